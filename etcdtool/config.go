@@ -0,0 +1,465 @@
+package etcdtool
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat 配置的编码格式 ，同时用于解析单个value 和绑定结构体
+type ConfigFormat struct {
+	Name      string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var (
+	// ConfigJSON 结构体使用 json tag
+	ConfigJSON = ConfigFormat{Name: "json", Marshal: json.Marshal, Unmarshal: json.Unmarshal}
+	// ConfigYAML 结构体使用 yaml tag
+	ConfigYAML = ConfigFormat{Name: "yaml", Marshal: yaml.Marshal, Unmarshal: yaml.Unmarshal}
+)
+
+// ConfigValidator 配置结构体实现该接口时 ，每次更新前校验 ，校验失败保留旧配置
+type ConfigValidator interface {
+	Validate() error
+}
+
+// ConfigChange 一个key 的变化 ，Key 为去掉前缀后的相对路径 ，新增时 Old 为空 ，删除时 New 为空
+type ConfigChange struct {
+	Key string
+	Old string
+	New string
+}
+
+type configOptions struct {
+	format        ConfigFormat
+	snapshotPath  string
+	retryInterval time.Duration
+	errFunc       func(err error)
+}
+
+type ConfigOption func(o *configOptions)
+
+// WithConfigFormat 配置格式 ，默认 ConfigJSON
+func WithConfigFormat(format ConfigFormat) ConfigOption {
+	return func(o *configOptions) {
+		if format.Marshal != nil && format.Unmarshal != nil {
+			o.format = format
+		}
+	}
+}
+
+// WithConfigSnapshot 每次更新成功后把原始数据写入本地文件 ，启动时etcd 不可用则从文件加载
+func WithConfigSnapshot(path string) ConfigOption {
+	return func(o *configOptions) {
+		o.snapshotPath = path
+	}
+}
+
+// WithConfigRetryInterval 启动时etcd 不可用 ，后台重连的间隔
+func WithConfigRetryInterval(interval time.Duration) ConfigOption {
+	return func(o *configOptions) {
+		if interval > 0 {
+			o.retryInterval = interval
+		}
+	}
+}
+
+// WithConfigErrFunc 后台更新失败(解析、校验、写快照)时回调
+func WithConfigErrFunc(fn func(err error)) ConfigOption {
+	return func(o *configOptions) {
+		o.errFunc = fn
+	}
+}
+
+// Config 动态配置 ，把一个前缀绑定到结构体 T
+// 前缀本身的value 作为完整文档 ，前缀下的子key 按路径覆盖字段 ，例如 prefix/limit/qps = 100 对应 {"limit":{"qps":100}}
+// prefix 建议以 "/" 结尾 ，避免匹配到其他前缀相同的配置
+type Config[T any] struct {
+	configOptions
+	tool   *EtcdTool
+	prefix string
+
+	value atomic.Pointer[T]
+
+	mu       sync.Mutex
+	raw      map[string]string // 当前配置对应的原始数据 ，相对key -> 原始value
+	revision int64
+	// etcd 中的最新数据 ，校验失败时也会前进 ，后续事件在它的基础上应用
+	latest         map[string]string
+	latestRevision int64
+	subs           map[int]func(old, new *T, changes []ConfigChange)
+	subSeq         int
+}
+
+// NewConfig 创建动态配置 ，Start 之后通过 Get 读取
+func NewConfig[T any](tool *EtcdTool, prefix string, opts ...ConfigOption) *Config[T] {
+	c := &Config[T]{
+		configOptions: configOptions{
+			format:        ConfigJSON,
+			retryInterval: DefaultWatchRetryInterval,
+		},
+		tool:   tool,
+		prefix: prefix,
+		raw:    make(map[string]string),
+		latest: make(map[string]string),
+		subs:   make(map[int]func(old, new *T, changes []ConfigChange), 2),
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&c.configOptions)
+	}
+	return c
+}
+
+// Start 加载配置并监听变化 ，etcd 不可用时从本地快照加载并在后台重连
+// etcd 和快照都不可用时返回err
+func (c *Config[T]) Start(ctx context.Context) (err error) {
+	if ctx == nil || c.prefix == "" || c.tool == nil || c.tool.Tool == nil {
+		err = errors.Errorf("Config_Start_err args err prefix = %s , ctx = %+v", c.prefix, ctx)
+		return
+	}
+	if err = c.loadAndWatch(ctx); err == nil {
+		return
+	}
+	if c.snapshotPath == "" {
+		return
+	}
+	if e := c.loadSnapshot(); e != nil {
+		err = errors.Wrapf(err, "Config_Start_err snapshot err %+v", e)
+		return
+	}
+	err = nil
+	go c.retryStart(ctx)
+	return
+}
+
+func (c *Config[T]) retryStart(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(c.retryInterval):
+		case <-ctx.Done():
+			return
+		}
+		if err := c.loadAndWatch(ctx); err == nil {
+			return
+		}
+	}
+}
+
+func (c *Config[T]) loadAndWatch(ctx context.Context) (err error) {
+	kvs, revision, err := c.tool.GetPrefixKVs(ctx, c.prefix)
+	if err != nil {
+		return
+	}
+	datas := make(map[string]string, len(kvs))
+	for i := 0; i < len(kvs); i++ {
+		datas[kvs[i].Key] = kvs[i].Value
+	}
+	if err = c.apply(c.relative(datas), revision); err != nil {
+		return
+	}
+	_, err = c.tool.Watch(ctx, c.prefix, nil,
+		WithWatchPrefix(),
+		WithWatchEvents(c.onEvents),
+		WithWatchRevision(revision),
+		WithWatchResync(c.resync),
+		WithWatchRetryInterval(c.retryInterval),
+	)
+	return
+}
+
+// etcd key 转为相对前缀的路径
+func (c *Config[T]) relKey(key string) string {
+	return strings.Trim(strings.TrimPrefix(key, c.prefix), "/")
+}
+
+func (c *Config[T]) relative(datas map[string]string) map[string]string {
+	raw := make(map[string]string, len(datas))
+	for k, v := range datas {
+		raw[c.relKey(k)] = v
+	}
+	return raw
+}
+
+func (c *Config[T]) resync(datas map[string]string, revision int64) {
+	c.report(c.apply(c.relative(datas), revision))
+}
+
+// 一个watch 响应中的事件一起应用 ，revision 为响应的revision
+// 事件应用在etcd 的最新数据上 ，而不是当前配置上 ，被拒绝的更新修正后可以整体重新校验
+func (c *Config[T]) onEvents(events []WatchEvent, revision int64) {
+	c.mu.Lock()
+	if revision < c.latestRevision {
+		c.mu.Unlock()
+		return
+	}
+	raw := make(map[string]string, len(c.latest)+len(events))
+	for k, v := range c.latest {
+		raw[k] = v
+	}
+	c.mu.Unlock()
+	for i := 0; i < len(events); i++ {
+		if events[i].Status == EtcdKeyDelete {
+			delete(raw, c.relKey(events[i].Key))
+		} else {
+			raw[c.relKey(events[i].Key)] = events[i].Value
+		}
+	}
+	c.report(c.apply(raw, revision))
+}
+
+// 记录etcd 的最新数据 ，解析完整数据 ，校验通过后替换当前配置并通知订阅者
+func (c *Config[T]) apply(raw map[string]string, revision int64) (err error) {
+	c.mu.Lock()
+	c.latest = raw
+	c.latestRevision = revision
+	c.mu.Unlock()
+	value, err := decodeConfig[T](raw, c.format)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	changes := diffConfig(c.raw, raw)
+	old := c.value.Load()
+	if old != nil && len(changes) == 0 {
+		c.revision = revision
+		c.mu.Unlock()
+		return
+	}
+	c.raw = raw
+	c.revision = revision
+	c.value.Store(value)
+	subs := make([]func(old, new *T, changes []ConfigChange), 0, len(c.subs))
+	for _, fn := range c.subs {
+		subs = append(subs, fn)
+	}
+	c.mu.Unlock()
+	if revision > 0 {
+		c.report(c.saveSnapshot(raw))
+	}
+	for i := 0; i < len(subs); i++ {
+		subs[i](old, value, changes)
+	}
+	return
+}
+
+func (c *Config[T]) report(err error) {
+	if err != nil && c.errFunc != nil {
+		c.errFunc(err)
+	}
+}
+
+// Get 当前配置 ，未加载时返回nil ，返回值不要修改
+func (c *Config[T]) Get() *T {
+	return c.value.Load()
+}
+
+// Revision 当前配置对应的etcd revision ，从本地快照加载时为0
+func (c *Config[T]) Revision() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.revision
+}
+
+// Subscribe 订阅配置变化 ，返回取消订阅函数
+func (c *Config[T]) Subscribe(fn func(old, new *T, changes []ConfigChange)) (cancel func()) {
+	if fn == nil {
+		return func() {}
+	}
+	c.mu.Lock()
+	c.subSeq++
+	id := c.subSeq
+	c.subs[id] = fn
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		delete(c.subs, id)
+		c.mu.Unlock()
+	}
+}
+
+func (c *Config[T]) saveSnapshot(raw map[string]string) (err error) {
+	if c.snapshotPath == "" {
+		return
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	// 先写临时文件再重命名 ，避免进程退出时文件不完整
+	tmp := c.snapshotPath + ".tmp"
+	if err = os.MkdirAll(filepath.Dir(c.snapshotPath), 0755); err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	if err = os.Rename(tmp, c.snapshotPath); err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	return
+}
+
+func (c *Config[T]) loadSnapshot() (err error) {
+	data, err := os.ReadFile(c.snapshotPath)
+	if err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	raw := make(map[string]string)
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return errors.Wrapf(err, "Config_snapshot_err")
+	}
+	return c.apply(raw, 0)
+}
+
+// 原始数据组装成文档后绑定到结构体
+func decodeConfig[T any](raw map[string]string, format ConfigFormat) (value *T, err error) {
+	doc := make(map[string]interface{}, len(raw))
+	// 前缀本身的value 是完整文档
+	if base, ok := raw[""]; ok && base != "" {
+		if err = format.Unmarshal([]byte(base), &doc); err != nil {
+			err = errors.Wrapf(err, "Config_decode_err base document")
+			return
+		}
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	// 短路径先写入 ，长路径覆盖
+	sort.Strings(keys)
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < len(keys); i++ {
+		path := strings.Split(keys[i], "/")
+		setConfigPath(doc, path, decodeConfigValue(raw[keys[i]], format, configFieldType(typ, path, format.Name)))
+	}
+	data, err := format.Marshal(doc)
+	if err != nil {
+		err = errors.Wrapf(err, "Config_decode_err")
+		return
+	}
+	value = new(T)
+	if err = format.Unmarshal(data, value); err != nil {
+		err = errors.Wrapf(err, "Config_decode_err")
+		return
+	}
+	if v, ok := interface{}(value).(ConfigValidator); ok {
+		if err = v.Validate(); err != nil {
+			err = errors.Wrapf(err, "Config_validate_err")
+			value = nil
+			return
+		}
+	}
+	return
+}
+
+// 单个value 按格式解析 ，解析失败按字符串处理
+// 目标字段是字符串时保留原始value ，避免 "123"、"true" 被解析成数字、布尔值后绑定失败
+func decodeConfigValue(value string, format ConfigFormat, typ reflect.Type) interface{} {
+	if typ != nil && typ.Kind() == reflect.String {
+		return value
+	}
+	var v interface{}
+	if err := format.Unmarshal([]byte(value), &v); err != nil || v == nil {
+		return value
+	}
+	return v
+}
+
+// 按路径查找结构体字段的类型 ，tag 为 json 或 yaml ，找不到返回nil
+func configFieldType(typ reflect.Type, path []string, tag string) reflect.Type {
+	for i := 0; i < len(path) && typ != nil; i++ {
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		switch typ.Kind() {
+		case reflect.Struct:
+			typ = configStructField(typ, path[i], tag)
+		case reflect.Map:
+			typ = typ.Elem()
+		default:
+			return nil
+		}
+	}
+	if typ != nil {
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+	}
+	return typ
+}
+
+// 按tag 名称查找字段 ，没有tag 时和字段名比较 ，匿名结构体字段展开查找
+func configStructField(typ reflect.Type, name, tag string) reflect.Type {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tagName := strings.Split(field.Tag.Get(tag), ",")[0]
+		if tagName == "-" {
+			continue
+		}
+		if tagName == "" && field.Anonymous {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if t := configStructField(ft, name, tag); t != nil {
+					return t
+				}
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if tagName == "" {
+			tagName = field.Name
+		}
+		if strings.EqualFold(tagName, name) {
+			return field.Type
+		}
+	}
+	return nil
+}
+
+func setConfigPath(doc map[string]interface{}, path []string, value interface{}) {
+	for i := 0; i < len(path)-1; i++ {
+		next, ok := doc[path[i]].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[path[i]] = next
+		}
+		doc = next
+	}
+	doc[path[len(path)-1]] = value
+}
+
+// 按key 比较两份原始数据 ，结果按key 排序
+func diffConfig(old, new map[string]string) []ConfigChange {
+	changes := make([]ConfigChange, 0, 2)
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || ov != v {
+			changes = append(changes, ConfigChange{Key: k, Old: old[k], New: v})
+		}
+	}
+	for k, v := range old {
+		if _, ok := new[k]; !ok {
+			changes = append(changes, ConfigChange{Key: k, Old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
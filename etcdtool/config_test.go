@@ -0,0 +1,187 @@
+package etcdtool
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testLimit struct {
+	QPS   int `json:"qps" yaml:"qps"`
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+type testConfig struct {
+	Topic   string            `json:"topic" yaml:"topic"`
+	Enabled bool              `json:"enabled" yaml:"enabled"`
+	Limit   testLimit         `json:"limit" yaml:"limit"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+func (c *testConfig) Validate() error {
+	if c.Limit.QPS < 0 {
+		return errors.New("qps must not be negative")
+	}
+	return nil
+}
+
+func TestDecodeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  ConfigFormat
+		raw     map[string]string
+		want    testConfig
+		wantErr bool
+	}{
+		{
+			name:   "json base document",
+			format: ConfigJSON,
+			raw:    map[string]string{"": `{"topic":"im-msg","limit":{"qps":10,"burst":20}}`},
+			want:   testConfig{Topic: "im-msg", Limit: testLimit{QPS: 10, Burst: 20}},
+		},
+		{
+			name:   "json keys override base",
+			format: ConfigJSON,
+			raw: map[string]string{
+				"":           `{"topic":"im-msg","limit":{"qps":10,"burst":20}}`,
+				"topic":      "im-msg-v2",
+				"enabled":    "true",
+				"limit/qps":  "100",
+				"limit":      `{"burst":5}`,
+				"limit/none": "ignored",
+			},
+			want: testConfig{Topic: "im-msg-v2", Enabled: true, Limit: testLimit{QPS: 100, Burst: 5}},
+		},
+		{
+			name:   "yaml",
+			format: ConfigYAML,
+			raw: map[string]string{
+				"":          "topic: im-push\nlimit:\n  qps: 3\n",
+				"limit/qps": "7",
+				"enabled":   "yes-not-bool",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "yaml keys",
+			format: ConfigYAML,
+			raw: map[string]string{
+				"":          "topic: im-push\nlimit:\n  qps: 3\n",
+				"limit/qps": "7",
+				"enabled":   "true",
+			},
+			want: testConfig{Topic: "im-push", Enabled: true, Limit: testLimit{QPS: 7}},
+		},
+		{
+			name:   "string field keeps raw value",
+			format: ConfigJSON,
+			raw: map[string]string{
+				"topic":     "123",
+				"limit/qps": "8",
+				"labels/id": "007",
+			},
+			want: testConfig{Topic: "123", Limit: testLimit{QPS: 8}, Labels: map[string]string{"id": "007"}},
+		},
+		{
+			name:   "yaml string field keeps raw value",
+			format: ConfigYAML,
+			raw:    map[string]string{"topic": "true"},
+			want:   testConfig{Topic: "true"},
+		},
+		{
+			name:    "validate failed",
+			format:  ConfigJSON,
+			raw:     map[string]string{"limit/qps": "-1"},
+			wantErr: true,
+		},
+		{
+			name:   "empty",
+			format: ConfigJSON,
+			raw:    map[string]string{},
+			want:   testConfig{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeConfig[testConfig](tt.raw, tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want err , got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeConfig err %+v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Fatalf("got %+v , want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	old := map[string]string{"a": "1", "b": "2", "c": "3"}
+	new := map[string]string{"a": "1", "b": "20", "d": "4"}
+	want := []ConfigChange{
+		{Key: "b", Old: "2", New: "20"},
+		{Key: "c", Old: "3"},
+		{Key: "d", New: "4"},
+	}
+	if got := diffConfig(old, new); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v , want %+v", got, want)
+	}
+	if got := diffConfig(old, old); len(got) != 0 {
+		t.Fatalf("want no changes , got %+v", got)
+	}
+}
+
+func TestConfig_OnEvents(t *testing.T) {
+	c := NewConfig[testConfig](nil, "/im/config/")
+	var changes [][]ConfigChange
+	c.Subscribe(func(old, new *testConfig, cs []ConfigChange) { changes = append(changes, cs) })
+
+	// 一个响应中的事件一起应用 ，revision 使用响应的revision
+	c.onEvents([]WatchEvent{
+		{Status: EtcdKeyCreate, Key: "/im/config/topic", Value: "im-msg"},
+		{Status: EtcdKeyCreate, Key: "/im/config/limit/qps", Value: "10"},
+	}, 12)
+	if got := c.Get(); got == nil || got.Topic != "im-msg" || got.Limit.QPS != 10 {
+		t.Fatalf("config = %+v", got)
+	}
+	if c.Revision() != 12 || len(changes) != 1 || len(changes[0]) != 2 {
+		t.Fatalf("revision = %d , changes = %+v", c.Revision(), changes)
+	}
+
+	c.onEvents([]WatchEvent{{Status: EtcdKeyDelete, Key: "/im/config/limit/qps"}}, 15)
+	if got := c.Get(); got.Limit.QPS != 0 || c.Revision() != 15 {
+		t.Fatalf("config = %+v , revision = %d", got, c.Revision())
+	}
+}
+
+func TestConfig_RejectThenFix(t *testing.T) {
+	c := NewConfig[testConfig](nil, "/im/config/")
+	var errs []error
+	c.errFunc = func(err error) { errs = append(errs, err) }
+	c.onEvents([]WatchEvent{
+		{Status: EtcdKeyCreate, Key: "/im/config/topic", Value: "im-msg"},
+		{Status: EtcdKeyCreate, Key: "/im/config/limit/qps", Value: "10"},
+	}, 2)
+
+	// 校验失败保留旧配置
+	c.onEvents([]WatchEvent{{Status: EtcdKeyModify, Key: "/im/config/limit/qps", Value: "-1"}}, 3)
+	if got := c.Get(); got.Limit.QPS != 10 || c.Revision() != 2 || len(errs) != 1 {
+		t.Fatalf("config = %+v , revision = %d , errs = %v", got, c.Revision(), errs)
+	}
+	// 后续事件在etcd 的最新数据上应用 ，qps 仍然是 -1 ，继续拒绝
+	c.onEvents([]WatchEvent{{Status: EtcdKeyCreate, Key: "/im/config/limit/burst", Value: "200"}}, 4)
+	if got := c.Get(); got.Limit.Burst != 0 || c.Revision() != 2 || len(errs) != 2 {
+		t.Fatalf("config = %+v , revision = %d , errs = %v", got, c.Revision(), errs)
+	}
+	// 修正后整体生效
+	c.onEvents([]WatchEvent{{Status: EtcdKeyModify, Key: "/im/config/limit/qps", Value: "100"}}, 5)
+	want := testConfig{Topic: "im-msg", Limit: testLimit{QPS: 100, Burst: 200}}
+	if got := c.Get(); !reflect.DeepEqual(*got, want) || c.Revision() != 5 || len(errs) != 2 {
+		t.Fatalf("config = %+v , revision = %d , errs = %v", got, c.Revision(), errs)
+	}
+}
@@ -0,0 +1,272 @@
+package etcdtool
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNoInstance = errors.New("no available instance")
+)
+
+// InstancesChangeFunc 实例列表变化回调，instances 为变化后的全量实例
+type InstancesChangeFunc func(instances []*ServiceInstance)
+
+// Picker 根据key 从实例列表中选择一个实例
+type Picker func(key string, instances []*ServiceInstance) (*ServiceInstance, error)
+
+// WeightRandomPicker 按权重随机选择，权重<=0 按1计算
+func WeightRandomPicker(key string, instances []*ServiceInstance) (*ServiceInstance, error) {
+	if len(instances) == 0 {
+		return nil, ErrNoInstance
+	}
+	total := 0
+	for i := 0; i < len(instances); i++ {
+		total += instanceWeight(instances[i])
+	}
+	n := rand.Intn(total)
+	for i := 0; i < len(instances); i++ {
+		n -= instanceWeight(instances[i])
+		if n < 0 {
+			return instances[i], nil
+		}
+	}
+	return instances[len(instances)-1], nil
+}
+
+func instanceWeight(ins *ServiceInstance) int {
+	if ins.Weight <= 0 {
+		return 1
+	}
+	return ins.Weight
+}
+
+type DiscoveryOption func(d *Discovery)
+
+// Discovery 服务发现，本地缓存某个服务的全量实例
+// 先按前缀读取一次全量数据并记录revision，然后从 revision+1 开始监听，不会丢失事件
+type Discovery struct {
+	tool          *EtcdTool
+	prefix        string
+	name          string
+	retryInterval time.Duration
+	picker        Picker
+
+	// 保证订阅者按顺序收到实例列表 ，Subscribe 的首次回调和后续变化不会交错
+	notifyMu  sync.Mutex
+	mu        sync.RWMutex
+	instances map[string]*ServiceInstance // key: etcd key
+	list      []*ServiceInstance          // 按id排序后的实例列表
+	revision  int64
+	watcher   *Watcher
+	subs      map[int]InstancesChangeFunc
+	subSeq    int
+}
+
+// WithDiscoveryPrefix 注册前缀，需要和 Registry 保持一致，默认 DefaultRegistryPrefix
+func WithDiscoveryPrefix(prefix string) DiscoveryOption {
+	return func(d *Discovery) {
+		if prefix != "" {
+			d.prefix = prefix
+		}
+	}
+}
+
+// WithDiscoveryPicker 设置 Pick 使用的选择策略，默认 WeightRandomPicker
+func WithDiscoveryPicker(picker Picker) DiscoveryOption {
+	return func(d *Discovery) {
+		if picker != nil {
+			d.picker = picker
+		}
+	}
+}
+
+// WithDiscoveryRetryInterval 监听断开后重新同步的间隔
+func WithDiscoveryRetryInterval(interval time.Duration) DiscoveryOption {
+	return func(d *Discovery) {
+		if interval > 0 {
+			d.retryInterval = interval
+		}
+	}
+}
+
+func NewDiscovery(tool *EtcdTool, name string, opts ...DiscoveryOption) *Discovery {
+	d := &Discovery{
+		tool:          tool,
+		name:          name,
+		prefix:        DefaultRegistryPrefix,
+		retryInterval: DefaultRegistryRetryInterval,
+		picker:        WeightRandomPicker,
+		instances:     make(map[string]*ServiceInstance, 10),
+		subs:          make(map[int]InstancesChangeFunc, 2),
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](d)
+	}
+	return d
+}
+
+// Start 同步读取一次全量实例后在后台监听变化，ctx 结束停止监听
+func (d *Discovery) Start(ctx context.Context) (err error) {
+	if ctx == nil || d.name == "" || d.tool == nil || d.tool.Tool == nil {
+		err = errors.Errorf("Discovery_Start_err args err name = %s , ctx = %+v", d.name, ctx)
+		return
+	}
+	if err = d.load(ctx); err != nil {
+		return
+	}
+	err = d.watch(ctx)
+	return
+}
+
+func (d *Discovery) servicePrefix() string {
+	return ServicePrefix(d.prefix, d.name)
+}
+
+// 全量读取实例，替换本地缓存
+func (d *Discovery) load(ctx context.Context) (err error) {
+	kvs, revision, err := d.tool.GetPrefixKVs(ctx, d.servicePrefix())
+	if err != nil {
+		err = errors.Wrapf(err, "Discovery_load_err name = %s", d.name)
+		return
+	}
+	datas := make(map[string]string, len(kvs))
+	for i := 0; i < len(kvs); i++ {
+		datas[kvs[i].Key] = kvs[i].Value
+	}
+	d.resync(datas, revision)
+	return
+}
+
+// 从全量数据的 revision+1 开始监听 ，压缩后由 resync 替换本地缓存
+func (d *Discovery) watch(ctx context.Context) (err error) {
+	w, err := d.tool.Watch(ctx, d.servicePrefix(), nil,
+		WithWatchPrefix(),
+		WithWatchEvents(d.onEvents),
+		WithWatchRevision(d.Revision()),
+		WithWatchResync(d.resync),
+		WithWatchRetryInterval(d.retryInterval),
+	)
+	if err != nil {
+		err = errors.Wrapf(err, "Discovery_watch_err name = %s", d.name)
+		return
+	}
+	d.mu.Lock()
+	d.watcher = w
+	d.mu.Unlock()
+	return
+}
+
+func (d *Discovery) resync(datas map[string]string, revision int64) {
+	instances := make(map[string]*ServiceInstance, len(datas))
+	for key, value := range datas {
+		ins, err := DecodeInstance([]byte(value))
+		if err != nil {
+			continue
+		}
+		instances[key] = ins
+	}
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	d.mu.Lock()
+	d.instances = instances
+	d.revision = revision
+	d.notify()
+}
+
+// 一个监听响应中的全部事件合并为一次更新
+func (d *Discovery) onEvents(events []WatchEvent, revision int64) {
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	d.mu.Lock()
+	changed := false
+	for i := 0; i < len(events); i++ {
+		if events[i].Status == EtcdKeyDelete {
+			if _, ok := d.instances[events[i].Key]; ok {
+				delete(d.instances, events[i].Key)
+				changed = true
+			}
+			continue
+		}
+		ins, err := DecodeInstance([]byte(events[i].Value))
+		if err != nil {
+			continue
+		}
+		d.instances[events[i].Key] = ins
+		changed = true
+	}
+	d.revision = revision
+	if !changed {
+		d.mu.Unlock()
+		return
+	}
+	d.notify()
+}
+
+// 持有 notifyMu 和 mu 时调用 ，重建实例列表后释放 mu 并通知订阅者
+func (d *Discovery) notify() {
+	list := make([]*ServiceInstance, 0, len(d.instances))
+	for _, ins := range d.instances {
+		list = append(list, ins)
+	}
+	sort.Slice(list, func(i, j int) bool { return strings.Compare(list[i].ID, list[j].ID) < 0 })
+	d.list = list
+	subs := make([]InstancesChangeFunc, 0, len(d.subs))
+	for _, fn := range d.subs {
+		subs = append(subs, fn)
+	}
+	d.mu.Unlock()
+	for i := 0; i < len(subs); i++ {
+		subs[i](list)
+	}
+}
+
+// Instances 当前实例快照，按id排序，返回的切片不要修改
+func (d *Discovery) Instances() []*ServiceInstance {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.list
+}
+
+// Revision 本地缓存对应的etcd revision
+func (d *Discovery) Revision() int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.watcher != nil {
+		return d.watcher.Revision()
+	}
+	return d.revision
+}
+
+// Subscribe 订阅实例变化，订阅时会立即回调一次当前实例，返回取消订阅函数
+// 回调按变化顺序串行执行 ，回调中不要再调用 Subscribe
+func (d *Discovery) Subscribe(fn InstancesChangeFunc) (cancel func()) {
+	if fn == nil {
+		return func() {}
+	}
+	d.notifyMu.Lock()
+	defer d.notifyMu.Unlock()
+	d.mu.Lock()
+	d.subSeq++
+	id := d.subSeq
+	d.subs[id] = fn
+	list := d.list
+	d.mu.Unlock()
+	fn(list)
+	return func() {
+		d.mu.Lock()
+		delete(d.subs, id)
+		d.mu.Unlock()
+	}
+}
+
+// Pick 使用picker 选择一个实例
+func (d *Discovery) Pick(key string) (*ServiceInstance, error) {
+	return d.picker(key, d.Instances())
+}
@@ -0,0 +1,85 @@
+package etcdtool
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func instanceEvent(status, id string) WatchEvent {
+	key := ServiceKey(DefaultRegistryPrefix, &ServiceInstance{Name: "im-gateway", ID: id})
+	if status == EtcdKeyDelete {
+		return WatchEvent{Status: status, Key: key}
+	}
+	value, _ := EncodeInstance(&ServiceInstance{Name: "im-gateway", ID: id, Addr: id + ":8080"})
+	return WatchEvent{Status: status, Key: key, Value: value}
+}
+
+func TestDiscovery_OnEvents(t *testing.T) {
+	d := NewDiscovery(nil, "im-gateway")
+	var calls [][]*ServiceInstance
+	d.Subscribe(func(instances []*ServiceInstance) { calls = append(calls, instances) })
+	if len(calls) != 1 || len(calls[0]) != 0 {
+		t.Fatalf("initial calls = %+v", calls)
+	}
+
+	// 一个响应中的多个事件只通知一次
+	d.onEvents([]WatchEvent{
+		instanceEvent(EtcdKeyCreate, "b"),
+		instanceEvent(EtcdKeyCreate, "a"),
+		instanceEvent(EtcdKeyCreate, "c"),
+		instanceEvent(EtcdKeyDelete, "c"),
+		{Status: EtcdKeyCreate, Key: "/services/im-gateway/bad", Value: "not json"},
+	}, 10)
+	if len(calls) != 2 {
+		t.Fatalf("calls = %d , want 2", len(calls))
+	}
+	if got := calls[1]; len(got) != 2 || got[0].ID != "a" || got[1].ID != "b" {
+		t.Fatalf("instances = %+v", got)
+	}
+	if d.Revision() != 10 {
+		t.Fatalf("revision = %d", d.Revision())
+	}
+
+	// 没有变化不通知
+	d.onEvents([]WatchEvent{instanceEvent(EtcdKeyDelete, "x")}, 11)
+	if len(calls) != 2 || d.Revision() != 11 {
+		t.Fatalf("calls = %d , revision = %d", len(calls), d.Revision())
+	}
+
+	d.resync(map[string]string{instanceEvent(EtcdKeyCreate, "z").Key: instanceEvent(EtcdKeyCreate, "z").Value}, 20)
+	if len(calls) != 3 || len(calls[2]) != 1 || calls[2][0].ID != "z" || d.Revision() != 20 {
+		t.Fatalf("resync calls = %+v", calls)
+	}
+	if ins, err := d.Pick("any"); err != nil || ins.ID != "z" {
+		t.Fatalf("Pick = %+v %v", ins, err)
+	}
+}
+
+// 并发订阅时首次回调和后续变化不会乱序
+func TestDiscovery_SubscribeOrder(t *testing.T) {
+	d := NewDiscovery(nil, "im-gateway")
+	const n = 200
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			d.onEvents([]WatchEvent{instanceEvent(EtcdKeyCreate, strconv.Itoa(i))}, int64(i+1))
+		}
+	}()
+	for s := 0; s < 20; s++ {
+		last := -1
+		cancel := d.Subscribe(func(instances []*ServiceInstance) {
+			if len(instances) <= last {
+				t.Errorf("out of order %d after %d", len(instances), last)
+			}
+			last = len(instances)
+		})
+		defer cancel()
+	}
+	wg.Wait()
+	if len(d.Instances()) != n {
+		t.Fatalf("instances = %d", len(d.Instances()))
+	}
+}
@@ -0,0 +1,267 @@
+package etcdtool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	electionPrefix = "etcd_election_"
+	// 默认选举会话租约时间 秒 ，leader 异常退出后最多 ttl 秒重新选举
+	DefaultElectionTTL = 5
+	// 默认选举失败后的重试间隔
+	DefaultElectionRetryInterval = time.Second
+)
+
+var (
+	// ErrNoLeader 当前没有leader
+	ErrNoLeader = errors.New("etcd election has no leader")
+)
+
+type ElectionOption func(e *Election)
+
+// WithElectionTTL 选举会话租约时间 ，单位秒
+func WithElectionTTL(ttl int) ElectionOption {
+	return func(e *Election) {
+		if ttl > 0 {
+			e.ttl = ttl
+		}
+	}
+}
+
+// WithElectionRetryInterval 选举失败或者会话过期后重新竞选的间隔
+func WithElectionRetryInterval(interval time.Duration) ElectionOption {
+	return func(e *Election) {
+		if interval > 0 {
+			e.retryInterval = interval
+		}
+	}
+}
+
+// WithOnElected 当选后在新的协程中回调 ，ctx 在失去leader 身份时取消 ，任务应该在ctx 结束后退出
+func WithOnElected(fn func(ctx context.Context)) ElectionOption {
+	return func(e *Election) {
+		e.onElected = fn
+	}
+}
+
+// WithOnRevoked 失去leader 身份时回调 ，包括主动退出、会话过期
+func WithOnRevoked(fn func()) ElectionOption {
+	return func(e *Election) {
+		e.onRevoked = fn
+	}
+}
+
+// Election leader 选举 ，保证后台任务只在一个副本上运行
+// 和 EtcdLock 一样基于 concurrency 会话 ，会话过期后自动重新竞选
+type Election struct {
+	tool          *EtcdTool
+	prefix        string
+	value         string
+	ttl           int
+	retryInterval time.Duration
+	onElected     func(ctx context.Context)
+	onRevoked     func()
+
+	isLeader int32
+	mu       sync.Mutex
+	resignCh chan struct{}
+}
+
+// NewElection name 选举名称 ，value 当前节点标识 ，当选后其他节点通过 Leader 获取
+func (etcd *EtcdTool) NewElection(name, value string, opts ...ElectionOption) (e *Election, err error) {
+	if name == "" || value == "" || etcd.Tool == nil {
+		err = errors.Errorf("NewElection_err args err name = %s , value = %s", name, value)
+		return
+	}
+	e = &Election{
+		tool:          etcd,
+		prefix:        electionPrefix + name,
+		value:         value,
+		ttl:           DefaultElectionTTL,
+		retryInterval: DefaultElectionRetryInterval,
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](e)
+	}
+	return
+}
+
+// Run 持续参与竞选直到ctx 结束 ，结束时如果是leader 会主动退出 ，阻塞调用
+func (e *Election) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if !e.campaign(ctx) {
+			select {
+			case <-time.After(e.retryInterval):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// 一轮竞选 ，返回false 表示需要等待后重试
+func (e *Election) campaign(ctx context.Context) bool {
+	ss, err := concurrency.NewSession(e.tool.Tool, concurrency.WithTTL(e.ttl), concurrency.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	defer ss.Close()
+	el := concurrency.NewElection(ss, e.prefix)
+	// 等待期间会话过期需要取消竞选
+	cctx, ccancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ss.Done():
+			ccancel()
+		case <-cctx.Done():
+		}
+	}()
+	err = el.Campaign(cctx, e.value)
+	ccancel()
+	if err != nil {
+		return false
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	resignCh := make(chan struct{})
+	e.mu.Lock()
+	e.resignCh = resignCh
+	e.mu.Unlock()
+	atomic.StoreInt32(&e.isLeader, 1)
+	if e.onElected != nil {
+		go e.onElected(leaderCtx)
+	}
+
+	lost := false
+	select {
+	case <-ss.Done():
+		lost = true
+	case <-ctx.Done():
+	case <-resignCh:
+	}
+	cancel()
+	atomic.StoreInt32(&e.isLeader, 0)
+	e.mu.Lock()
+	e.resignCh = nil
+	e.mu.Unlock()
+	if !lost {
+		rctx, rcancel := e.tool.withTimeout(context.Background())
+		_ = el.Resign(rctx)
+		rcancel()
+	}
+	if e.onRevoked != nil {
+		e.onRevoked()
+	}
+	return !lost
+}
+
+// IsLeader 当前节点是否是leader
+func (e *Election) IsLeader() bool {
+	return atomic.LoadInt32(&e.isLeader) == 1
+}
+
+// Resign 主动放弃leader 身份 ，Run 会重新排队竞选
+func (e *Election) Resign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.resignCh != nil {
+		close(e.resignCh)
+		e.resignCh = nil
+	}
+}
+
+// Leader 当前leader 的value ，没有leader 返回 ErrNoLeader
+func (e *Election) Leader(ctx context.Context) (value string, err error) {
+	_, value, _, err = e.leader(ctx)
+	return
+}
+
+// 创建revision 最小的key 是leader ，revision 为读取时的revision
+func (e *Election) leader(ctx context.Context) (key, value string, revision int64, err error) {
+	ctx, cancel := e.tool.withTimeout(ctx)
+	defer cancel()
+	resp, e2 := e.tool.Tool.Get(ctx, e.prefix+"/", clientv3.WithFirstCreate()...)
+	if e2 != nil {
+		err = errors.Wrapf(e2, "Leader_err")
+		return
+	}
+	revision = resp.Header.Revision
+	if len(resp.Kvs) == 0 {
+		err = ErrNoLeader
+		return
+	}
+	key, value = string(resp.Kvs[0].Key), string(resp.Kvs[0].Value)
+	return
+}
+
+// Observe 监听leader 变化 ，leader 变化时发送新的value ，没有leader 时发送空字符 ，ctx 结束后关闭通道
+// leader 由监听事件推导 ，只有leader 的key 被删除时才需要重新读取
+func (e *Election) Observe(ctx context.Context) (<-chan string, error) {
+	key, last, revision, err := e.leader(ctx)
+	if err != nil && err != ErrNoLeader {
+		return nil, errors.Wrapf(err, "Observe_err")
+	}
+	ch := make(chan string, 1)
+	ch <- last
+	// 以下状态只在监听协程中访问
+	stale := false
+	send := func(k, v string) {
+		key = k
+		if v == last {
+			return
+		}
+		last = v
+		select {
+		case ch <- v:
+		case <-ctx.Done():
+		}
+	}
+	refresh := func() {
+		k, v, _, err := e.leader(ctx)
+		if err != nil && err != ErrNoLeader {
+			// 下一个响应时重试
+			stale = true
+			return
+		}
+		stale = false
+		send(k, v)
+	}
+	onEvents := func(events []WatchEvent, revision int64) {
+		for i := 0; i < len(events) && !stale; i++ {
+			ev := events[i]
+			switch {
+			case ev.Key == key && ev.Status == EtcdKeyDelete:
+				// 下一个leader 需要按创建revision 读取 ，同一个响应中之后的事件一并包含在读取结果中
+				stale = true
+			case ev.Key == key:
+				send(key, ev.Value)
+			case key == "" && ev.Status == EtcdKeyCreate:
+				// 没有leader 时第一个创建的key 当选
+				send(ev.Key, ev.Value)
+			}
+		}
+		if stale {
+			refresh()
+		}
+	}
+	w, err := e.tool.Watch(ctx, e.prefix+"/", nil,
+		WithWatchPrefix(),
+		WithWatchRevision(revision),
+		WithWatchEvents(onEvents),
+		WithWatchResync(func(datas map[string]string, revision int64) { refresh() }),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Observe_err")
+	}
+	go func() {
+		<-w.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
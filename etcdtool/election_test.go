@@ -0,0 +1,211 @@
+package etcdtool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 记录选举回调
+type electionRecorder struct {
+	elected chan context.Context
+	revoked chan struct{}
+}
+
+func newElectionRecorder() *electionRecorder {
+	return &electionRecorder{elected: make(chan context.Context, 8), revoked: make(chan struct{}, 8)}
+}
+
+func (r *electionRecorder) opts() []ElectionOption {
+	return []ElectionOption{
+		WithElectionRetryInterval(10 * time.Millisecond),
+		WithOnElected(func(ctx context.Context) { r.elected <- ctx }),
+		WithOnRevoked(func() { r.revoked <- struct{}{} }),
+	}
+}
+
+func (r *electionRecorder) waitElected(t *testing.T) context.Context {
+	t.Helper()
+	select {
+	case ctx := <-r.elected:
+		return ctx
+	case <-time.After(2 * time.Second):
+		t.Fatal("not elected")
+	}
+	return nil
+}
+
+func (r *electionRecorder) waitRevoked(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.revoked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not revoked")
+	}
+}
+
+// 启动竞选 ，返回的函数停止竞选并等待 Run 退出
+func runElection(t *testing.T, e *Election) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		waitClosed(t, done, "Run not stopped")
+	}
+}
+
+func TestElection_Resign(t *testing.T) {
+	etcd := newFakeTool(newFakeKV())
+	r := newElectionRecorder()
+	e, err := etcd.NewElection("job", "node-a", r.opts()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.Leader(context.Background()); err != ErrNoLeader {
+		t.Fatalf("Leader err = %v , want ErrNoLeader", err)
+	}
+	stop := runElection(t, e)
+
+	leaderCtx := r.waitElected(t)
+	if !e.IsLeader() {
+		t.Fatal("IsLeader should be true after elected")
+	}
+	if value, _ := e.Leader(context.Background()); value != "node-a" {
+		t.Fatalf("leader = %s", value)
+	}
+
+	// 主动退出后取消任务 ，然后重新竞选
+	e.Resign()
+	waitClosed(t, leaderCtx.Done(), "leader ctx not cancelled after Resign")
+	r.waitRevoked(t)
+	r.waitElected(t)
+
+	stop()
+	r.waitRevoked(t)
+	if e.IsLeader() {
+		t.Fatal("IsLeader should be false after Run returns")
+	}
+	if _, err = e.Leader(context.Background()); err != ErrNoLeader {
+		t.Fatalf("Leader err = %v , want ErrNoLeader after stop", err)
+	}
+}
+
+func TestElection_SessionLost(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	ra, rb := newElectionRecorder(), newElectionRecorder()
+	a, _ := etcd.NewElection("job", "node-a", ra.opts()...)
+	b, _ := etcd.NewElection("job", "node-b", rb.opts()...)
+	stopA := runElection(t, a)
+	defer stopA()
+	leaderCtx := ra.waitElected(t)
+	stopB := runElection(t, b)
+	defer stopB()
+
+	// 等待 b 排队
+	deadline := time.Now().Add(2 * time.Second)
+	for len(f.keys(electionPrefix+"job/")) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("b not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-rb.elected:
+		t.Fatal("b elected while a is leader")
+	default:
+	}
+
+	// a 的会话过期 ，b 当选 ，a 重新排队
+	resp, err := etcd.Tool.Get(context.Background(), electionPrefix+"job/", clientv3.WithFirstCreate()...)
+	if err != nil || len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "node-a" {
+		t.Fatalf("resp = %+v , err = %v", resp, err)
+	}
+	f.expire(resp.Kvs[0].Lease)
+	waitClosed(t, leaderCtx.Done(), "leader ctx not cancelled after session lost")
+	ra.waitRevoked(t)
+	rb.waitElected(t)
+	if a.IsLeader() || !b.IsLeader() {
+		t.Fatalf("a.IsLeader = %v , b.IsLeader = %v", a.IsLeader(), b.IsLeader())
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for len(f.keys(electionPrefix+"job/")) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("a did not campaign again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if value, _ := a.Leader(context.Background()); value != "node-b" {
+		t.Fatalf("leader = %s , want node-b", value)
+	}
+}
+
+func TestElection_Observe(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	ra := newElectionRecorder()
+	a, _ := etcd.NewElection("job", "node-a", ra.opts()...)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := a.Observe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() string {
+		t.Helper()
+		select {
+		case value := <-ch:
+			return value
+		case <-time.After(2 * time.Second):
+			t.Fatal("no leader change")
+		}
+		return ""
+	}
+	if value := next(); value != "" {
+		t.Fatalf("initial leader = %q , want empty", value)
+	}
+
+	stopA := runElection(t, a)
+	defer stopA()
+	ra.waitElected(t)
+	if value := next(); value != "node-a" {
+		t.Fatalf("leader = %q , want node-a", value)
+	}
+
+	// 其他候选人排队不改变leader ，不发送 ，也不需要重新读取
+	ranges := f.rangeCount()
+	f.set(electionPrefix+"job/ffff", "node-x", 0)
+	select {
+	case value := <-ch:
+		t.Fatalf("unexpected leader %q", value)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := f.rangeCount() - ranges; n != 0 {
+		t.Fatalf("observe read %d times for a non-leader create", n)
+	}
+
+	// leader 退出后切换到下一个候选人
+	a.Resign()
+	ra.waitRevoked(t)
+	if value := next(); value != "node-x" {
+		t.Fatalf("leader = %q , want node-x", value)
+	}
+
+	cancel()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("observe channel not closed after ctx done")
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package etcdtool
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+// fakeKV 内存中的 KV 、租约和监听服务 ，只实现测试用到的部分 ，不需要启动 etcd
+type fakeKV struct {
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]*mvccpb.KeyValue
+	// 每次事务执行前调用 ，可以模拟其他客户端并发写入
+	beforeTxn func(f *fakeKV)
+	txns      int
+	ranges    int
+
+	leases     map[int64]int64 // 租约id -> ttl
+	leaseSeq   int64
+	keepAlives map[*fakeKeepAliveStream]struct{}
+
+	history   []*mvccpb.Event
+	compacted int64
+	watches   map[*fakeWatchStream]struct{}
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{
+		revision:   1,
+		kvs:        make(map[string]*mvccpb.KeyValue),
+		leases:     make(map[int64]int64),
+		keepAlives: make(map[*fakeKeepAliveStream]struct{}),
+		watches:    make(map[*fakeWatchStream]struct{}),
+	}
+}
+
+// newFakeTool 使用 fakeKV 的 EtcdTool
+func newFakeTool(f *fakeKV, opts ...Option) *EtcdTool {
+	cli := clientv3.NewCtxClient(context.Background())
+	cli.KV = clientv3.NewKVFromKVClient(f, cli)
+	cli.Lease = clientv3.NewLeaseFromLeaseClient(f, cli, time.Second)
+	cli.Watcher = clientv3.NewWatchFromWatchClient(f, cli)
+	return New(cli, opts...)
+}
+
+// set 直接写入 ，模拟其他客户端
+func (f *fakeKV) set(key, value string, lease int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.put(&pb.PutRequest{Key: []byte(key), Value: []byte(value), Lease: lease})
+}
+
+func (f *fakeKV) get(key string) *mvccpb.KeyValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.kvs[key]
+}
+
+func (f *fakeKV) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: f.revision}
+}
+
+// 按key 排序返回 [key, end) 范围内的数据 ，end 为空时只匹配key
+func (f *fakeKV) match(key, end []byte) []*mvccpb.KeyValue {
+	var kvs []*mvccpb.KeyValue
+	for k, kv := range f.kvs {
+		kb := []byte(k)
+		if len(end) == 0 {
+			if bytes.Equal(kb, key) {
+				kvs = append(kvs, kv)
+			}
+			continue
+		}
+		if bytes.Compare(kb, key) >= 0 && (bytes.Equal(end, []byte{0}) || bytes.Compare(kb, end) < 0) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs
+}
+
+func (f *fakeKV) rng(r *pb.RangeRequest) *pb.RangeResponse {
+	var kvs []*mvccpb.KeyValue
+	for _, kv := range f.match(r.Key, r.RangeEnd) {
+		if (r.MinCreateRevision > 0 && kv.CreateRevision < r.MinCreateRevision) ||
+			(r.MaxCreateRevision > 0 && kv.CreateRevision > r.MaxCreateRevision) ||
+			(r.MinModRevision > 0 && kv.ModRevision < r.MinModRevision) ||
+			(r.MaxModRevision > 0 && kv.ModRevision > r.MaxModRevision) {
+			continue
+		}
+		kvs = append(kvs, kv)
+	}
+	if r.SortOrder != pb.RangeRequest_NONE {
+		sort.SliceStable(kvs, func(i, j int) bool {
+			var less bool
+			switch r.SortTarget {
+			case pb.RangeRequest_CREATE:
+				less = kvs[i].CreateRevision < kvs[j].CreateRevision
+			case pb.RangeRequest_MOD:
+				less = kvs[i].ModRevision < kvs[j].ModRevision
+			case pb.RangeRequest_VERSION:
+				less = kvs[i].Version < kvs[j].Version
+			case pb.RangeRequest_VALUE:
+				less = bytes.Compare(kvs[i].Value, kvs[j].Value) < 0
+			default:
+				less = bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+			}
+			if r.SortOrder == pb.RangeRequest_DESCEND {
+				return !less
+			}
+			return less
+		})
+	}
+	resp := &pb.RangeResponse{Header: f.header(), Count: int64(len(kvs))}
+	if r.CountOnly {
+		return resp
+	}
+	if r.Limit > 0 && int64(len(kvs)) > r.Limit {
+		kvs = kvs[:r.Limit]
+		resp.More = true
+	}
+	resp.Kvs = kvs
+	return resp
+}
+
+func (f *fakeKV) put(r *pb.PutRequest) *pb.PutResponse {
+	f.revision++
+	key := string(r.Key)
+	kv := &mvccpb.KeyValue{Key: r.Key, Value: r.Value, CreateRevision: f.revision, ModRevision: f.revision, Version: 1, Lease: r.Lease}
+	old, ok := f.kvs[key]
+	if ok {
+		kv.CreateRevision = old.CreateRevision
+		kv.Version = old.Version + 1
+	}
+	f.kvs[key] = kv
+	f.notify(&mvccpb.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: old})
+	return &pb.PutResponse{Header: f.header()}
+}
+
+func (f *fakeKV) del(r *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	kvs := f.match(r.Key, r.RangeEnd)
+	if len(kvs) > 0 {
+		f.revision++
+	}
+	resp := &pb.DeleteRangeResponse{Header: f.header(), Deleted: int64(len(kvs))}
+	for _, kv := range kvs {
+		delete(f.kvs, string(kv.Key))
+		f.notify(&mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: kv.Key, ModRevision: f.revision}, PrevKv: kv})
+	}
+	if r.PrevKv {
+		resp.PrevKvs = kvs
+	}
+	return resp
+}
+
+func (f *fakeKV) compare(c *pb.Compare) bool {
+	kv := f.kvs[string(c.Key)]
+	if kv == nil {
+		kv = &mvccpb.KeyValue{}
+	}
+	var r int
+	switch c.Target {
+	case pb.Compare_VALUE:
+		r = bytes.Compare(kv.Value, c.GetValue())
+	default:
+		var v, t int64
+		switch c.Target {
+		case pb.Compare_MOD:
+			v, t = kv.ModRevision, c.GetModRevision()
+		case pb.Compare_CREATE:
+			v, t = kv.CreateRevision, c.GetCreateRevision()
+		case pb.Compare_VERSION:
+			v, t = kv.Version, c.GetVersion()
+		case pb.Compare_LEASE:
+			v, t = kv.Lease, c.GetLease()
+		}
+		switch {
+		case v < t:
+			r = -1
+		case v > t:
+			r = 1
+		}
+	}
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return r == 0
+	case pb.Compare_NOT_EQUAL:
+		return r != 0
+	case pb.Compare_GREATER:
+		return r > 0
+	default:
+		return r < 0
+	}
+}
+
+func (f *fakeKV) Range(ctx context.Context, in *pb.RangeRequest, opts ...grpc.CallOption) (*pb.RangeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ranges++
+	return f.rng(in), nil
+}
+
+func (f *fakeKV) Put(ctx context.Context, in *pb.PutRequest, opts ...grpc.CallOption) (*pb.PutResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.put(in), nil
+}
+
+func (f *fakeKV) DeleteRange(ctx context.Context, in *pb.DeleteRangeRequest, opts ...grpc.CallOption) (*pb.DeleteRangeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.del(in), nil
+}
+
+func (f *fakeKV) Txn(ctx context.Context, in *pb.TxnRequest, opts ...grpc.CallOption) (*pb.TxnResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.beforeTxn != nil {
+		f.beforeTxn(f)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txns++
+	succeeded := true
+	for _, c := range in.Compare {
+		if !f.compare(c) {
+			succeeded = false
+			break
+		}
+	}
+	ops := in.Success
+	if !succeeded {
+		ops = in.Failure
+	}
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		switch {
+		case op.GetRequestRange() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: f.rng(op.GetRequestRange())}})
+		case op.GetRequestPut() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: f.put(op.GetRequestPut())}})
+		case op.GetRequestDeleteRange() != nil:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: f.del(op.GetRequestDeleteRange())}})
+		}
+	}
+	resp.Header = f.header()
+	return resp, nil
+}
+
+func (f *fakeKV) Compact(ctx context.Context, in *pb.CompactionRequest, opts ...grpc.CallOption) (*pb.CompactionResponse, error) {
+	return &pb.CompactionResponse{Header: f.header()}, nil
+}
+
+// 删除租约以及绑定的key
+func (f *fakeKV) revoke(id int64) bool {
+	if _, ok := f.leases[id]; !ok {
+		return false
+	}
+	delete(f.leases, id)
+	for key, kv := range f.kvs {
+		if kv.Lease == id {
+			f.del(&pb.DeleteRangeRequest{Key: []byte(key)})
+		}
+	}
+	return true
+}
+
+// expire 模拟租约过期 ，绑定的key 被删除 ，续期的客户端收到 TTL 为0 的响应
+func (f *fakeKV) expire(id int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoke(id)
+	for s := range f.keepAlives {
+		s.send(&pb.LeaseKeepAliveResponse{Header: f.header(), ID: id})
+	}
+}
+
+// rangeCount Range 请求次数 ，不包含事务中的读取
+func (f *fakeKV) rangeCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ranges
+}
+
+// leaseIDs 当前有效的租约
+func (f *fakeKV) leaseIDs() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]int64, 0, len(f.leases))
+	for id := range f.leases {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// keys 按前缀返回当前的key
+func (f *fakeKV) keys(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for _, kv := range f.match([]byte(prefix), []byte(clientv3.GetPrefixRangeEnd(prefix))) {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+// compact 压缩 revision 之前的历史 ，从更早的revision 开始的监听收到 CompactRevision
+func (f *fakeKV) compact(revision int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compacted = revision
+	i := 0
+	for i < len(f.history) && f.history[i].Kv.ModRevision < revision {
+		i++
+	}
+	f.history = f.history[i:]
+}
+
+func (f *fakeKV) LeaseGrant(ctx context.Context, in *pb.LeaseGrantRequest, opts ...grpc.CallOption) (*pb.LeaseGrantResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leaseSeq++
+	f.leases[f.leaseSeq] = in.TTL
+	return &pb.LeaseGrantResponse{Header: f.header(), ID: f.leaseSeq, TTL: in.TTL}, nil
+}
+
+func (f *fakeKV) LeaseRevoke(ctx context.Context, in *pb.LeaseRevokeRequest, opts ...grpc.CallOption) (*pb.LeaseRevokeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.revoke(in.ID) {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	return &pb.LeaseRevokeResponse{Header: f.header()}, nil
+}
+
+func (f *fakeKV) LeaseKeepAlive(ctx context.Context, opts ...grpc.CallOption) (pb.Lease_LeaseKeepAliveClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := &fakeKeepAliveStream{f: f, ctx: ctx, resps: make(chan *pb.LeaseKeepAliveResponse, 64)}
+	f.keepAlives[s] = struct{}{}
+	return s, nil
+}
+
+func (f *fakeKV) LeaseTimeToLive(ctx context.Context, in *pb.LeaseTimeToLiveRequest, opts ...grpc.CallOption) (*pb.LeaseTimeToLiveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ttl, ok := f.leases[in.ID]
+	if !ok {
+		ttl = -1
+	}
+	return &pb.LeaseTimeToLiveResponse{Header: f.header(), ID: in.ID, TTL: ttl, GrantedTTL: ttl}, nil
+}
+
+func (f *fakeKV) LeaseLeases(ctx context.Context, in *pb.LeaseLeasesRequest, opts ...grpc.CallOption) (*pb.LeaseLeasesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &pb.LeaseLeasesResponse{Header: f.header()}
+	for id := range f.leases {
+		resp.Leases = append(resp.Leases, &pb.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+type fakeKeepAliveStream struct {
+	grpc.ClientStream
+	f     *fakeKV
+	ctx   context.Context
+	resps chan *pb.LeaseKeepAliveResponse
+}
+
+// 调用方持有 f.mu
+func (s *fakeKeepAliveStream) send(resp *pb.LeaseKeepAliveResponse) {
+	select {
+	case s.resps <- resp:
+	default:
+	}
+}
+
+func (s *fakeKeepAliveStream) Send(req *pb.LeaseKeepAliveRequest) error {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.send(&pb.LeaseKeepAliveResponse{Header: s.f.header(), ID: req.ID, TTL: s.f.leases[req.ID]})
+	return nil
+}
+
+func (s *fakeKeepAliveStream) Recv() (*pb.LeaseKeepAliveResponse, error) {
+	select {
+	case resp := <-s.resps:
+		return resp, nil
+	case <-s.ctx.Done():
+		s.f.mu.Lock()
+		delete(s.f.keepAlives, s)
+		s.f.mu.Unlock()
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *fakeKeepAliveStream) Context() context.Context { return s.ctx }
+func (s *fakeKeepAliveStream) CloseSend() error         { return nil }
+
+// 记录事件并推送给匹配的监听 ，调用方持有 f.mu
+func (f *fakeKV) notify(ev *mvccpb.Event) {
+	f.history = append(f.history, ev)
+	for s := range f.watches {
+		for id, req := range s.reqs {
+			if watchMatch(req, ev) {
+				s.resps <- &pb.WatchResponse{Header: f.header(), WatchId: id, Events: []*mvccpb.Event{watchEvent(req, ev)}}
+			}
+		}
+	}
+}
+
+func watchMatch(req *pb.WatchCreateRequest, ev *mvccpb.Event) bool {
+	for _, filter := range req.Filters {
+		if (filter == pb.WatchCreateRequest_NOPUT && ev.Type == mvccpb.PUT) ||
+			(filter == pb.WatchCreateRequest_NODELETE && ev.Type == mvccpb.DELETE) {
+			return false
+		}
+	}
+	key := ev.Kv.Key
+	if len(req.RangeEnd) == 0 {
+		return bytes.Equal(key, req.Key)
+	}
+	return bytes.Compare(key, req.Key) >= 0 && (bytes.Equal(req.RangeEnd, []byte{0}) || bytes.Compare(key, req.RangeEnd) < 0)
+}
+
+func watchEvent(req *pb.WatchCreateRequest, ev *mvccpb.Event) *mvccpb.Event {
+	if req.PrevKv {
+		return ev
+	}
+	return &mvccpb.Event{Type: ev.Type, Kv: ev.Kv}
+}
+
+func (f *fakeKV) Watch(ctx context.Context, opts ...grpc.CallOption) (pb.Watch_WatchClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := &fakeWatchStream{f: f, ctx: ctx, reqs: make(map[int64]*pb.WatchCreateRequest), resps: make(chan *pb.WatchResponse, 1024)}
+	f.watches[s] = struct{}{}
+	return s, nil
+}
+
+type fakeWatchStream struct {
+	grpc.ClientStream
+	f      *fakeKV
+	ctx    context.Context
+	nextID int64
+	reqs   map[int64]*pb.WatchCreateRequest // f.mu 保护
+	resps  chan *pb.WatchResponse
+}
+
+func (s *fakeWatchStream) Send(req *pb.WatchRequest) error {
+	f := s.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r := req.GetCancelRequest(); r != nil {
+		delete(s.reqs, r.WatchId)
+		s.resps <- &pb.WatchResponse{Header: f.header(), WatchId: r.WatchId, Canceled: true}
+		return nil
+	}
+	r := req.GetCreateRequest()
+	if r == nil {
+		return nil
+	}
+	id := s.nextID
+	s.nextID++
+	s.resps <- &pb.WatchResponse{Header: f.header(), WatchId: id, Created: true}
+	if r.StartRevision > 0 && r.StartRevision < f.compacted {
+		s.resps <- &pb.WatchResponse{Header: f.header(), WatchId: id, CompactRevision: f.compacted, Canceled: true}
+		return nil
+	}
+	if r.StartRevision > 0 {
+		for _, ev := range f.history {
+			if ev.Kv.ModRevision >= r.StartRevision && watchMatch(r, ev) {
+				s.resps <- &pb.WatchResponse{Header: f.header(), WatchId: id, Events: []*mvccpb.Event{watchEvent(r, ev)}}
+			}
+		}
+	}
+	s.reqs[id] = r
+	return nil
+}
+
+func (s *fakeWatchStream) Recv() (*pb.WatchResponse, error) {
+	select {
+	case resp := <-s.resps:
+		return resp, nil
+	case <-s.ctx.Done():
+		s.f.mu.Lock()
+		delete(s.f.watches, s)
+		s.f.mu.Unlock()
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *fakeWatchStream) Context() context.Context { return s.ctx }
+func (s *fakeWatchStream) CloseSend() error         { return nil }
@@ -5,10 +5,11 @@ import clientv3 "go.etcd.io/etcd/client/v3"
 var etcdcli *clientv3.Client
 var tool *EtcdTool
 
-func InitEtcd(cli *clientv3.Client) {
+// InitEtcd 初始化全局的 EtcdTool ，多集群请使用 New
+func InitEtcd(cli *clientv3.Client, opts ...Option) {
 	if cli == nil {
 		panic("etcd init fail")
 	}
 	etcdcli = cli
-	tool = &EtcdTool{Tool: etcdcli}
+	tool = New(etcdcli, opts...)
 }
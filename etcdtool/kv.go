@@ -0,0 +1,150 @@
+package etcdtool
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 分页读取默认每页数量
+	DefaultRangePageSize = 500
+)
+
+// KeyValue etcd 中的一条数据
+type KeyValue struct {
+	Key   string
+	Value string
+	// 创建时的revision
+	CreateRevision int64
+	// 最后一次修改的revision
+	ModRevision int64
+	// 修改次数，删除后重置为0
+	Version int64
+	// 绑定的租约id ，0 表示未绑定
+	Lease int64
+}
+
+func newKeyValue(kv *mvccpb.KeyValue) *KeyValue {
+	return &KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+}
+
+func newKeyValues(kvs []*mvccpb.KeyValue) []*KeyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+	datas := make([]*KeyValue, 0, len(kvs))
+	for i := 0; i < len(kvs); i++ {
+		datas = append(datas, newKeyValue(kvs[i]))
+	}
+	return datas
+}
+
+// GetKV 获取一个key ，不存在返回 nil
+func (etcd *EtcdTool) GetKV(ctx context.Context, key string) (kv *KeyValue, err error) {
+	if key == "" {
+		err = errors.Errorf("GetKV_err key is empty ")
+		return
+	}
+	ctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	resp, e := etcd.Tool.Get(ctx, key)
+	if e != nil {
+		err = errors.Wrapf(e, "GetKV_err")
+		return
+	}
+	if len(resp.Kvs) == 0 {
+		return
+	}
+	kv = newKeyValue(resp.Kvs[0])
+	return
+}
+
+// GetPrefixKVs 获取前缀下的所有数据 ，按key排序 ，revision 为读取时的revision
+func (etcd *EtcdTool) GetPrefixKVs(ctx context.Context, prefixKey string) (kvs []*KeyValue, revision int64, err error) {
+	if prefixKey == "" {
+		err = errors.Errorf("GetPrefixKVs_err key is empty ")
+		return
+	}
+	ctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	resp, e := etcd.Tool.Get(ctx, prefixKey, clientv3.WithPrefix())
+	if e != nil {
+		err = errors.Wrapf(e, "GetPrefixKVs_err")
+		return
+	}
+	kvs = newKeyValues(resp.Kvs)
+	revision = resp.Header.Revision
+	return
+}
+
+// RangePrefix 分页遍历前缀下的数据 ，所有分页读取同一个revision 的快照
+// pageSize <= 0 使用 DefaultRangePageSize ，fn 返回false 则终止遍历
+func (etcd *EtcdTool) RangePrefix(ctx context.Context, prefixKey string, pageSize int64, fn func(kv *KeyValue) bool) (revision int64, err error) {
+	if prefixKey == "" || fn == nil {
+		err = errors.Errorf("RangePrefix_err args err prefixKey = %s", prefixKey)
+		return
+	}
+	if pageSize <= 0 {
+		pageSize = DefaultRangePageSize
+	}
+	end := clientv3.GetPrefixRangeEnd(prefixKey)
+	key := prefixKey
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(pageSize)}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		pctx, cancel := etcd.withTimeout(ctx)
+		resp, e := etcd.Tool.Get(pctx, key, opts...)
+		cancel()
+		if e != nil {
+			err = errors.Wrapf(e, "RangePrefix_err key = %s , revision = %d", key, revision)
+			return
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		for i := 0; i < len(resp.Kvs); i++ {
+			if !fn(newKeyValue(resp.Kvs[i])) {
+				return
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return
+		}
+		// 下一页从最后一个key 之后开始
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// DeletePrefixKVs 删除前缀下的数据 ，withPrevKV 为true 时返回被删除的数据
+func (etcd *EtcdTool) DeletePrefixKVs(ctx context.Context, prefixKey string, withPrevKV bool) (deleted int64, prevKvs []*KeyValue, err error) {
+	if prefixKey == "" {
+		err = errors.Errorf("DeletePrefixKVs_err key is empty ")
+		return
+	}
+	ctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	opts := []clientv3.OpOption{clientv3.WithPrefix()}
+	if withPrevKV {
+		opts = append(opts, clientv3.WithPrevKV())
+	}
+	resp, e := etcd.Tool.Delete(ctx, prefixKey, opts...)
+	if e != nil {
+		err = errors.Wrapf(e, "DeletePrefixKVs_err")
+		return
+	}
+	deleted = resp.Deleted
+	prevKvs = newKeyValues(resp.PrevKvs)
+	return
+}
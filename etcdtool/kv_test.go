@@ -0,0 +1,85 @@
+package etcdtool
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+func TestNewKeyValue(t *testing.T) {
+	kv := newKeyValue(&mvccpb.KeyValue{Key: []byte("k"), Value: []byte("v"), CreateRevision: 2, ModRevision: 5, Version: 3, Lease: 9})
+	want := KeyValue{Key: "k", Value: "v", CreateRevision: 2, ModRevision: 5, Version: 3, Lease: 9}
+	if *kv != want {
+		t.Fatalf("kv = %+v , want %+v", *kv, want)
+	}
+	if newKeyValues(nil) != nil {
+		t.Fatal("empty kvs should be nil")
+	}
+}
+
+func TestGetKV(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	kv, err := etcd.GetKV(context.Background(), "k")
+	if err != nil || kv != nil {
+		t.Fatalf("kv = %+v , err = %v", kv, err)
+	}
+	f.set("k", "v", 3)
+	kv, err = etcd.GetKV(context.Background(), "k")
+	if err != nil || kv.Value != "v" || kv.Lease != 3 || kv.Version != 1 {
+		t.Fatalf("kv = %+v , err = %v", kv, err)
+	}
+}
+
+func TestRangePrefix(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	for i := 0; i < 7; i++ {
+		f.set("/users/"+strconv.Itoa(i), strconv.Itoa(i), 0)
+	}
+	f.set("/usersx", "other", 0)
+
+	var keys []string
+	revision, err := etcd.RangePrefix(context.Background(), "/users/", 3, func(kv *KeyValue) bool {
+		keys = append(keys, kv.Key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 7 || keys[0] != "/users/0" || keys[6] != "/users/6" {
+		t.Fatalf("keys = %v", keys)
+	}
+	if revision != 9 {
+		t.Fatalf("revision = %d , want 9", revision)
+	}
+
+	// fn 返回false 终止遍历
+	n := 0
+	_, _ = etcd.RangePrefix(context.Background(), "/users/", 3, func(kv *KeyValue) bool {
+		n++
+		return n < 4
+	})
+	if n != 4 {
+		t.Fatalf("n = %d , want 4", n)
+	}
+}
+
+func TestDeletePrefixKVs(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	f.set("/a/1", "1", 0)
+	f.set("/a/2", "2", 0)
+	f.set("/b/1", "1", 0)
+
+	deleted, prevKvs, err := etcd.DeletePrefixKVs(context.Background(), "/a/", true)
+	if err != nil || deleted != 2 || len(prevKvs) != 2 || prevKvs[0].Key != "/a/1" {
+		t.Fatalf("deleted = %d , prevKvs = %+v , err = %v", deleted, prevKvs, err)
+	}
+	deleted, prevKvs, err = etcd.DeletePrefixKVs(context.Background(), "/b/", false)
+	if err != nil || deleted != 1 || prevKvs != nil {
+		t.Fatalf("deleted = %d , prevKvs = %+v , err = %v", deleted, prevKvs, err)
+	}
+}
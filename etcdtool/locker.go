@@ -2,6 +2,7 @@ package etcdtool
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,64 +11,267 @@ import (
 
 const (
 	lockPrefix = "etcd_lock_"
+	// 默认会话租约时间 秒，持有锁的进程异常退出后最多 ttl 秒锁自动释放
+	DefaultLockTTL = 5
 )
 
+var (
+	// ErrLocked TryLock 时锁已被其他会话持有
+	ErrLocked = errors.New("etcd lock is held by another session")
+	// ErrNotLocked 未持有锁时解锁
+	ErrNotLocked = errors.New("etcd lock is not held")
+)
+
+// 分布式锁、读写锁、信号量共用的参数
+type lockOptions struct {
+	ttl int
+}
+
+func newLockOptions(opts []LockOption) lockOptions {
+	o := lockOptions{ttl: DefaultLockTTL}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&o)
+	}
+	return o
+}
+
+type LockOption func(o *lockOptions)
+
+// WithLockTTL 会话租约时间 ，单位秒
+func WithLockTTL(ttl int) LockOption {
+	return func(o *lockOptions) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// EtcdLock 分布式锁 ，同一个 EtcdLock 对象可重入 ，不同协程竞争请使用不同的 EtcdLock
+// 每次加锁创建一个会话 ，解锁时关闭会话
 type EtcdLock struct {
-	m *concurrency.Mutex
+	lockOptions
+	tool *EtcdTool
+	key  string
+	// 会话的生命周期 ，结束后租约不再续期
+	sessionCtx context.Context
+	// 同一时间只有一个协程在加锁 ，等待etcd 时不持有 mu
+	acquiring chan struct{}
+
+	mu       sync.Mutex
+	session  *concurrency.Session
+	m        *concurrency.Mutex
+	holds    int
+	lost     chan struct{}
+	released chan struct{}
 }
 
 // 创建一把分布式锁
-func NewLocker(lockKey string, timeout int) (locker *EtcdLock, cancelFunc func(), err error) {
-	if lockKey == "" || timeout <= 0 || etcdcli == nil {
-		err = errors.Errorf("GetLocker_err args err lockKey = %s , timeout = %s , cli = %+v \n ",
+// timeout 不是加锁的等待时间 ，而是从调用 NewLocker 开始计算的会话存活时间 单位秒
+// 到期后会话不再续期 ，Lost 立即关闭 ，租约在 ttl 秒内仍然有效 ，之后锁自动释放
+// 临界区需要在 timeout 内完成 ，只需要限制等待时间请使用 EtcdTool.NewLocker 和 LockCtx
+func NewLocker(lockKey string, timeout int, opts ...LockOption) (locker *EtcdLock, cancelFunc func(), err error) {
+	if lockKey == "" || timeout <= 0 || tool == nil {
+		err = errors.Errorf("GetLocker_err args err lockKey = %s , timeout = %d , cli = %+v \n ",
 			lockKey, timeout, etcdcli)
 		return
 	}
-	lockKey = lockPrefix + lockKey
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(timeout))
+	locker, err = tool.NewLocker(lockKey, opts...)
+	if err != nil {
+		cancel()
+		return
+	}
+	locker.sessionCtx = ctx
 	cancelFunc = cancel
-	ss, e := concurrency.NewSession(etcdcli, concurrency.WithContext(ctx), concurrency.WithTTL(1))
-	if e != nil {
-		err = errors.Wrapf(e, "GetLocker_err NewSession_err")
+	return
+}
+
+// NewLocker 创建一把分布式锁
+func (etcd *EtcdTool) NewLocker(lockKey string, opts ...LockOption) (locker *EtcdLock, err error) {
+	if lockKey == "" || etcd.Tool == nil {
+		err = errors.Errorf("NewLocker_err args err lockKey = %s", lockKey)
 		return
 	}
-	myLocker := concurrency.NewMutex(ss, lockKey)
-	locker = &EtcdLock{m: myLocker}
+	locker = &EtcdLock{
+		lockOptions: newLockOptions(opts),
+		tool:        etcd,
+		key:         lockPrefix + lockKey,
+		sessionCtx:  context.Background(),
+		acquiring:   make(chan struct{}, 1),
+	}
 	return
 }
 
 // 锁住代码
-// isWait 是否阻塞等待锁释放
+// isWait 是否阻塞等待锁释放 ，false 时锁被占用立即返回 ErrLocked
 func (locker *EtcdLock) Lock(isWait bool) (err error) {
+	if isWait {
+		return locker.LockCtx(context.Background())
+	}
+	return locker.TryLock(context.Background())
+}
 
-	var ctx context.Context
-	var cancel func()
+// LockCtx 阻塞等待直到获取到锁或者ctx 结束
+func (locker *EtcdLock) LockCtx(ctx context.Context) (err error) {
+	return locker.acquire(ctx, false)
+}
 
-	if isWait {
-		ctx = context.TODO()
+// TryLock 尝试获取锁 ，锁被其他会话持有时立即返回 ErrLocked
+func (locker *EtcdLock) TryLock(ctx context.Context) (err error) {
+	return locker.acquire(ctx, true)
+}
+
+func (locker *EtcdLock) acquire(ctx context.Context, try bool) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case locker.acquiring <- struct{}{}:
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "Lock_err")
+		return
+	}
+	defer func() { <-locker.acquiring }()
+	// 重入
+	locker.mu.Lock()
+	if locker.holds > 0 {
+		locker.holds++
+		locker.mu.Unlock()
+		return
+	}
+	locker.mu.Unlock()
+
+	ss, e := newLockSession(ctx, locker.tool, locker.lockOptions, locker.sessionCtx)
+	if e != nil {
+		err = errors.Wrapf(e, "Lock_err NewSession_err")
+		return
+	}
+	m := concurrency.NewMutex(ss, locker.key)
+	if try {
+		e = m.TryLock(ctx)
 	} else {
-		// 获取锁的等待时间 ,1 秒未获取到锁，会取消获取
-		ctx2, cn := context.WithTimeout(context.Background(), time.Second*1)
-		cancel = cn
-		ctx = ctx2
+		e = m.Lock(ctx)
 	}
-	err = locker.m.Lock(ctx)
-	if err != nil {
-		err = errors.Wrapf(err, "Lock_err")
-		if cancel != nil {
-			cancel()
+	if e != nil {
+		_ = ss.Close()
+		if e == concurrency.ErrLocked {
+			err = ErrLocked
+			return
 		}
+		err = errors.Wrapf(e, "Lock_err")
+		return
+	}
+	// 加锁成功后才对 UnLock、Lost 可见
+	locker.mu.Lock()
+	locker.session = ss
+	locker.m = m
+	locker.holds = 1
+	locker.lost = make(chan struct{})
+	locker.released = make(chan struct{})
+	go watchSession(ss, locker.lost, locker.released)
+	locker.mu.Unlock()
+	return
+}
+
+// 创建会话 ，申请租约受调用方ctx 控制 ，续期受 sessionCtx 控制
+// 调用方ctx 只用于等待加锁 ，结束后不影响已经持有的锁
+func newLockSession(ctx context.Context, etcd *EtcdTool, o lockOptions, sessionCtx context.Context) (ss *concurrency.Session, err error) {
+	gctx, cancel := etcd.withTimeout(ctx)
+	lease, err := etcd.Tool.Grant(gctx, int64(o.ttl))
+	cancel()
+	if err != nil {
 		return
 	}
+	ss, err = concurrency.NewSession(etcd.Tool, concurrency.WithLease(lease.ID),
+		concurrency.WithContext(sessionCtx), concurrency.WithTTL(o.ttl))
+	if err != nil {
+		rctx, cancel := etcd.withTimeout(context.Background())
+		_, _ = etcd.Tool.Revoke(rctx, lease.ID)
+		cancel()
+	}
 	return
 }
 
-// 释放锁
+// 会话过期后通知持有者
+func watchSession(ss *concurrency.Session, lost, released chan struct{}) {
+	select {
+	case <-ss.Done():
+		close(lost)
+	case <-released:
+	}
+}
+
+// 释放锁 ，重入的锁需要解锁相同的次数 ，最后一次解锁关闭会话
 func (locker *EtcdLock) UnLock() (err error) {
-	err = locker.m.Unlock(context.Background())
+	locker.mu.Lock()
+	if locker.holds == 0 {
+		locker.mu.Unlock()
+		err = ErrNotLocked
+		return
+	}
+	locker.holds--
+	if locker.holds > 0 {
+		locker.mu.Unlock()
+		return
+	}
+	ss, m := locker.session, locker.m
+	close(locker.released)
+	locker.session = nil
+	locker.m = nil
+	locker.mu.Unlock()
+
+	// 请求etcd 时不持有 mu
+	ctx, cancel := locker.tool.withTimeout(context.Background())
+	defer cancel()
+	err = m.Unlock(ctx)
+	// 关闭会话会撤销租约 ，解锁失败时锁也会被释放
+	_ = ss.Close()
 	if err != nil {
 		err = errors.Wrapf(err, "Lock_err")
 		return
 	}
 	return
 }
+
+// Lost 持有锁期间会话过期时关闭 ，此时锁可能已被其他会话获取 ，临界区应该中止
+// 未持有锁时返回已关闭的通道
+func (locker *EtcdLock) Lost() <-chan struct{} {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	if locker.holds == 0 {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return locker.lost
+}
+
+// WithLock 获取锁后执行 fn ，fn 的ctx 在会话过期时取消 ，执行完成后释放锁
+func (etcd *EtcdTool) WithLock(ctx context.Context, lockKey string, fn func(ctx context.Context) error, opts ...LockOption) (err error) {
+	if fn == nil {
+		err = errors.Errorf("WithLock_err fn is nil lockKey = %s", lockKey)
+		return
+	}
+	locker, err := etcd.NewLocker(lockKey, opts...)
+	if err != nil {
+		return
+	}
+	if err = locker.LockCtx(ctx); err != nil {
+		return
+	}
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lost := locker.Lost()
+	go func() {
+		select {
+		case <-lost:
+			cancel()
+		case <-fnCtx.Done():
+		}
+	}()
+	err = fn(fnCtx)
+	if e := locker.UnLock(); e != nil && err == nil {
+		err = e
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package etcdtool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 等待通道关闭
+func waitClosed(t *testing.T, ch <-chan struct{}, msg string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal(msg)
+	}
+}
+
+func TestEtcdLock_TryLock(t *testing.T) {
+	etcd := newFakeTool(newFakeKV())
+	a, _ := etcd.NewLocker("job")
+	b, _ := etcd.NewLocker("job")
+	ctx := context.Background()
+	if err := a.TryLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TryLock(ctx); err != ErrLocked {
+		t.Fatalf("TryLock err = %v , want ErrLocked", err)
+	}
+	if err := a.UnLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(false); err != nil {
+		t.Fatal(err)
+	}
+	_ = b.UnLock()
+}
+
+func TestEtcdLock_Reentrant(t *testing.T) {
+	etcd := newFakeTool(newFakeKV())
+	a, _ := etcd.NewLocker("job")
+	b, _ := etcd.NewLocker("job")
+	ctx := context.Background()
+	if err := a.UnLock(); err != ErrNotLocked {
+		t.Fatalf("UnLock err = %v , want ErrNotLocked", err)
+	}
+	if err := a.LockCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.TryLock(ctx); err != nil {
+		t.Fatalf("reentrant TryLock err = %v", err)
+	}
+	// 解锁次数不够仍然持有
+	if err := a.UnLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.TryLock(ctx); err != ErrLocked {
+		t.Fatalf("TryLock err = %v , want ErrLocked", err)
+	}
+	if err := a.UnLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.UnLock(); err != ErrNotLocked {
+		t.Fatalf("UnLock err = %v , want ErrNotLocked", err)
+	}
+
+	// 等待中的 LockCtx 在释放后获取到锁
+	if err := a.LockCtx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- b.LockCtx(ctx) }()
+	select {
+	case err := <-done:
+		t.Fatalf("LockCtx returned while held , err = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	_ = a.UnLock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("LockCtx not acquired after unlock")
+	}
+	_ = b.UnLock()
+}
+
+func TestEtcdLock_Lost(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	a, _ := etcd.NewLocker("job")
+	b, _ := etcd.NewLocker("job")
+	if err := a.TryLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lost := a.Lost()
+	select {
+	case <-lost:
+		t.Fatal("Lost closed while held")
+	default:
+	}
+	// 租约过期 ，锁被释放
+	for _, id := range f.leaseIDs() {
+		f.expire(id)
+	}
+	waitClosed(t, lost, "Lost not closed after session expiry")
+	if err := b.TryLock(context.Background()); err != nil {
+		t.Fatalf("TryLock after expiry err = %v", err)
+	}
+	_ = b.UnLock()
+}
+
+func TestNewLocker_Timeout(t *testing.T) {
+	old := tool
+	tool = newFakeTool(newFakeKV())
+	defer func() { tool = old }()
+
+	locker, cancel, err := NewLocker("job", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if err = locker.Lock(true); err != nil {
+		t.Fatal(err)
+	}
+	// timeout 到期后会话不再续期 ，Lost 关闭
+	waitClosed(t, locker.Lost(), "Lost not closed after NewLocker timeout")
+	_ = locker.UnLock()
+}
+
+func TestEtcdLock_AcquireInFlight(t *testing.T) {
+	locker, err := newFakeTool(newFakeKV()).NewLocker("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟另一个协程正在等待etcd 加锁
+	locker.acquiring <- struct{}{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-locker.Lost():
+		default:
+			t.Error("Lost should be closed when the lock is not held")
+		}
+		if err := locker.UnLock(); err != ErrNotLocked {
+			t.Errorf("UnLock err = %v , want ErrNotLocked", err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lost and UnLock blocked by an in-flight acquire")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := locker.LockCtx(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("LockCtx err = %v , want deadline exceeded", err)
+	}
+}
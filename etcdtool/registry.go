@@ -0,0 +1,244 @@
+package etcdtool
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 默认服务注册前缀
+	DefaultRegistryPrefix = "/services/"
+	// 默认租约时间 秒
+	DefaultRegistryTTL = 6
+	// 默认重新注册间隔
+	DefaultRegistryRetryInterval = time.Second
+)
+
+// ServiceInstance 注册到etcd的服务实例，以json格式存储
+type ServiceInstance struct {
+	Name    string            `json:"name"`
+	ID      string            `json:"id"`
+	Addr    string            `json:"addr"`
+	Weight  int               `json:"weight"`
+	Zone    string            `json:"zone,omitempty"`
+	Version string            `json:"version,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func (ins *ServiceInstance) validate() error {
+	if ins == nil {
+		return errors.New("instance is nil")
+	}
+	if ins.Name == "" || ins.ID == "" || ins.Addr == "" {
+		return errors.Errorf("instance args err name = %s , id = %s , addr = %s", ins.Name, ins.ID, ins.Addr)
+	}
+	if strings.Contains(ins.Name, "/") || strings.Contains(ins.ID, "/") {
+		return errors.Errorf("instance name or id contains '/' name = %s , id = %s", ins.Name, ins.ID)
+	}
+	return nil
+}
+
+// ServicePrefix 服务下所有实例的key前缀 prefix + name + "/"
+func ServicePrefix(prefix, name string) string {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + name + "/"
+}
+
+// ServiceKey 实例在etcd中的key prefix + name + "/" + id
+func ServiceKey(prefix string, ins *ServiceInstance) string {
+	return ServicePrefix(prefix, ins.Name) + ins.ID
+}
+
+// EncodeInstance 实例序列化
+func EncodeInstance(ins *ServiceInstance) (string, error) {
+	b, err := json.Marshal(ins)
+	if err != nil {
+		return "", errors.Wrapf(err, "EncodeInstance_err")
+	}
+	return string(b), nil
+}
+
+// DecodeInstance 实例反序列化
+func DecodeInstance(value []byte) (*ServiceInstance, error) {
+	ins := new(ServiceInstance)
+	if err := json.Unmarshal(value, ins); err != nil {
+		return nil, errors.Wrapf(err, "DecodeInstance_err")
+	}
+	return ins, nil
+}
+
+type RegistryOption func(r *Registry)
+
+// Registry 基于租约的服务注册
+// 租约失效或者保活通道关闭后会自动重新申请租约并写入key，context 取消后撤销租约删除key
+type Registry struct {
+	tool          *EtcdTool
+	prefix        string
+	ttl           int64
+	retryInterval time.Duration
+	errFunc       func(ins *ServiceInstance, err error)
+}
+
+// WithRegistryPrefix 设置注册前缀 ，默认 DefaultRegistryPrefix
+func WithRegistryPrefix(prefix string) RegistryOption {
+	return func(r *Registry) {
+		if prefix != "" {
+			r.prefix = prefix
+		}
+	}
+}
+
+// WithRegistryTTL 设置租约时间 ，单位秒
+func WithRegistryTTL(ttl int64) RegistryOption {
+	return func(r *Registry) {
+		if ttl > 0 {
+			r.ttl = ttl
+		}
+	}
+}
+
+// WithRegistryRetryInterval 重新注册失败后的重试间隔
+func WithRegistryRetryInterval(interval time.Duration) RegistryOption {
+	return func(r *Registry) {
+		if interval > 0 {
+			r.retryInterval = interval
+		}
+	}
+}
+
+// WithRegistryErrFunc 后台保活、重新注册出错时回调
+func WithRegistryErrFunc(fn func(ins *ServiceInstance, err error)) RegistryOption {
+	return func(r *Registry) {
+		r.errFunc = fn
+	}
+}
+
+func NewRegistry(tool *EtcdTool, opts ...RegistryOption) *Registry {
+	r := &Registry{
+		tool:          tool,
+		prefix:        DefaultRegistryPrefix,
+		ttl:           DefaultRegistryTTL,
+		retryInterval: DefaultRegistryRetryInterval,
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](r)
+	}
+	return r
+}
+
+func (r *Registry) Prefix() string {
+	return r.prefix
+}
+
+// Register 注册一个实例，首次注册失败直接返回err
+// 注册成功后后台保活，ctx 取消时注销实例
+func (r *Registry) Register(ctx context.Context, ins *ServiceInstance) (err error) {
+	if ctx == nil || r.tool == nil || r.tool.Tool == nil {
+		err = errors.Errorf("Register_err args err ctx = %+v , tool = %+v", ctx, r.tool)
+		return
+	}
+	if err = ins.validate(); err != nil {
+		err = errors.Wrapf(err, "Register_err")
+		return
+	}
+	value, err := EncodeInstance(ins)
+	if err != nil {
+		return
+	}
+	key := ServiceKey(r.prefix, ins)
+	leaseID, keepChan, err := r.grantAndPut(ctx, key, value)
+	if err != nil {
+		return
+	}
+	go r.keepAlive(ctx, ins, key, value, leaseID, keepChan)
+	return
+}
+
+func (r *Registry) grantAndPut(ctx context.Context, key, value string) (leaseID clientv3.LeaseID, keepChan <-chan *clientv3.LeaseKeepAliveResponse, err error) {
+	cli := r.tool.Tool
+	reqCtx, cancel := r.tool.withTimeout(ctx)
+	defer cancel()
+	lease, e := cli.Grant(reqCtx, r.ttl)
+	if e != nil {
+		err = errors.Wrapf(e, "Register_err grant key = %s", key)
+		return
+	}
+	_, e = cli.Put(reqCtx, key, value, clientv3.WithLease(lease.ID))
+	if e != nil {
+		r.revoke(lease.ID)
+		err = errors.Wrapf(e, "Register_err put key = %s", key)
+		return
+	}
+	keepChan, e = cli.KeepAlive(ctx, lease.ID)
+	if e != nil {
+		r.revoke(lease.ID)
+		err = errors.Wrapf(e, "Register_err keepalive key = %s", key)
+		return
+	}
+	leaseID = lease.ID
+	return
+}
+
+func (r *Registry) keepAlive(ctx context.Context, ins *ServiceInstance, key, value string,
+	leaseID clientv3.LeaseID, keepChan <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		select {
+		case _, ok := <-keepChan:
+			if ok {
+				continue
+			}
+			if ctx.Err() != nil {
+				r.revoke(leaseID)
+				return
+			}
+			// 保活通道关闭，租约已失效或者etcd连接异常，重新注册
+			r.onErr(ins, errors.Errorf("Register_err keepalive closed key = %s , lease = %d", key, leaseID))
+			leaseID, keepChan = r.reRegister(ctx, ins, key, value)
+			if keepChan == nil {
+				return
+			}
+		case <-ctx.Done():
+			r.revoke(leaseID)
+			return
+		}
+	}
+}
+
+// 重新注册直到成功或者ctx结束，ctx结束返回 nil chan
+func (r *Registry) reRegister(ctx context.Context, ins *ServiceInstance, key, value string) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		leaseID, keepChan, err := r.grantAndPut(ctx, key, value)
+		if err == nil {
+			return leaseID, keepChan
+		}
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+		r.onErr(ins, err)
+		select {
+		case <-time.After(r.retryInterval):
+		case <-ctx.Done():
+			return 0, nil
+		}
+	}
+}
+
+// 撤销租约，绑定租约的key会被删除
+func (r *Registry) revoke(leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	_, _ = r.tool.Tool.Revoke(ctx, leaseID)
+}
+
+func (r *Registry) onErr(ins *ServiceInstance, err error) {
+	if r.errFunc != nil {
+		r.errFunc(ins, err)
+	}
+}
@@ -0,0 +1,73 @@
+package etcdtool
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServiceInstance_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ins     *ServiceInstance
+		wantErr bool
+	}{
+		{name: "ok", ins: &ServiceInstance{Name: "im-gateway", ID: "10.0.0.1", Addr: "10.0.0.1:8080"}},
+		{name: "nil", ins: nil, wantErr: true},
+		{name: "empty id", ins: &ServiceInstance{Name: "im-gateway", Addr: "10.0.0.1:8080"}, wantErr: true},
+		{name: "empty addr", ins: &ServiceInstance{Name: "im-gateway", ID: "a"}, wantErr: true},
+		{name: "slash in name", ins: &ServiceInstance{Name: "im/gateway", ID: "a", Addr: "a:1"}, wantErr: true},
+		{name: "slash in id", ins: &ServiceInstance{Name: "im-gateway", ID: "a/b", Addr: "a:1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.ins.validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v , wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServiceKey(t *testing.T) {
+	ins := &ServiceInstance{Name: "im-gateway", ID: "a"}
+	if got := ServiceKey("/services", ins); got != "/services/im-gateway/a" {
+		t.Fatalf("key = %s", got)
+	}
+	if got := ServiceKey(DefaultRegistryPrefix, ins); got != "/services/im-gateway/a" {
+		t.Fatalf("key = %s", got)
+	}
+	if got := ServicePrefix("/services/", "im-gateway"); got != "/services/im-gateway/" {
+		t.Fatalf("prefix = %s", got)
+	}
+}
+
+func TestEncodeInstance(t *testing.T) {
+	ins := &ServiceInstance{
+		Name:    "im-gateway",
+		ID:      "a",
+		Addr:    "10.0.0.1:8080",
+		Weight:  3,
+		Zone:    "sh",
+		Version: "v1.2.0",
+		Labels:  map[string]string{"env": "prod"},
+	}
+	value, err := EncodeInstance(ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeInstance([]byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ins) {
+		t.Fatalf("got = %+v , want %+v", got, ins)
+	}
+
+	// 可选字段为空时不写入
+	value, _ = EncodeInstance(&ServiceInstance{Name: "im-gateway", ID: "a", Addr: "a:1"})
+	if value != `{"name":"im-gateway","id":"a","addr":"a:1","weight":0}` {
+		t.Fatalf("value = %s", value)
+	}
+	if _, err = DecodeInstance([]byte("not json")); err == nil {
+		t.Fatal("decode invalid json should fail")
+	}
+}
@@ -0,0 +1,124 @@
+package etcdtool
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// ResolverScheme grpc 拨号地址 etcd:///服务名
+	ResolverScheme = "etcd"
+)
+
+type instanceAttrKey struct{}
+
+// Equal grpc 比较地址属性时使用
+func (ins *ServiceInstance) Equal(o interface{}) bool {
+	other, ok := o.(*ServiceInstance)
+	if !ok {
+		return false
+	}
+	if ins == nil || other == nil {
+		return ins == other
+	}
+	if ins.Name != other.Name || ins.ID != other.ID || ins.Addr != other.Addr || ins.Weight != other.Weight ||
+		ins.Zone != other.Zone || ins.Version != other.Version || len(ins.Labels) != len(other.Labels) {
+		return false
+	}
+	for k, v := range ins.Labels {
+		if ov, ok := other.Labels[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// InstanceFromAddress 从 grpc 地址中取出服务实例，可以在自定义负载均衡中使用权重、机房等信息
+func InstanceFromAddress(addr resolver.Address) *ServiceInstance {
+	ins, _ := addr.BalancerAttributes.Value(instanceAttrKey{}).(*ServiceInstance)
+	return ins
+}
+
+type resolverBuilder struct {
+	tool *EtcdTool
+	opts []DiscoveryOption
+}
+
+// NewResolverBuilder 创建 grpc resolver.Builder ，opts 会传给每个服务的 Discovery
+func NewResolverBuilder(tool *EtcdTool, opts ...DiscoveryOption) resolver.Builder {
+	return &resolverBuilder{tool: tool, opts: opts}
+}
+
+// RegisterResolver 注册到grpc全局，之后可以直接 grpc.Dial("etcd:///im-gateway")
+func RegisterResolver(tool *EtcdTool, opts ...DiscoveryOption) {
+	resolver.Register(NewResolverBuilder(tool, opts...))
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return ResolverScheme
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	name := strings.TrimPrefix(target.URL.Path, "/")
+	if name == "" {
+		name = target.Endpoint
+	}
+	if name == "" {
+		return nil, errors.Errorf("Resolver_Build_err service name is empty target = %+v", target)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &etcdResolver{
+		cc:        cc,
+		cancel:    cancel,
+		discovery: NewDiscovery(b.tool, name, b.opts...),
+	}
+	if err := r.discovery.Start(ctx); err != nil {
+		cancel()
+		return nil, errors.Wrapf(err, "Resolver_Build_err")
+	}
+	r.unsubscribe = r.discovery.Subscribe(r.update)
+	return r, nil
+}
+
+type etcdResolver struct {
+	cc          resolver.ClientConn
+	cancel      context.CancelFunc
+	discovery   *Discovery
+	unsubscribe func()
+}
+
+// 服务实例转换为 grpc 地址 ，实例保存在 BalancerAttributes 中
+func instanceAddresses(instances []*ServiceInstance) []resolver.Address {
+	addrs := make([]resolver.Address, 0, len(instances))
+	for i := 0; i < len(instances); i++ {
+		ins := instances[i]
+		addrs = append(addrs, resolver.Address{
+			Addr:               ins.Addr,
+			BalancerAttributes: attributes.New(instanceAttrKey{}, ins),
+		})
+	}
+	return addrs
+}
+
+func (r *etcdResolver) update(instances []*ServiceInstance) {
+	addrs := instanceAddresses(instances)
+	if len(addrs) == 0 {
+		r.cc.ReportError(errors.Wrapf(ErrNoInstance, "service = %s", r.discovery.name))
+		return
+	}
+	_ = r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+// ResolveNow 本地缓存由watch实时更新，无需处理
+func (r *etcdResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *etcdResolver) Close() {
+	if r.unsubscribe != nil {
+		r.unsubscribe()
+	}
+	r.cancel()
+}
@@ -0,0 +1,82 @@
+package etcdtool
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func TestServiceInstance_Equal(t *testing.T) {
+	a := &ServiceInstance{Name: "im-gateway", ID: "a", Addr: "a:1", Labels: map[string]string{"env": "prod"}}
+	b := &ServiceInstance{Name: "im-gateway", ID: "a", Addr: "a:1", Labels: map[string]string{"env": "prod"}}
+	if !a.Equal(b) {
+		t.Fatal("same instance should be equal")
+	}
+	b.Labels["env"] = "test"
+	if a.Equal(b) {
+		t.Fatal("different labels should not be equal")
+	}
+	if a.Equal(&ServiceInstance{Name: "im-gateway", ID: "a", Addr: "a:1", Weight: 2, Labels: a.Labels}) {
+		t.Fatal("different weight should not be equal")
+	}
+	if a.Equal("a:1") || a.Equal((*ServiceInstance)(nil)) {
+		t.Fatal("other types or nil should not be equal")
+	}
+	var null *ServiceInstance
+	if !null.Equal((*ServiceInstance)(nil)) {
+		t.Fatal("nil should equal nil")
+	}
+}
+
+func TestInstanceAddresses(t *testing.T) {
+	instances := []*ServiceInstance{
+		{Name: "im-gateway", ID: "a", Addr: "10.0.0.1:8080", Weight: 2},
+		{Name: "im-gateway", ID: "b", Addr: "10.0.0.2:8080", Zone: "sh"},
+	}
+	addrs := instanceAddresses(instances)
+	if len(addrs) != 2 {
+		t.Fatalf("addrs = %+v", addrs)
+	}
+	for i, addr := range addrs {
+		if addr.Addr != instances[i].Addr {
+			t.Fatalf("addr = %s , want %s", addr.Addr, instances[i].Addr)
+		}
+		if ins := InstanceFromAddress(addr); ins != instances[i] {
+			t.Fatalf("instance = %+v , want %+v", ins, instances[i])
+		}
+	}
+	// 实例相同的地址属性相等 ，grpc 不会重建连接
+	again := instanceAddresses([]*ServiceInstance{{Name: "im-gateway", ID: "a", Addr: "10.0.0.1:8080", Weight: 2}})
+	if !again[0].Equal(addrs[0]) {
+		t.Fatal("addresses of equal instances should be equal")
+	}
+	if len(instanceAddresses(nil)) != 0 {
+		t.Fatal("no instances should give no addresses")
+	}
+	if InstanceFromAddress(resolver.Address{Addr: "a:1"}) != nil {
+		t.Fatal("address without instance")
+	}
+}
+
+func TestWeightRandomPicker(t *testing.T) {
+	if _, err := WeightRandomPicker("", nil); err != ErrNoInstance {
+		t.Fatalf("err = %v , want ErrNoInstance", err)
+	}
+	instances := []*ServiceInstance{
+		{ID: "a", Weight: 3},
+		{ID: "b", Weight: 0},
+		{ID: "c", Weight: -1},
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		ins, err := WeightRandomPicker("", instances)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[ins.ID]++
+	}
+	// 权重 3:1:1 ，a 约占 60%
+	if counts["a"] < 2700 || counts["a"] > 3300 || counts["b"] == 0 || counts["c"] == 0 {
+		t.Fatalf("counts = %v", counts)
+	}
+}
@@ -0,0 +1,182 @@
+package etcdtool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/ttime"
+	"github.com/heyehang/go-im-pkg/util"
+)
+
+const (
+	// 默认合并实例变化的时间窗口
+	DefaultRingDebounce = time.Millisecond * 500
+)
+
+// RingMoveFunc 环上节点变化后回调 ，moves 为归属发生变化的区间 ，instances 为变化后的全量实例
+// 可以用 HashRing.Hash 计算key 的位置 ，判断key 是否在迁移区间内
+type RingMoveFunc func(moves []util.RangeMove, instances []*ServiceInstance)
+
+type RingSyncOption func(r *RingSync)
+
+// WithRingDebounce 实例变化后等待 debounce 时间再更新环 ，期间的多次变化合并为一次
+func WithRingDebounce(debounce time.Duration) RingSyncOption {
+	return func(r *RingSync) {
+		if debounce >= 0 {
+			r.debounce = debounce
+		}
+	}
+}
+
+// WithRingClock debounce 使用的时钟 ，测试中使用 ttime.FakeClock ，默认使用系统时间
+func WithRingClock(clock ttime.Clock) RingSyncOption {
+	return func(r *RingSync) {
+		if clock != nil {
+			r.clock = clock
+		}
+	}
+}
+
+// WithRingNodeKey 实例在环上的节点名 ，默认使用 Addr
+func WithRingNodeKey(fn func(ins *ServiceInstance) string) RingSyncOption {
+	return func(r *RingSync) {
+		if fn != nil {
+			r.nodeKey = fn
+		}
+	}
+}
+
+// WithRingOnMove 区间归属变化回调
+func WithRingOnMove(fn RingMoveFunc) RingSyncOption {
+	return func(r *RingSync) {
+		r.onMove = fn
+	}
+}
+
+// RingSync 根据服务发现的实例自动维护 HashRing ，权重使用实例注册的 Weight ，可用区使用实例的 Zone
+// 多个进程需要一致的路由时 ，ring 请使用 util.WithFixedSpots 创建
+type RingSync struct {
+	discovery *Discovery
+	ring      *util.HashRing
+	debounce  time.Duration
+	clock     ttime.Clock
+	nodeKey   func(ins *ServiceInstance) string
+	onMove    RingMoveFunc
+
+	mu      sync.Mutex
+	pending []*ServiceInstance
+	// 每次变化递增 ，避免较早的实例列表覆盖较新的
+	seq      int64
+	deadline time.Time
+	waiting  bool
+	started  bool
+	stopped  bool
+	stopCh   chan struct{}
+	// 保证环的更新和回调串行执行
+	applyMu sync.Mutex
+	applied int64
+}
+
+func NewRingSync(discovery *Discovery, ring *util.HashRing, opts ...RingSyncOption) *RingSync {
+	r := &RingSync{
+		discovery: discovery,
+		ring:      ring,
+		debounce:  DefaultRingDebounce,
+		clock:     ttime.NewRealClock(),
+		stopCh:    make(chan struct{}),
+		nodeKey:   func(ins *ServiceInstance) string { return ins.Addr },
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](r)
+	}
+	return r
+}
+
+// Start 订阅服务发现 ，当前实例立即写入环 ，之后的变化按 debounce 合并 ，返回停止函数
+// discovery 需要先 Start ，停止后等待中的变化不再写入环
+func (r *RingSync) Start() (stop func()) {
+	unsubscribe := r.discovery.Subscribe(r.onChange)
+	return func() {
+		unsubscribe()
+		r.mu.Lock()
+		if !r.stopped {
+			r.stopped = true
+			close(r.stopCh)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *RingSync) onChange(instances []*ServiceInstance) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	r.seq++
+	// 第一次同步立即生效 ，保证 Start 返回后环可用
+	if !r.started || r.debounce == 0 {
+		r.started = true
+		seq := r.seq
+		r.mu.Unlock()
+		r.apply(instances, seq)
+		return
+	}
+	r.pending = instances
+	r.deadline = r.clock.Now().Add(r.debounce)
+	if !r.waiting {
+		r.waiting = true
+		go r.wait()
+	}
+	r.mu.Unlock()
+}
+
+// 等待到最后一次变化之后 debounce 时间再写入环 ，期间的变化只推迟截止时间
+func (r *RingSync) wait() {
+	for {
+		r.mu.Lock()
+		if r.stopped {
+			r.waiting = false
+			r.pending = nil
+			r.mu.Unlock()
+			return
+		}
+		d := r.deadline.Sub(r.clock.Now())
+		if d <= 0 {
+			instances, seq := r.pending, r.seq
+			r.pending = nil
+			r.waiting = false
+			r.mu.Unlock()
+			r.apply(instances, seq)
+			return
+		}
+		r.mu.Unlock()
+		select {
+		case <-r.clock.After(d):
+		case <-r.stopCh:
+		}
+	}
+}
+
+func (r *RingSync) apply(instances []*ServiceInstance, seq int64) {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	if seq <= r.applied {
+		return
+	}
+	r.applied = seq
+	nodes := make(map[string]int, len(instances))
+	zones := make(map[string]string, len(instances))
+	for i := 0; i < len(instances); i++ {
+		nodeKey := r.nodeKey(instances[i])
+		nodes[nodeKey] = instanceWeight(instances[i])
+		zones[nodeKey] = instances[i].Zone
+	}
+	before := r.ring.Ranges()
+	r.ring.SetZones(zones)
+	r.ring.SetNodes(nodes)
+	moves := util.DiffRanges(before, r.ring.Ranges())
+	if len(moves) > 0 && r.onMove != nil {
+		r.onMove(moves, instances)
+	}
+}
@@ -0,0 +1,103 @@
+package etcdtool
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/ttime"
+	"github.com/heyehang/go-im-pkg/util"
+)
+
+// 等待后台协程写入环
+func waitRingNodes(t *testing.T, ring *util.HashRing, want map[string]int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(ring.Nodes(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes = %v , want %v", ring.Nodes(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRingSync_Debounce(t *testing.T) {
+	clock := ttime.NewFakeClock(time.Time{})
+	d := NewDiscovery(nil, "im-gateway")
+	ring := util.NewHashRing(50)
+	var mu sync.Mutex
+	var moves [][]util.RangeMove
+	r := NewRingSync(d, ring, WithRingClock(clock), WithRingDebounce(time.Second),
+		WithRingOnMove(func(m []util.RangeMove, instances []*ServiceInstance) {
+			mu.Lock()
+			moves = append(moves, m)
+			mu.Unlock()
+		}))
+	stop := r.Start()
+	defer stop()
+
+	// 窗口内的多次变化合并为一次 ，每次变化都推迟截止时间
+	d.onEvents([]WatchEvent{instanceEvent(EtcdKeyCreate, "a")}, 2)
+	clock.BlockUntil(1)
+	clock.Advance(600 * time.Millisecond)
+	d.onEvents([]WatchEvent{instanceEvent(EtcdKeyCreate, "b")}, 3)
+	clock.Advance(400 * time.Millisecond)
+	clock.BlockUntil(1)
+	if len(ring.Nodes()) != 0 {
+		t.Fatalf("applied before debounce , nodes = %v", ring.Nodes())
+	}
+	clock.Advance(600 * time.Millisecond)
+	waitRingNodes(t, ring, map[string]int{"a:8080": 1, "b:8080": 1})
+	mu.Lock()
+	if len(moves) != 1 {
+		t.Fatalf("moves = %d , want 1", len(moves))
+	}
+	mu.Unlock()
+
+	// 删除节点后区间迁出
+	d.onEvents([]WatchEvent{instanceEvent(EtcdKeyDelete, "a")}, 4)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	waitRingNodes(t, ring, map[string]int{"b:8080": 1})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(moves) != 2 {
+		t.Fatalf("moves = %d , want 2", len(moves))
+	}
+	for _, m := range moves[1] {
+		if m.From != "a:8080" || m.To != "b:8080" {
+			t.Fatalf("move = %+v", m)
+		}
+	}
+}
+
+func TestRingSync_StopCancelsPending(t *testing.T) {
+	clock := ttime.NewFakeClock(time.Time{})
+	d := NewDiscovery(nil, "im-gateway")
+	ring := util.NewHashRing(50)
+	r := NewRingSync(d, ring, WithRingClock(clock), WithRingDebounce(time.Second))
+	stop := r.Start()
+
+	d.onEvents([]WatchEvent{instanceEvent(EtcdKeyCreate, "a")}, 2)
+	clock.BlockUntil(1)
+	stop()
+	// 等待后台协程退出
+	deadline := time.Now().Add(time.Second)
+	for {
+		r.mu.Lock()
+		waiting := r.waiting
+		r.mu.Unlock()
+		if !waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pending debounce not cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(2 * time.Second)
+	if len(ring.Nodes()) != 0 {
+		t.Fatalf("applied after stop , nodes = %v", ring.Nodes())
+	}
+}
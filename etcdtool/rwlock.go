@@ -0,0 +1,337 @@
+package etcdtool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	rwLockPrefix    = "etcd_rwlock_"
+	semaphorePrefix = "etcd_sem_"
+	rwReadPrefix    = "read/"
+	rwWritePrefix   = "write/"
+)
+
+// 持有者在etcd 中的排队key ，按创建revision 排序 ，会话过期后自动删除
+type queueKey struct {
+	session *concurrency.Session
+	key     string
+	rev     int64
+}
+
+// 创建会话并写入排队key
+func newQueueKey(ctx context.Context, etcd *EtcdTool, pfx string, o lockOptions) (q *queueKey, err error) {
+	ss, e := newLockSession(ctx, etcd, o, context.Background())
+	if e != nil {
+		err = errors.Wrapf(e, "NewSession_err")
+		return
+	}
+	key := fmt.Sprintf("%s%x", pfx, ss.Lease())
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	put := clientv3.OpPut(key, "", clientv3.WithLease(ss.Lease()))
+	get := clientv3.OpGet(key)
+	resp, e := etcd.Tool.Txn(ctx).If(cmp).Then(put).Else(get).Commit()
+	if e != nil {
+		_ = ss.Close()
+		err = errors.Wrapf(e, "put queue key err key = %s", key)
+		return
+	}
+	q = &queueKey{session: ss, key: key, rev: resp.Header.Revision}
+	if !resp.Succeeded {
+		q.rev = resp.Responses[0].GetResponseRange().Kvs[0].CreateRevision
+	}
+	return
+}
+
+// 删除排队key 并关闭会话
+func (q *queueKey) release(etcd *EtcdTool) (err error) {
+	ctx, cancel := etcd.withTimeout(context.Background())
+	defer cancel()
+	_, err = etcd.Tool.Delete(ctx, q.key)
+	// 关闭会话会撤销租约 ，删除失败key 也会被清理
+	_ = q.session.Close()
+	return
+}
+
+// 等待 pfx 下创建revision 小于等于 maxCreateRev 的key 全部被删除
+func waitDeletes(ctx context.Context, cli *clientv3.Client, pfx string, maxCreateRev int64) error {
+	opts := append(clientv3.WithLastCreate(), clientv3.WithMaxCreateRev(maxCreateRev))
+	for {
+		resp, err := cli.Get(ctx, pfx, opts...)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return nil
+		}
+		if err = waitDelete(ctx, cli, string(resp.Kvs[0].Key), resp.Header.Revision); err != nil {
+			return err
+		}
+	}
+}
+
+func waitDelete(ctx context.Context, cli *clientv3.Client, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wr := range cli.Watch(wctx, key, clientv3.WithRev(rev)) {
+		for _, ev := range wr.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+		if err := wr.Err(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Errorf("lost watcher waiting for delete key = %s", key)
+}
+
+// EtcdRWMutex 分布式读写锁 ，按创建revision 公平排队
+// 读锁只等待比自己早的写锁 ，写锁等待比自己早的所有读写锁 ，排队中的写锁会阻塞之后的读锁
+// 同一个对象同一时间只能持有一把锁 ，不同协程请使用不同的 EtcdRWMutex
+type EtcdRWMutex struct {
+	lockOptions
+	tool *EtcdTool
+	pfx  string
+	// 同一时间只有一个协程在加锁 ，等待etcd 时不持有 mu
+	acquiring chan struct{}
+
+	mu     sync.Mutex
+	holder *queueKey
+	isRead bool
+}
+
+// NewRWMutex 创建分布式读写锁 ，和同名的 EtcdLock、EtcdSemaphore 互不影响
+func (etcd *EtcdTool) NewRWMutex(lockKey string, opts ...LockOption) (rw *EtcdRWMutex, err error) {
+	if lockKey == "" || etcd.Tool == nil {
+		err = errors.Errorf("NewRWMutex_err args err lockKey = %s", lockKey)
+		return
+	}
+	rw = &EtcdRWMutex{
+		lockOptions: newLockOptions(opts),
+		tool:        etcd,
+		pfx:         rwLockPrefix + lockKey + "/",
+		acquiring:   make(chan struct{}, 1),
+	}
+	return
+}
+
+// RLock 获取读锁 ，阻塞直到获取成功或者ctx 结束
+func (rw *EtcdRWMutex) RLock(ctx context.Context) error {
+	return rw.acquire(ctx, true)
+}
+
+// Lock 获取写锁 ，阻塞直到获取成功或者ctx 结束
+func (rw *EtcdRWMutex) Lock(ctx context.Context) error {
+	return rw.acquire(ctx, false)
+}
+
+func (rw *EtcdRWMutex) acquire(ctx context.Context, isRead bool) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case rw.acquiring <- struct{}{}:
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "RWMutex_err")
+		return
+	}
+	defer func() { <-rw.acquiring }()
+	rw.mu.Lock()
+	held := rw.holder != nil
+	rw.mu.Unlock()
+	if held {
+		err = errors.Errorf("RWMutex_err already held key = %s", rw.pfx)
+		return
+	}
+	pfx := rw.pfx + rwWritePrefix
+	if isRead {
+		pfx = rw.pfx + rwReadPrefix
+	}
+	q, err := newQueueKey(ctx, rw.tool, pfx, rw.lockOptions)
+	if err != nil {
+		err = errors.Wrapf(err, "RWMutex_err")
+		return
+	}
+	// 读锁只关心更早的写锁 ，写锁关心更早的所有锁
+	waitPfx := rw.pfx
+	if isRead {
+		waitPfx = rw.pfx + rwWritePrefix
+	}
+	if e := waitDeletes(ctx, rw.tool.Tool, waitPfx, q.rev-1); e != nil {
+		_ = q.release(rw.tool)
+		err = errors.Wrapf(e, "RWMutex_err wait")
+		return
+	}
+	// 加锁成功后才对 RUnlock、Unlock 可见
+	rw.mu.Lock()
+	rw.holder = q
+	rw.isRead = isRead
+	rw.mu.Unlock()
+	return
+}
+
+// RUnlock 释放读锁
+func (rw *EtcdRWMutex) RUnlock() error {
+	return rw.release(true)
+}
+
+// Unlock 释放写锁
+func (rw *EtcdRWMutex) Unlock() error {
+	return rw.release(false)
+}
+
+func (rw *EtcdRWMutex) release(isRead bool) (err error) {
+	rw.mu.Lock()
+	q := rw.holder
+	if q == nil || rw.isRead != isRead {
+		rw.mu.Unlock()
+		err = ErrNotLocked
+		return
+	}
+	rw.holder = nil
+	rw.mu.Unlock()
+	// 请求etcd 时不持有 mu
+	if err = q.release(rw.tool); err != nil {
+		err = errors.Wrapf(err, "RWMutex_err release")
+	}
+	return
+}
+
+// EtcdSemaphore 分布式计数信号量 ，最多 n 个持有者 ，按创建revision 公平排队
+// 同一个对象同一时间只能持有一个名额 ，不同协程请使用不同的 EtcdSemaphore
+type EtcdSemaphore struct {
+	lockOptions
+	tool *EtcdTool
+	pfx  string
+	n    int64
+	// 同一时间只有一个协程在获取名额 ，等待etcd 时不持有 mu
+	acquiring chan struct{}
+
+	mu     sync.Mutex
+	holder *queueKey
+}
+
+// NewSemaphore 创建最多 n 个持有者的信号量
+func (etcd *EtcdTool) NewSemaphore(key string, n int, opts ...LockOption) (sem *EtcdSemaphore, err error) {
+	if key == "" || n <= 0 || etcd.Tool == nil {
+		err = errors.Errorf("NewSemaphore_err args err key = %s , n = %d", key, n)
+		return
+	}
+	sem = &EtcdSemaphore{
+		lockOptions: newLockOptions(opts),
+		tool:        etcd,
+		pfx:         semaphorePrefix + key + "/",
+		n:           int64(n),
+		acquiring:   make(chan struct{}, 1),
+	}
+	return
+}
+
+// Acquire 获取一个名额 ，阻塞直到获取成功或者ctx 结束
+func (sem *EtcdSemaphore) Acquire(ctx context.Context) error {
+	return sem.acquire(ctx, false)
+}
+
+// TryAcquire 尝试获取一个名额 ，没有空闲名额立即返回 ErrLocked
+func (sem *EtcdSemaphore) TryAcquire(ctx context.Context) error {
+	return sem.acquire(ctx, true)
+}
+
+func (sem *EtcdSemaphore) acquire(ctx context.Context, try bool) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case sem.acquiring <- struct{}{}:
+	case <-ctx.Done():
+		err = errors.Wrapf(ctx.Err(), "Semaphore_err")
+		return
+	}
+	defer func() { <-sem.acquiring }()
+	sem.mu.Lock()
+	held := sem.holder != nil
+	sem.mu.Unlock()
+	if held {
+		err = errors.Errorf("Semaphore_err already held key = %s", sem.pfx)
+		return
+	}
+	q, err := newQueueKey(ctx, sem.tool, sem.pfx, sem.lockOptions)
+	if err != nil {
+		err = errors.Wrapf(err, "Semaphore_err")
+		return
+	}
+	for {
+		// 排在自己前面(包含自己)的持有者数量
+		resp, e := sem.tool.Tool.Get(ctx, sem.pfx, clientv3.WithPrefix(), clientv3.WithCountOnly(),
+			clientv3.WithMaxCreateRev(q.rev))
+		if e != nil {
+			err = errors.Wrapf(e, "Semaphore_err count")
+			break
+		}
+		if resp.Count <= sem.n {
+			sem.mu.Lock()
+			sem.holder = q
+			sem.mu.Unlock()
+			return
+		}
+		if try {
+			err = ErrLocked
+			break
+		}
+		// 等待前面任意一个持有者释放
+		if e = sem.waitRelease(ctx, q.rev, resp.Header.Revision); e != nil {
+			err = errors.Wrapf(e, "Semaphore_err wait")
+			break
+		}
+	}
+	_ = q.release(sem.tool)
+	return
+}
+
+func (sem *EtcdSemaphore) waitRelease(ctx context.Context, myRev, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wr := range sem.tool.Tool.Watch(wctx, sem.pfx, clientv3.WithPrefix(), clientv3.WithRev(rev+1),
+		clientv3.WithFilterPut(), clientv3.WithPrevKV()) {
+		if err := wr.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wr.Events {
+			if ev.PrevKv == nil || ev.PrevKv.CreateRevision < myRev {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Errorf("lost watcher waiting for release key = %s", sem.pfx)
+}
+
+// Release 释放名额
+func (sem *EtcdSemaphore) Release() (err error) {
+	sem.mu.Lock()
+	q := sem.holder
+	if q == nil {
+		sem.mu.Unlock()
+		err = ErrNotLocked
+		return
+	}
+	sem.holder = nil
+	sem.mu.Unlock()
+	// 请求etcd 时不持有 mu
+	if err = q.release(sem.tool); err != nil {
+		err = errors.Wrapf(err, "Semaphore_err release")
+	}
+	return
+}
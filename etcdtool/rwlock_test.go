@@ -0,0 +1,218 @@
+package etcdtool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 在后台加锁 ，返回结果通道
+func acquireAsync(fn func(ctx context.Context) error) chan error {
+	done := make(chan error, 1)
+	go func() { done <- fn(context.Background()) }()
+	return done
+}
+
+// 确认还在等待
+func assertBlocked(t *testing.T, done chan error, msg string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("%s , err = %v", msg, err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertAcquired(t *testing.T, done chan error, msg string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%s , err = %v", msg, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal(msg)
+	}
+}
+
+// 等待前缀下的排队key 数量
+func waitKeys(t *testing.T, f *fakeKV, prefix string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(f.keys(prefix)) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("keys = %v , want %d", f.keys(prefix), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEtcdRWMutex_Order(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	newRW := func() *EtcdRWMutex {
+		rw, err := etcd.NewRWMutex("room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rw
+	}
+	r1, r2, w, r3 := newRW(), newRW(), newRW(), newRW()
+	pfx := rwLockPrefix + "room/"
+	ctx := context.Background()
+
+	// 读锁之间不互斥
+	if err := r1.RLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.RLock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 写锁等待更早的读锁
+	wDone := acquireAsync(w.Lock)
+	waitKeys(t, f, pfx, 3)
+	assertBlocked(t, wDone, "write lock acquired while read locks are held")
+	// 排队中的写锁阻塞之后的读锁
+	r3Done := acquireAsync(r3.RLock)
+	waitKeys(t, f, pfx, 4)
+	assertBlocked(t, r3Done, "read lock jumped ahead of a queued write lock")
+
+	if err := r1.RUnlock(); err != nil {
+		t.Fatal(err)
+	}
+	assertBlocked(t, wDone, "write lock acquired while a read lock is held")
+	if err := r2.Unlock(); err != ErrNotLocked {
+		t.Fatalf("Unlock of a read lock err = %v , want ErrNotLocked", err)
+	}
+	if err := r2.RUnlock(); err != nil {
+		t.Fatal(err)
+	}
+	assertAcquired(t, wDone, "write lock not acquired after readers released")
+	assertBlocked(t, r3Done, "read lock acquired while the write lock is held")
+	if err := w.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	assertAcquired(t, r3Done, "read lock not acquired after writer released")
+	if err := r3.RUnlock(); err != nil {
+		t.Fatal(err)
+	}
+	waitKeys(t, f, pfx, 0)
+}
+
+func TestEtcdRWMutex_CtxCancel(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	r, _ := etcd.NewRWMutex("room")
+	w, _ := etcd.NewRWMutex("room")
+	pfx := rwLockPrefix + "room/"
+	if err := r.RLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 等待超时后删除自己的排队key ，不阻塞之后的读锁
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := w.Lock(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Lock err = %v , want deadline exceeded", err)
+	}
+	waitKeys(t, f, pfx, 1)
+	if err := w.Unlock(); err != ErrNotLocked {
+		t.Fatalf("Unlock err = %v , want ErrNotLocked", err)
+	}
+	if err := w.RLock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.RUnlock()
+	_ = r.RUnlock()
+	waitKeys(t, f, pfx, 0)
+}
+
+func TestEtcdRWMutex_AcquireInFlight(t *testing.T) {
+	rw, _ := newFakeTool(newFakeKV()).NewRWMutex("room")
+	// 模拟另一个协程正在等待etcd 加锁 ，解锁不会被阻塞
+	rw.acquiring <- struct{}{}
+	done := make(chan error, 2)
+	go func() {
+		done <- rw.RUnlock()
+		done <- rw.Unlock()
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != ErrNotLocked {
+				t.Fatalf("err = %v , want ErrNotLocked", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("unlock blocked by an in-flight acquire")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := rw.Lock(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Lock err = %v , want deadline exceeded", err)
+	}
+}
+
+func TestEtcdSemaphore_Limit(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	newSem := func() *EtcdSemaphore {
+		sem, err := etcd.NewSemaphore("push", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sem
+	}
+	s1, s2, s3, s4 := newSem(), newSem(), newSem(), newSem()
+	pfx := semaphorePrefix + "push/"
+	ctx := context.Background()
+
+	if err := s1.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.TryAcquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 名额已满 ，立即返回并删除排队key
+	if err := s3.TryAcquire(ctx); err != ErrLocked {
+		t.Fatalf("TryAcquire err = %v , want ErrLocked", err)
+	}
+	waitKeys(t, f, pfx, 2)
+	if err := s3.Release(); err != ErrNotLocked {
+		t.Fatalf("Release err = %v , want ErrNotLocked", err)
+	}
+
+	s4Done := acquireAsync(s4.Acquire)
+	waitKeys(t, f, pfx, 3)
+	assertBlocked(t, s4Done, "acquired beyond the limit")
+	if err := s1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	assertAcquired(t, s4Done, "not acquired after a holder released")
+
+	// 等待中取消 ，排队key 被删除
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s3.Acquire(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Acquire err = %v , want deadline exceeded", err)
+	}
+	waitKeys(t, f, pfx, 2)
+
+	_ = s2.Release()
+	_ = s4.Release()
+	waitKeys(t, f, pfx, 0)
+}
+
+func TestNewSemaphore_Args(t *testing.T) {
+	etcd := newFakeTool(newFakeKV())
+	if _, err := etcd.NewSemaphore("push", 0); err == nil {
+		t.Fatal("n = 0 should fail")
+	}
+	if _, err := etcd.NewSemaphore("", 1); err == nil {
+		t.Fatal("empty key should fail")
+	}
+	if _, err := etcd.NewRWMutex(""); err == nil {
+		t.Fatal("empty key should fail")
+	}
+}
@@ -2,7 +2,6 @@ package etcdtool
 
 import (
 	"context"
-	"runtime"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,8 +15,58 @@ const (
 	EtcdKeyModify = "MODIFY"
 )
 
+const (
+	// 默认请求超时时间
+	DefaultTimeout = time.Second * 10
+)
+
 type EtcdTool struct {
-	Tool *clientv3.Client
+	Tool    *clientv3.Client
+	timeout time.Duration
+	txnStat txnStats
+}
+
+type Option func(etcd *EtcdTool)
+
+// WithTimeout 调用方ctx 未设置超时时间时使用的默认超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(etcd *EtcdTool) {
+		if timeout > 0 {
+			etcd.timeout = timeout
+		}
+	}
+}
+
+// New 创建一个独立的 EtcdTool ，可以同时连接多个集群
+func New(cli *clientv3.Client, opts ...Option) *EtcdTool {
+	etcd := &EtcdTool{
+		Tool:    cli,
+		timeout: DefaultTimeout,
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](etcd)
+	}
+	return etcd
+}
+
+// ctx 已有超时时间直接使用 ，否则加上默认超时时间
+func (etcd *EtcdTool) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	timeout := etcd.timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// TimeoutContext 和 EtcdTool 的方法使用相同的超时规则 ，直接通过 Tool 请求etcd 时使用
+func (etcd *EtcdTool) TimeoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return etcd.withTimeout(ctx)
 }
 
 // leaseId 租约id ，保活使用
@@ -31,20 +80,20 @@ func GetEtcdTool() *EtcdTool {
 // status 取值 EtcdKeyDelete ,EtcdKeyCreate , EtcdKeyModify
 type DidChangeFunc func(status, key, value string)
 
-// put 值
+// put 值 ，timeout 租约时间 单位秒
 func (etcd *EtcdTool) PutWithTimeOut(key, value string, timeout int) (err error) {
 	if key == "" {
 		err = errors.Errorf("PutWithTimeOut_err key is empty ")
 		return
 	}
-	lease, e := etcdcli.Grant(context.Background(), int64(timeout))
+	ctx, cancel := etcd.withTimeout(context.Background())
+	defer cancel()
+	lease, e := etcd.Tool.Grant(ctx, int64(timeout))
 	if e != nil {
 		err = errors.Errorf("PutWithTimeOut_err grant err %+v\n ", e)
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
-	defer cancel()
-	_, e = etcdcli.Put(ctx, key, value, clientv3.WithLease(lease.ID))
+	_, e = etcd.Tool.Put(ctx, key, value, clientv3.WithLease(lease.ID))
 	if e != nil {
 		err = errors.Errorf("PutWithTimeOut_err %+v \n", e)
 		return
@@ -58,10 +107,9 @@ func (etcd *EtcdTool) PutWithLeaseId(key, value string, leaseId int64) (err erro
 		err = errors.Errorf("PutWithLeaseId_err key is empty ")
 		return
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := etcd.withTimeout(context.Background())
 	defer cancel()
-	_, e := etcdcli.Put(ctx, key, value, clientv3.WithLease(clientv3.LeaseID(leaseId)))
+	_, e := etcd.Tool.Put(ctx, key, value, clientv3.WithLease(clientv3.LeaseID(leaseId)))
 	if e != nil {
 		err = errors.Errorf("PutValue_err %+v \n", e)
 		return
@@ -71,13 +119,18 @@ func (etcd *EtcdTool) PutWithLeaseId(key, value string, leaseId int64) (err erro
 
 // put
 func (etcd *EtcdTool) Put(key, value string) (err error) {
+	return etcd.PutCtx(context.Background(), key, value)
+}
+
+// PutCtx ctx 没有设置超时时间时使用默认超时时间
+func (etcd *EtcdTool) PutCtx(ctx context.Context, key, value string) (err error) {
 	if key == "" {
 		err = errors.Errorf("PutValue_err key is empty ")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := etcd.withTimeout(ctx)
 	defer cancel()
-	_, e := etcdcli.Put(ctx, key, value)
+	_, e := etcd.Tool.Put(ctx, key, value)
 	if e != nil {
 		err = errors.Errorf("PutValue_err %+v \n", e)
 		return
@@ -86,13 +139,17 @@ func (etcd *EtcdTool) Put(key, value string) (err error) {
 }
 
 func (etcd *EtcdTool) Delete(key string) (err error) {
+	return etcd.DeleteCtx(context.Background(), key)
+}
+
+func (etcd *EtcdTool) DeleteCtx(ctx context.Context, key string) (err error) {
 	if key == "" {
 		err = errors.Errorf("Delete_err key is empty ")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	ctx, cancel := etcd.withTimeout(ctx)
 	defer cancel()
-	_, e := etcdcli.Delete(ctx, key)
+	_, e := etcd.Tool.Delete(ctx, key)
 	if e != nil {
 		err = errors.Wrapf(e, "Delete_err ")
 		return
@@ -101,54 +158,63 @@ func (etcd *EtcdTool) Delete(key string) (err error) {
 }
 
 func (etcd *EtcdTool) DeletePrefix(prefixKey string) (datas []string, err error) {
+	return etcd.DeletePrefixCtx(context.Background(), prefixKey)
+}
+
+// DeletePrefixCtx 删除前缀下的数据
+// datas 为兼容保留 ，始终为空 ，需要被删除的数据时使用 DeletePrefixKVs ，大前缀下返回旧数据开销较大
+func (etcd *EtcdTool) DeletePrefixCtx(ctx context.Context, prefixKey string) (datas []string, err error) {
 	if prefixKey == "" {
 		err = errors.Errorf("DeletePrefix_err key is empty ")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
-	defer cancel()
-
-	_, e := etcdcli.Delete(ctx, prefixKey, clientv3.WithPrefix())
-	if e != nil {
-		err = errors.Wrapf(e, "GetPrefixValue_err")
+	if _, _, err = etcd.DeletePrefixKVs(ctx, prefixKey, false); err != nil {
+		err = errors.Wrapf(err, "DeletePrefix_err")
 		return
 	}
-
 	return
 }
 
 // 失败返回err ,成功返回数据 或者空字符
 func (etcd *EtcdTool) Get(key string) (data string, err error) {
+	return etcd.GetCtx(context.Background(), key)
+}
+
+func (etcd *EtcdTool) GetCtx(ctx context.Context, key string) (data string, err error) {
 	if key == "" {
 		err = errors.Errorf("GetValue_err key is empty ")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	ctx, cancel := etcd.withTimeout(ctx)
 	defer cancel()
 
-	resp, e := etcdcli.Get(ctx, key)
+	resp, e := etcd.Tool.Get(ctx, key)
 	if e != nil {
 		err = errors.Wrapf(e, "GetValue_err")
 		return
 	}
 	// 未获取到数据
-	if resp == nil || resp.Kvs == nil {
+	if resp == nil || len(resp.Kvs) == 0 {
 		return
 	}
 	data = string(resp.Kvs[0].Value)
 	return
 }
 
-// 失败返回err ,成功返回数据 或者 返回nil
+// 失败返回err ,成功返回数据 key -> value 或者 返回nil
 func (etcd *EtcdTool) GetPrefix(prefixKey string) (datas map[string]string, err error) {
+	return etcd.GetPrefixCtx(context.Background(), prefixKey)
+}
+
+func (etcd *EtcdTool) GetPrefixCtx(ctx context.Context, prefixKey string) (datas map[string]string, err error) {
 	if prefixKey == "" {
 		err = errors.Errorf("GetPrefixValue_err key is empty ")
 		return
 	}
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Second*10)
+	ctx, cancel := etcd.withTimeout(ctx)
 	defer cancel()
 
-	resp, e := etcdcli.Get(ctx, prefixKey, clientv3.WithPrefix())
+	resp, e := etcd.Tool.Get(ctx, prefixKey, clientv3.WithPrefix())
 	if e != nil {
 		err = errors.Wrapf(e, "GetPrefixValue_err")
 		return
@@ -160,7 +226,7 @@ func (etcd *EtcdTool) GetPrefix(prefixKey string) (datas map[string]string, err
 	tmp := make(map[string]string, 10)
 	for i := 0; i < len(resp.Kvs); i++ {
 		value := string(resp.Kvs[i].Value)
-		tmp[resp.Kvs[i].String()] = value
+		tmp[string(resp.Kvs[i].Key)] = value
 	}
 	if len(tmp) > 0 {
 		datas = tmp
@@ -168,26 +234,13 @@ func (etcd *EtcdTool) GetPrefix(prefixKey string) (datas map[string]string, err
 	return
 }
 
-// 监控一个带前缀的key
-func (etcd *EtcdTool) WatchPrefix(prefixKey string, ctx context.Context, changeFunc DidChangeFunc) (err error) {
+// 监控一个带前缀的key ，断开后自动从最后的revision恢复 ，ctx 结束后停止
+func (etcd *EtcdTool) WatchPrefix(prefixKey string, ctx context.Context, changeFunc DidChangeFunc, opts ...WatchOption) (err error) {
 	if prefixKey == "" || ctx == nil {
 		err = errors.Errorf("WatchPrefix_err args err prefixkey = %s , ctx = %+v \n", prefixKey, ctx)
 		return
 	}
-	go func() {
-		watchChan := etcdcli.Watch(context.TODO(), prefixKey, clientv3.WithPrefix())
-		for {
-			select {
-			case data := <-watchChan:
-				handleEvents(data, "WatchPrefix_trace", changeFunc)
-			case <-ctx.Done():
-				//logger.Info("WatchPrefix_info watch key finished prefixKey", prefixKey)
-				return
-			}
-			runtime.Gosched()
-		}
-	}()
-
+	_, err = etcd.Watch(ctx, prefixKey, changeFunc, append(opts, WithWatchPrefix())...)
 	return
 }
 
@@ -218,40 +271,26 @@ func handleEvents(data clientv3.WatchResponse, logPrefix string, changeFunc DidC
 	}
 }
 
-// 监控一个带前缀的key
-func (etcd *EtcdTool) WatchKey(watchKey string, ctx context.Context, changeFunc DidChangeFunc) (err error) {
-	//pre := "WatchKey"
+// 监控一个key ，断开后自动从最后的revision恢复 ，ctx 结束后停止
+func (etcd *EtcdTool) WatchKey(watchKey string, ctx context.Context, changeFunc DidChangeFunc, opts ...WatchOption) (err error) {
 	if watchKey == "" || ctx == nil {
 		err = errors.Errorf("WatchKey_err args err prefixkey = %s , ctx = %+v \n", watchKey, ctx)
 		return
 	}
-	go func() {
-		watchChan := etcdcli.Watch(context.TODO(), watchKey)
-		for {
-			select {
-			case data := <-watchChan:
-				handleEvents(data, "WatchKey_trace", changeFunc)
-			case <-ctx.Done():
-				//str := fmt.Sprintf("WatchPrefix_info watch key finished prefixKey = %s \n", watchKey)
-				//logger.Info(pre, str)
-				return
-			}
-			runtime.Gosched()
-		}
-
-	}()
-
+	_, err = etcd.Watch(ctx, watchKey, changeFunc, opts...)
 	return
 }
 
-// 注册一个key 并且 使用租约保活
+// 注册一个key 并且 使用租约保活 ，ctx 结束后停止保活 ，key 在租约过期后删除
 func (etcd *EtcdTool) RegistKeyAndKeepAlive(key, value string, ctx context.Context, callBack WatchHandleCallBack) (err error) {
 	if key == "" || ctx == nil {
 		err = errors.New("RegistKeyAndKeepAlive_err key or context is empty ")
 		return
 	}
 	//pre := "RegistKeyAndKeepAlive"
-	lease, e := etcd.Tool.Grant(context.TODO(), 6)
+	gctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	lease, e := etcd.Tool.Grant(gctx, 6)
 	if e != nil || lease == nil {
 		err = errors.Wrapf(e, "RegistKeyAndKeepAlive_err")
 		//logger.Error(err, pre, fmt.Sprintf("RegistKeyAndKeepAlive_err create lease err %+v , lease %+v \n", e, lease))
@@ -259,7 +298,7 @@ func (etcd *EtcdTool) RegistKeyAndKeepAlive(key, value string, ctx context.Conte
 	}
 	//str := fmt.Sprintf("RegistKeyAndKeepAlive_info create lease succ lease.id %d , key %s , value = %s  \n", lease.ID, key, value)
 	//logger.Info(pre, str)
-	_, e = etcd.Tool.Put(context.TODO(), key, value, clientv3.WithLease(lease.ID))
+	_, e = etcd.Tool.Put(gctx, key, value, clientv3.WithLease(lease.ID))
 	if e != nil {
 		err = errors.Wrapf(e, "RegistKeyAndKeepAlive_err put value err %+v , key %s \n", e, key)
 		//str := fmt.Sprintf("RegistKeyAndKeepAlive_err put value err %+v , key %s \n", e, key)
@@ -267,7 +306,8 @@ func (etcd *EtcdTool) RegistKeyAndKeepAlive(key, value string, ctx context.Conte
 		return
 	}
 
-	watchChan, e := etcd.Tool.KeepAlive(context.TODO(), lease.ID)
+	// 保活的生命周期是调用方ctx ，不能加超时时间
+	watchChan, e := etcd.Tool.KeepAlive(ctx, lease.ID)
 	if e != nil {
 		err = errors.Wrapf(e, "RegistKeyAndKeepAlive_err")
 		//str := fmt.Sprintf("RegistKeyAndKeepAlive_err keepalive err %+v , key %s \n", err, key)
@@ -278,7 +318,11 @@ func (etcd *EtcdTool) RegistKeyAndKeepAlive(key, value string, ctx context.Conte
 	go func() {
 		for {
 			select {
-			case resp := <-watchChan:
+			case resp, ok := <-watchChan:
+				// 租约过期或者ctx 结束后通道关闭
+				if !ok {
+					return
+				}
 				if resp != nil && callBack != nil {
 					callBack(key, int64(resp.ID), etcd)
 				}
@@ -0,0 +1,81 @@
+package etcdtool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNew_WithTimeout(t *testing.T) {
+	// 默认超时时间
+	etcd := New(nil)
+	ctx, cancel := etcd.withTimeout(context.Background())
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > DefaultTimeout || time.Until(deadline) < DefaultTimeout-time.Second {
+		t.Fatalf("deadline = %v , ok = %v", deadline, ok)
+	}
+	ctx, cancel = etcd.withTimeout(nil)
+	if _, ok = ctx.Deadline(); !ok {
+		t.Fatal("nil ctx should get the default timeout")
+	}
+	cancel()
+
+	// 配置的超时时间 ，非法值忽略
+	etcd = New(nil, WithTimeout(time.Second), WithTimeout(-1))
+	ctx, cancel = etcd.withTimeout(context.Background())
+	deadline, _ = ctx.Deadline()
+	cancel()
+	if time.Until(deadline) > time.Second {
+		t.Fatalf("deadline = %v , want within 1s", deadline)
+	}
+
+	// 调用方的超时时间优先 ，即使比默认值长
+	parent, pcancel := context.WithTimeout(context.Background(), time.Hour)
+	defer pcancel()
+	want, _ := parent.Deadline()
+	ctx, cancel = etcd.withTimeout(parent)
+	defer cancel()
+	if deadline, _ = ctx.Deadline(); !deadline.Equal(want) {
+		t.Fatalf("deadline = %v , want %v", deadline, want)
+	}
+}
+
+func TestRegistKeyAndKeepAlive(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	leases := make(chan int64, 8)
+	err := etcd.RegistKeyAndKeepAlive("/im/node/a", "10.0.0.1", ctx, func(key string, leaseId int64, tool *EtcdTool) {
+		select {
+		case leases <- leaseId:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv := f.get("/im/node/a")
+	if kv == nil || string(kv.Value) != "10.0.0.1" || kv.Lease == 0 {
+		t.Fatalf("kv = %+v", kv)
+	}
+	select {
+	case id := <-leases:
+		if id != kv.Lease {
+			t.Fatalf("lease = %d , want %d", id, kv.Lease)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no keepalive callback")
+	}
+
+	// 调用方ctx 已经超时 ，不会发起请求
+	dctx, dcancel := context.WithTimeout(context.Background(), -time.Second)
+	defer dcancel()
+	if err = etcd.RegistKeyAndKeepAlive("/im/node/b", "10.0.0.2", dctx, nil); err == nil {
+		t.Fatal("expired ctx should fail")
+	}
+	if f.get("/im/node/b") != nil {
+		t.Fatal("key should not be written")
+	}
+}
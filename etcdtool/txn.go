@@ -0,0 +1,202 @@
+package etcdtool
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// Update 默认最大重试次数
+	DefaultUpdateMaxRetries = 10
+)
+
+var (
+	// ErrTooManyRetries Update 冲突重试次数用完
+	ErrTooManyRetries = errors.New("etcd txn too many retries")
+	// ErrSkipUpdate UpdateFunc 返回该错误(包括包装后的)时不写入，Update 返回当前值
+	ErrSkipUpdate = errors.New("etcd txn skip update")
+)
+
+// UpdateFunc 根据旧值计算新值 ，key 不存在时 old 为nil
+type UpdateFunc func(old *KeyValue) (newValue string, err error)
+
+// TxnStats 事务统计
+type TxnStats struct {
+	// 提交次数 ，包含重试
+	Attempts int64
+	// 成功次数
+	Succeeded int64
+	// 比较失败次数
+	Conflicts int64
+	// Update 重试次数用完的次数
+	Exhausted int64
+}
+
+type txnStats struct {
+	attempts  int64
+	succeeded int64
+	conflicts int64
+	exhausted int64
+}
+
+// TxnStats 当前事务统计 ，可以上报监控
+func (etcd *EtcdTool) TxnStats() TxnStats {
+	return TxnStats{
+		Attempts:  atomic.LoadInt64(&etcd.txnStat.attempts),
+		Succeeded: atomic.LoadInt64(&etcd.txnStat.succeeded),
+		Conflicts: atomic.LoadInt64(&etcd.txnStat.conflicts),
+		Exhausted: atomic.LoadInt64(&etcd.txnStat.exhausted),
+	}
+}
+
+// 执行事务并记录统计
+func (etcd *EtcdTool) commit(txn clientv3.Txn) (resp *clientv3.TxnResponse, err error) {
+	atomic.AddInt64(&etcd.txnStat.attempts, 1)
+	resp, err = txn.Commit()
+	if err != nil {
+		return
+	}
+	if resp.Succeeded {
+		atomic.AddInt64(&etcd.txnStat.succeeded, 1)
+	} else {
+		atomic.AddInt64(&etcd.txnStat.conflicts, 1)
+	}
+	return
+}
+
+// PutIfAbsent key 不存在时写入 ，ok 表示是否写入成功 ，opts 可以传入 clientv3.WithLease 等
+func (etcd *EtcdTool) PutIfAbsent(ctx context.Context, key, value string, opts ...clientv3.OpOption) (ok bool, err error) {
+	return etcd.PutIfRevision(ctx, key, value, 0, opts...)
+}
+
+// PutIfRevision key 的 ModRevision 等于 modRevision 时写入 ，modRevision 为0 表示key 不存在时写入
+func (etcd *EtcdTool) PutIfRevision(ctx context.Context, key, value string, modRevision int64, opts ...clientv3.OpOption) (ok bool, err error) {
+	if key == "" || modRevision < 0 {
+		err = errors.Errorf("PutIfRevision_err args err key = %s , modRevision = %d", key, modRevision)
+		return
+	}
+	ctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	txn := etcd.Tool.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpPut(key, value, opts...))
+	resp, e := etcd.commit(txn)
+	if e != nil {
+		err = errors.Wrapf(e, "PutIfRevision_err key = %s", key)
+		return
+	}
+	ok = resp.Succeeded
+	return
+}
+
+// DeleteIfValue key 的值等于 value 时删除
+func (etcd *EtcdTool) DeleteIfValue(ctx context.Context, key, value string) (ok bool, err error) {
+	if key == "" {
+		err = errors.Errorf("DeleteIfValue_err key is empty ")
+		return
+	}
+	ctx, cancel := etcd.withTimeout(ctx)
+	defer cancel()
+	txn := etcd.Tool.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", value)).
+		Then(clientv3.OpDelete(key))
+	resp, e := etcd.commit(txn)
+	if e != nil {
+		err = errors.Wrapf(e, "DeleteIfValue_err key = %s", key)
+		return
+	}
+	ok = resp.Succeeded
+	return
+}
+
+type updateOptions struct {
+	maxRetries int
+	putOpts    []clientv3.OpOption
+}
+
+type UpdateOption func(o *updateOptions)
+
+// WithUpdateMaxRetries 冲突后的最大重试次数
+func WithUpdateMaxRetries(n int) UpdateOption {
+	return func(o *updateOptions) {
+		if n > 0 {
+			o.maxRetries = n
+		}
+	}
+}
+
+// WithUpdatePutOptions 写入时使用的参数 ，默认保留旧值绑定的租约 ，传入 clientv3.WithLease 可以替换
+func WithUpdatePutOptions(opts ...clientv3.OpOption) UpdateOption {
+	return func(o *updateOptions) {
+		o.putOpts = append(o.putOpts, opts...)
+	}
+}
+
+// Update 读取-修改-写入 ，写入时比较 ModRevision ，冲突后使用最新值重新调用 fn
+// 超过重试次数返回 ErrTooManyRetries ，fn 返回 ErrSkipUpdate 时不写入直接返回当前值
+// 写入时保留旧值绑定的租约 ，返回写入后的数据
+func (etcd *EtcdTool) Update(ctx context.Context, key string, fn UpdateFunc, opts ...UpdateOption) (kv *KeyValue, err error) {
+	if key == "" || fn == nil {
+		err = errors.Errorf("Update_err args err key = %s", key)
+		return
+	}
+	o := &updateOptions{maxRetries: DefaultUpdateMaxRetries}
+	for i := 0; i < len(opts); i++ {
+		opts[i](o)
+	}
+	old, err := etcd.GetKV(ctx, key)
+	if err != nil {
+		err = errors.Wrapf(err, "Update_err")
+		return
+	}
+	for i := 0; i <= o.maxRetries; i++ {
+		newValue, e := fn(old)
+		// fn 可能使用 errors.Wrap 包装
+		if errors.Cause(e) == ErrSkipUpdate {
+			kv = old
+			return
+		}
+		if e != nil {
+			err = e
+			return
+		}
+		var modRevision int64
+		putOpts := o.putOpts
+		if old != nil {
+			modRevision = old.ModRevision
+			// 不带租约的 put 会解除绑定 ，先使用旧租约 ，调用方传入的参数在后面可以覆盖
+			if old.Lease != 0 {
+				putOpts = append([]clientv3.OpOption{clientv3.WithLease(clientv3.LeaseID(old.Lease))}, o.putOpts...)
+			}
+		}
+		tctx, cancel := etcd.withTimeout(ctx)
+		txn := etcd.Tool.Txn(tctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+			Then(clientv3.OpPut(key, newValue, putOpts...), clientv3.OpGet(key)).
+			Else(clientv3.OpGet(key))
+		resp, e := etcd.commit(txn)
+		cancel()
+		if e != nil {
+			err = errors.Wrapf(e, "Update_err key = %s", key)
+			return
+		}
+		if resp.Succeeded {
+			// 同一个事务中读取写入后的数据 ，包含租约、版本号
+			if rng := resp.Responses[1].GetResponseRange(); rng != nil && len(rng.Kvs) > 0 {
+				kv = newKeyValue(rng.Kvs[0])
+			}
+			return
+		}
+		// 冲突 ，使用 else 分支读取到的最新值重试
+		old = nil
+		if rng := resp.Responses[0].GetResponseRange(); rng != nil && len(rng.Kvs) > 0 {
+			old = newKeyValue(rng.Kvs[0])
+		}
+	}
+	atomic.AddInt64(&etcd.txnStat.exhausted, 1)
+	err = errors.Wrapf(ErrTooManyRetries, "Update_err key = %s , retries = %d", key, o.maxRetries)
+	return
+}
@@ -0,0 +1,141 @@
+package etcdtool
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func incr(old *KeyValue) (string, error) {
+	n := 0
+	if old != nil {
+		n, _ = strconv.Atoi(old.Value)
+	}
+	return strconv.Itoa(n + 1), nil
+}
+
+func TestUpdate_KeepLease(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	f.set("counter", "1", 7)
+
+	kv, err := etcd.Update(context.Background(), "counter", incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv.Value != "2" || kv.Lease != 7 || kv.Version != 2 {
+		t.Fatalf("kv = %+v", kv)
+	}
+	if got := f.get("counter"); got.Lease != 7 {
+		t.Fatalf("stored lease = %d , want 7", got.Lease)
+	}
+
+	// 调用方传入的租约覆盖旧租约
+	kv, err = etcd.Update(context.Background(), "counter", incr, WithUpdatePutOptions(clientv3.WithLease(9)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv.Value != "3" || kv.Lease != 9 {
+		t.Fatalf("kv = %+v", kv)
+	}
+}
+
+func TestUpdate_Conflict(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	f.set("counter", "1", 0)
+
+	// 前两次提交前都有其他客户端写入
+	conflicts := 2
+	f.beforeTxn = func(f *fakeKV) {
+		if conflicts > 0 {
+			conflicts--
+			f.set("counter", "10", 0)
+		}
+	}
+	var seen []string
+	kv, err := etcd.Update(context.Background(), "counter", func(old *KeyValue) (string, error) {
+		seen = append(seen, old.Value)
+		return incr(old)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv.Value != "11" {
+		t.Fatalf("value = %s , want 11", kv.Value)
+	}
+	if len(seen) != 3 || seen[0] != "1" || seen[1] != "10" || seen[2] != "10" {
+		t.Fatalf("seen = %v", seen)
+	}
+	stats := etcd.TxnStats()
+	if stats.Attempts != 3 || stats.Conflicts != 2 || stats.Succeeded != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestUpdate_TooManyRetries(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	f.beforeTxn = func(f *fakeKV) { f.set("counter", "0", 0) }
+
+	_, err := etcd.Update(context.Background(), "counter", incr, WithUpdateMaxRetries(2))
+	if errors.Cause(err) != ErrTooManyRetries {
+		t.Fatalf("err = %v , want ErrTooManyRetries", err)
+	}
+	if f.txns != 3 {
+		t.Fatalf("txns = %d , want 3", f.txns)
+	}
+	if etcd.TxnStats().Exhausted != 1 {
+		t.Fatalf("stats = %+v", etcd.TxnStats())
+	}
+}
+
+func TestUpdate_Skip(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+
+	kv, err := etcd.Update(context.Background(), "counter", func(old *KeyValue) (string, error) {
+		return "", ErrSkipUpdate
+	})
+	if err != nil || kv != nil {
+		t.Fatalf("kv = %+v , err = %v", kv, err)
+	}
+	if f.txns != 0 || f.get("counter") != nil {
+		t.Fatal("skip update should not write")
+	}
+
+	// 包装后的 ErrSkipUpdate 同样跳过 ，返回当前值
+	f.set("counter", "3", 0)
+	kv, err = etcd.Update(context.Background(), "counter", func(old *KeyValue) (string, error) {
+		return "", errors.Wrapf(ErrSkipUpdate, "counter = %s", old.Value)
+	})
+	if err != nil || kv == nil || kv.Value != "3" {
+		t.Fatalf("kv = %+v , err = %v", kv, err)
+	}
+	if f.txns != 0 {
+		t.Fatal("wrapped skip update should not write")
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+
+	ok, err := etcd.PutIfAbsent(context.Background(), "k", "v1")
+	if err != nil || !ok {
+		t.Fatalf("ok = %v , err = %v", ok, err)
+	}
+	ok, err = etcd.PutIfAbsent(context.Background(), "k", "v2")
+	if err != nil || ok {
+		t.Fatalf("ok = %v , err = %v", ok, err)
+	}
+	if ok, _ = etcd.DeleteIfValue(context.Background(), "k", "v2"); ok {
+		t.Fatal("delete with wrong value")
+	}
+	if ok, _ = etcd.DeleteIfValue(context.Background(), "k", "v1"); !ok || f.get("k") != nil {
+		t.Fatal("delete with right value failed")
+	}
+}
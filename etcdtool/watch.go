@@ -0,0 +1,221 @@
+package etcdtool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 默认监听断开后的重试间隔
+	DefaultWatchRetryInterval = time.Second
+)
+
+// ResyncFunc 监听的revision已被压缩，事件可能丢失，回调当前全量数据 key -> value
+// revision 为全量数据对应的revision ，之后从 revision+1 继续监听
+type ResyncFunc func(datas map[string]string, revision int64)
+
+// WatchEvent 一个key 的变化 ，Status 取值 EtcdKeyDelete ,EtcdKeyCreate , EtcdKeyModify
+type WatchEvent struct {
+	Status string
+	Key    string
+	Value  string
+}
+
+// WatchEventsFunc 每个监听响应回调一次 ，events 为同一个响应中的全部事件 ，revision 为响应的revision
+type WatchEventsFunc func(events []WatchEvent, revision int64)
+
+type WatchOption func(w *Watcher)
+
+// WithWatchPrefix 按前缀监听
+func WithWatchPrefix() WatchOption {
+	return func(w *Watcher) {
+		w.isPrefix = true
+	}
+}
+
+// WithWatchRevision 从 revision+1 开始监听，一般传入读取全量数据时的revision
+func WithWatchRevision(revision int64) WatchOption {
+	return func(w *Watcher) {
+		if revision > 0 {
+			w.revision = revision
+		}
+	}
+}
+
+// WithWatchResync 发生压缩时的全量同步回调，不设置则从压缩后的最小revision继续监听
+func WithWatchResync(fn ResyncFunc) WatchOption {
+	return func(w *Watcher) {
+		w.resync = fn
+	}
+}
+
+// WithWatchEvents 按监听响应批量回调 ，可以和 changeFunc 同时使用
+// 同一个事务或者短时间内的多次变化合并为一次处理
+func WithWatchEvents(fn WatchEventsFunc) WatchOption {
+	return func(w *Watcher) {
+		w.eventsFunc = fn
+	}
+}
+
+// WithWatchRetryInterval 监听断开后的重试间隔
+func WithWatchRetryInterval(interval time.Duration) WatchOption {
+	return func(w *Watcher) {
+		if interval > 0 {
+			w.retryInterval = interval
+		}
+	}
+}
+
+// Watcher 可恢复的监听，记录最后处理的revision
+// 连接断开后从 revision+1 重新监听，revision 被压缩后触发全量同步，ctx 结束后停止
+type Watcher struct {
+	tool          *EtcdTool
+	key           string
+	isPrefix      bool
+	revision      int64
+	retryInterval time.Duration
+	resync        ResyncFunc
+	changeFunc    DidChangeFunc
+	eventsFunc    WatchEventsFunc
+	done          chan struct{}
+}
+
+// Watch 监听一个key ，返回的 Watcher 可以查询当前revision
+func (etcd *EtcdTool) Watch(ctx context.Context, key string, changeFunc DidChangeFunc, opts ...WatchOption) (w *Watcher, err error) {
+	if key == "" || ctx == nil {
+		err = errors.Errorf("Watch_err args err key = %s , ctx = %+v \n", key, ctx)
+		return
+	}
+	w = &Watcher{
+		tool:          etcd,
+		key:           key,
+		retryInterval: DefaultWatchRetryInterval,
+		changeFunc:    changeFunc,
+		done:          make(chan struct{}),
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](w)
+	}
+	// 未指定revision 先读取当前revision，保证之后重连不会漏掉事件
+	if w.revision == 0 {
+		getOpts := []clientv3.OpOption{clientv3.WithCountOnly()}
+		if w.isPrefix {
+			getOpts = append(getOpts, clientv3.WithPrefix())
+		}
+		gctx, cancel := etcd.withTimeout(ctx)
+		resp, e := etcd.Tool.Get(gctx, key, getOpts...)
+		cancel()
+		if e != nil {
+			err = errors.Wrapf(e, "Watch_err get revision key = %s", key)
+			return
+		}
+		w.revision = resp.Header.Revision
+	}
+	go w.run(ctx)
+	return
+}
+
+// Revision 最后处理的revision
+func (w *Watcher) Revision() int64 {
+	return atomic.LoadInt64(&w.revision)
+}
+
+// Done 监听结束后关闭
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *Watcher) run(ctx context.Context) {
+	defer close(w.done)
+	for {
+		compacted := w.watchOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if compacted > 0 {
+			if w.resync == nil {
+				atomic.StoreInt64(&w.revision, compacted-1)
+				continue
+			}
+			if w.doResync(ctx) {
+				continue
+			}
+		}
+		select {
+		case <-time.After(w.retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 监听直到通道关闭，被压缩返回压缩后的最小revision
+func (w *Watcher) watchOnce(ctx context.Context) (compactRevision int64) {
+	opts := []clientv3.OpOption{clientv3.WithRev(w.Revision() + 1), clientv3.WithProgressNotify()}
+	if w.isPrefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	watchChan := w.tool.Tool.Watch(wctx, w.key, opts...)
+	for resp := range watchChan {
+		if resp.CompactRevision > 0 {
+			return resp.CompactRevision
+		}
+		if resp.Err() != nil {
+			return 0
+		}
+		handleEvents(resp, "Watch_trace", w.changeFunc)
+		if w.eventsFunc != nil && len(resp.Events) > 0 {
+			w.eventsFunc(watchEvents(resp), resp.Header.Revision)
+		}
+		if resp.Header.Revision > w.Revision() {
+			atomic.StoreInt64(&w.revision, resp.Header.Revision)
+		}
+	}
+	return 0
+}
+
+func (w *Watcher) doResync(ctx context.Context) bool {
+	opts := make([]clientv3.OpOption, 0, 1)
+	if w.isPrefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	gctx, cancel := w.tool.withTimeout(ctx)
+	defer cancel()
+	resp, err := w.tool.Tool.Get(gctx, w.key, opts...)
+	if err != nil {
+		return false
+	}
+	datas := make(map[string]string, len(resp.Kvs))
+	for i := 0; i < len(resp.Kvs); i++ {
+		datas[string(resp.Kvs[i].Key)] = string(resp.Kvs[i].Value)
+	}
+	w.resync(datas, resp.Header.Revision)
+	atomic.StoreInt64(&w.revision, resp.Header.Revision)
+	return true
+}
+
+// 转换监听响应中的事件
+func watchEvents(resp clientv3.WatchResponse) []WatchEvent {
+	events := make([]WatchEvent, 0, len(resp.Events))
+	for i := 0; i < len(resp.Events); i++ {
+		event := resp.Events[i]
+		e := WatchEvent{Key: string(event.Kv.Key), Value: string(event.Kv.Value)}
+		switch {
+		case event.Type == mvccpb.DELETE:
+			e.Status = EtcdKeyDelete
+		case event.IsCreate():
+			e.Status = EtcdKeyCreate
+		default:
+			e.Status = EtcdKeyModify
+		}
+		events = append(events, e)
+	}
+	return events
+}
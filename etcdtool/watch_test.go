@@ -0,0 +1,222 @@
+package etcdtool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 一次 Watch 调用 ，测试通过 ch 发送响应 ，关闭 ch 模拟通道断开
+type watchCall struct {
+	rev int64
+	ch  chan clientv3.WatchResponse
+}
+
+// scriptWatcher 由测试控制响应的 clientv3.Watcher
+type scriptWatcher struct {
+	calls chan watchCall
+}
+
+func (s *scriptWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	call := watchCall{rev: clientv3.OpGet(key, opts...).Rev(), ch: make(chan clientv3.WatchResponse)}
+	out := make(chan clientv3.WatchResponse)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case resp, ok := <-call.ch:
+				if !ok {
+					return
+				}
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	s.calls <- call
+	return out
+}
+
+func (s *scriptWatcher) RequestProgress(ctx context.Context) error { return nil }
+func (s *scriptWatcher) Close() error                              { return nil }
+
+func newScriptTool(f *fakeKV) (*EtcdTool, *scriptWatcher) {
+	etcd := newFakeTool(f)
+	w := &scriptWatcher{calls: make(chan watchCall, 1)}
+	etcd.Tool.Watcher = w
+	return etcd, w
+}
+
+func (s *scriptWatcher) next(t *testing.T) watchCall {
+	t.Helper()
+	select {
+	case call := <-s.calls:
+		return call
+	case <-time.After(time.Second):
+		t.Fatal("no watch call")
+	}
+	return watchCall{}
+}
+
+func putResponse(revision int64, key, value string) clientv3.WatchResponse {
+	return clientv3.WatchResponse{
+		Header: etcdserverpb.ResponseHeader{Revision: revision},
+		Events: []*clientv3.Event{{Type: mvccpb.PUT, Kv: &mvccpb.KeyValue{
+			Key: []byte(key), Value: []byte(value), CreateRevision: revision, ModRevision: revision, Version: 1}}},
+	}
+}
+
+func TestWatcher_Resume(t *testing.T) {
+	etcd, sw := newScriptTool(newFakeKV())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var changes []string
+	w, err := etcd.Watch(ctx, "/im/", func(status, key, value string) {
+		mu.Lock()
+		changes = append(changes, status+" "+key+" "+value)
+		mu.Unlock()
+	}, WithWatchPrefix(), WithWatchRevision(5), WithWatchRetryInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 从 revision+1 开始监听
+	call := sw.next(t)
+	if call.rev != 6 {
+		t.Fatalf("rev = %d , want 6", call.rev)
+	}
+	call.ch <- putResponse(8, "/im/a", "1")
+	// 通道断开后从最后处理的 revision+1 重新监听
+	close(call.ch)
+	call = sw.next(t)
+	if call.rev != 9 || w.Revision() != 8 {
+		t.Fatalf("rev = %d , revision = %d , want 9 , 8", call.rev, w.Revision())
+	}
+	mu.Lock()
+	if len(changes) != 1 || changes[0] != EtcdKeyCreate+" /im/a 1" {
+		t.Fatalf("changes = %v", changes)
+	}
+	mu.Unlock()
+
+	// 错误响应后重试 ，revision 不变
+	call.ch <- clientv3.WatchResponse{Canceled: true}
+	call = sw.next(t)
+	if call.rev != 9 {
+		t.Fatalf("rev = %d , want 9", call.rev)
+	}
+
+	// ctx 结束后停止
+	cancel()
+	select {
+	case <-w.Done():
+	case <-time.After(time.Second):
+		t.Fatal("watcher not stopped")
+	}
+}
+
+func TestWatcher_CompactResync(t *testing.T) {
+	f := newFakeKV()
+	f.set("/im/a", "1", 0)
+	f.set("/im/b", "2", 0)
+	f.set("/other", "3", 0)
+	etcd, sw := newScriptTool(f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type resync struct {
+		datas    map[string]string
+		revision int64
+	}
+	resyncs := make(chan resync, 1)
+	w, err := etcd.Watch(ctx, "/im/", nil, WithWatchPrefix(), WithWatchRevision(1),
+		WithWatchRetryInterval(time.Millisecond),
+		WithWatchResync(func(datas map[string]string, revision int64) { resyncs <- resync{datas, revision} }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 压缩后读取全量数据 ，从全量数据的 revision+1 继续监听
+	call := sw.next(t)
+	call.ch <- clientv3.WatchResponse{CompactRevision: 3}
+	r := <-resyncs
+	if len(r.datas) != 2 || r.datas["/im/a"] != "1" || r.datas["/im/b"] != "2" || r.revision != 4 {
+		t.Fatalf("resync = %+v", r)
+	}
+	call = sw.next(t)
+	if call.rev != 5 || w.Revision() != 4 {
+		t.Fatalf("rev = %d , revision = %d , want 5 , 4", call.rev, w.Revision())
+	}
+}
+
+func TestWatcher_CompactWithoutResync(t *testing.T) {
+	etcd, sw := newScriptTool(newFakeKV())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := etcd.Watch(ctx, "/im/a", nil, WithWatchRevision(1), WithWatchRetryInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有全量同步回调 ，从压缩后的最小revision 继续监听
+	call := sw.next(t)
+	call.ch <- clientv3.WatchResponse{CompactRevision: 20}
+	call = sw.next(t)
+	if call.rev != 20 || w.Revision() != 19 {
+		t.Fatalf("rev = %d , revision = %d , want 20 , 19", call.rev, w.Revision())
+	}
+}
+
+func TestWatcher_FakeCompaction(t *testing.T) {
+	// 使用 fakeKV 的监听服务 ，revision 被压缩后的完整流程
+	f := newFakeKV()
+	etcd := newFakeTool(f)
+	f.set("/im/a", "1", 0)
+	f.set("/im/a", "2", 0)
+	f.compact(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resynced := make(chan int64, 1)
+	events := make(chan []WatchEvent, 1)
+	w, err := etcd.Watch(ctx, "/im/", nil, WithWatchPrefix(), WithWatchRevision(1),
+		WithWatchRetryInterval(time.Millisecond),
+		WithWatchEvents(func(es []WatchEvent, revision int64) { events <- es }),
+		WithWatchResync(func(datas map[string]string, revision int64) { resynced <- revision }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case revision := <-resynced:
+		if revision != 3 {
+			t.Fatalf("resync revision = %d , want 3", revision)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no resync")
+	}
+	f.set("/im/b", "3", 0)
+	select {
+	case es := <-events:
+		if len(es) != 1 || es[0].Key != "/im/b" || es[0].Status != EtcdKeyCreate {
+			t.Fatalf("events = %+v", es)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no events after resync")
+	}
+	// 回调之后才记录revision
+	deadline := time.Now().Add(time.Second)
+	for w.Revision() != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("revision = %d , want 4", w.Revision())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,86 @@
+package tdelay
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/heyehang/go-im-pkg/etcdtool"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 默认认领租约时间 秒 ，认领的进程异常退出后最多 ttl 秒其他进程可以重新认领
+	DefaultClaimTTL = 30
+	claimPrefix     = "tdelay_claim_"
+)
+
+// ErrClaimed 任务已被其他进程认领
+var ErrClaimed = errors.New("delay job claimed by another replica")
+
+// Claimer 多个进程加载同一个存储时 ，保证每个任务同一时间只有一个进程执行
+type Claimer interface {
+	// Claim 认领任务 ，已被认领返回 ErrClaimed ，执行完成后调用 release
+	Claim(ctx context.Context, id string) (release func(), err error)
+}
+
+// EtcdClaimer 使用etcd 租约认领 ，执行期间自动续期
+type EtcdClaimer struct {
+	tool   *etcdtool.EtcdTool
+	prefix string
+	ttl    int64
+	owner  string
+}
+
+// NewEtcdClaimer 创建 etcd 认领 ，ttl 单位秒 ，<= 0 使用 DefaultClaimTTL
+func NewEtcdClaimer(tool *etcdtool.EtcdTool, ttl int64) *EtcdClaimer {
+	if ttl <= 0 {
+		ttl = DefaultClaimTTL
+	}
+	host, _ := os.Hostname()
+	return &EtcdClaimer{
+		tool:   tool,
+		prefix: claimPrefix,
+		ttl:    ttl,
+		owner:  host + "-" + strconv.Itoa(os.Getpid()),
+	}
+}
+
+func (c *EtcdClaimer) Claim(ctx context.Context, id string) (release func(), err error) {
+	gctx, cancel := c.tool.TimeoutContext(ctx)
+	lease, err := c.tool.Tool.Grant(gctx, c.ttl)
+	cancel()
+	if err != nil {
+		return nil, errors.Wrapf(err, "EtcdClaimer_Claim_err grant id = %s", id)
+	}
+	ok, err := c.tool.PutIfAbsent(ctx, c.prefix+id, c.owner, clientv3.WithLease(lease.ID))
+	if err != nil || !ok {
+		c.revoke(lease.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "EtcdClaimer_Claim_err id = %s", id)
+		}
+		return nil, ErrClaimed
+	}
+	// 执行期间续期 ，释放时撤销租约删除key
+	keepCtx, keepCancel := context.WithCancel(context.Background())
+	ch, err := c.tool.Tool.KeepAlive(keepCtx, lease.ID)
+	if err == nil {
+		go func() {
+			for range ch {
+			}
+		}()
+	}
+	release = func() {
+		keepCancel()
+		c.revoke(lease.ID)
+	}
+	return release, nil
+}
+
+// 撤销租约 ，etcd 不可用时最多等待超时时间 ，之后租约在 ttl 秒后过期
+func (c *EtcdClaimer) revoke(id clientv3.LeaseID) {
+	ctx, cancel := c.tool.TimeoutContext(context.Background())
+	defer cancel()
+	_, _ = c.tool.Tool.Revoke(ctx, id)
+}
@@ -0,0 +1,79 @@
+package tdelay
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存存储 ，进程重启后任务丢失 ，用于测试和单机场景
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]*Job)}
+}
+
+func (m *MemoryStore) Save(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; ok {
+		return ErrJobExists
+	}
+	cp := *job
+	m.jobs[job.ID] = &cp
+	return nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	cp := *job
+	return &cp, nil
+}
+
+func (m *MemoryStore) LoadPending(ctx context.Context, until time.Time, limit int) ([]*Job, error) {
+	m.mu.RLock()
+	jobs := make([]*Job, 0, 16)
+	for _, job := range m.jobs {
+		if job.Status == JobPending && !job.RunAt.After(until) {
+			cp := *job
+			jobs = append(jobs, &cp)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].RunAt.Before(jobs[j].RunAt) })
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+func (m *MemoryStore) MarkDone(ctx context.Context, id string, doneAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return false, ErrJobNotFound
+	}
+	if job.Status == JobDone {
+		return false, nil
+	}
+	job.Status = JobDone
+	job.DoneAt = doneAt
+	return true, nil
+}
+
+func (m *MemoryStore) Remove(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
@@ -0,0 +1,103 @@
+package tdelay
+
+import (
+	"context"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/mongosdk"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MongoStore MongoDB 存储 ，一个任务一个文档 ，_id 为任务ID
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+// NewMongoStore 使用 mongosdk 初始化的客户端 ，需要先调用 mongosdk.InitMongo
+func NewMongoStore(database, collection string) *MongoStore {
+	return NewMongoStoreWithCollection(mongosdk.GetClient().Database(database).Collection(collection))
+}
+
+// NewMongoStoreWithCollection 读取固定使用主节点 ，认领后检查状态需要读到其他进程最新的写入
+// 客户端或者集合设置了从节点读取时同样生效
+func NewMongoStoreWithCollection(coll *mongo.Collection) *MongoStore {
+	if primary, err := coll.Clone(options.Collection().SetReadPreference(readpref.Primary())); err == nil {
+		coll = primary
+	}
+	return &MongoStore{coll: coll}
+}
+
+// EnsureIndexes 创建加载未完成任务需要的索引
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "MongoStore_EnsureIndexes_err")
+	}
+	return nil
+}
+
+func (m *MongoStore) Save(ctx context.Context, job *Job) error {
+	if _, err := m.coll.InsertOne(ctx, job); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrJobExists
+		}
+		return errors.Wrapf(err, "MongoStore_Save_err id = %s", job.ID)
+	}
+	return nil
+}
+
+func (m *MongoStore) Get(ctx context.Context, id string) (*Job, error) {
+	job := &Job{}
+	if err := m.coll.FindOne(ctx, bson.M{"_id": id}).Decode(job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrJobNotFound
+		}
+		return nil, errors.Wrapf(err, "MongoStore_Get_err id = %s", id)
+	}
+	return job, nil
+}
+
+func (m *MongoStore) LoadPending(ctx context.Context, until time.Time, limit int) ([]*Job, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "run_at", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := m.coll.Find(ctx, bson.M{"status": JobPending, "run_at": bson.M{"$lte": until}}, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MongoStore_LoadPending_err")
+	}
+	jobs := make([]*Job, 0, 16)
+	if err = cur.All(ctx, &jobs); err != nil {
+		return nil, errors.Wrapf(err, "MongoStore_LoadPending_err")
+	}
+	return jobs, nil
+}
+
+func (m *MongoStore) MarkDone(ctx context.Context, id string, doneAt time.Time) (bool, error) {
+	res, err := m.coll.UpdateOne(ctx, bson.M{"_id": id, "status": JobPending},
+		bson.M{"$set": bson.M{"status": JobDone, "done_at": doneAt}})
+	if err != nil {
+		return false, errors.Wrapf(err, "MongoStore_MarkDone_err id = %s", id)
+	}
+	if res.ModifiedCount == 1 {
+		return true, nil
+	}
+	// 区分已经完成和不存在
+	if _, err = m.Get(ctx, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (m *MongoStore) Remove(ctx context.Context, id string) error {
+	if _, err := m.coll.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return errors.Wrapf(err, "MongoStore_Remove_err id = %s", id)
+	}
+	return nil
+}
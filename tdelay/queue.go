@@ -0,0 +1,304 @@
+package tdelay
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/ttime"
+	"github.com/pkg/errors"
+)
+
+const (
+	// 默认扫描存储的间隔
+	DefaultScanInterval = time.Second * 30
+	// 默认每次扫描加载的任务数
+	DefaultLoadLimit = 10000
+	// 默认单个任务执行超时
+	DefaultRunTimeout = time.Second * 30
+)
+
+// Handler 任务处理函数 ，返回err 时任务保持未完成 ，下次扫描时重新执行
+type Handler func(ctx context.Context, job *Job) error
+
+type Option func(q *Queue)
+
+// WithTimeWheel 使用已有的时间轮 ，调用方负责启动和停止 ，默认创建多层时间轮
+func WithTimeWheel(tw *ttime.TimeWheel) Option {
+	return func(q *Queue) {
+		if tw != nil {
+			q.tw = tw
+		}
+	}
+}
+
+// WithClock 设置时钟 ，测试中使用 ttime.FakeClock ，默认使用系统时间
+// 同时使用 WithTimeWheel 时 ，时间轮需要通过 ttime.WithClock 使用同一个时钟
+func WithClock(clock ttime.Clock) Option {
+	return func(q *Queue) {
+		if clock != nil {
+			q.clock = clock
+		}
+	}
+}
+
+// WithClaimer 多进程部署时认领任务 ，保证每个任务同一时间只有一个进程执行
+func WithClaimer(claimer Claimer) Option {
+	return func(q *Queue) {
+		q.claimer = claimer
+	}
+}
+
+// WithScanInterval 扫描存储的间隔 ，每次加载 2 个间隔内到期的任务
+func WithScanInterval(interval time.Duration) Option {
+	return func(q *Queue) {
+		if interval > 0 {
+			q.scanInterval = interval
+		}
+	}
+}
+
+// WithLoadLimit 每次扫描最多加载的任务数
+func WithLoadLimit(limit int) Option {
+	return func(q *Queue) {
+		if limit > 0 {
+			q.loadLimit = limit
+		}
+	}
+}
+
+// WithRunTimeout 单个任务执行超时
+func WithRunTimeout(timeout time.Duration) Option {
+	return func(q *Queue) {
+		if timeout > 0 {
+			q.runTimeout = timeout
+		}
+	}
+}
+
+// WithErrFunc 后台扫描、执行失败时回调
+func WithErrFunc(fn func(err error)) Option {
+	return func(q *Queue) {
+		q.errFunc = fn
+	}
+}
+
+// Queue 持久化的延时队列 ，任务先写入存储 ，即将到期的任务加载到时间轮中执行
+// 启动和每次扫描时从存储加载未完成的任务 ，进程重启不会丢失任务
+// 执行前检查任务状态 ，执行成功后标记完成 ，保证至少执行一次
+type Queue struct {
+	store        Store
+	handler      Handler
+	tw           *ttime.TimeWheel
+	ownWheel     bool
+	claimer      Claimer
+	scanInterval time.Duration
+	loadLimit    int
+	runTimeout   time.Duration
+	errFunc      func(err error)
+	clock        ttime.Clock
+
+	mu        sync.Mutex
+	scheduled map[string]*ttime.Timer
+	// Start 时设置 ，mu 保护
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewQueue(store Store, handler Handler, opts ...Option) *Queue {
+	q := &Queue{
+		store:        store,
+		handler:      handler,
+		scanInterval: DefaultScanInterval,
+		loadLimit:    DefaultLoadLimit,
+		runTimeout:   DefaultRunTimeout,
+		scheduled:    make(map[string]*ttime.Timer),
+		clock:        ttime.NewRealClock(),
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](q)
+	}
+	if q.tw == nil {
+		q.tw = ttime.NewTimeWheel(ttime.WithHierarchical(), ttime.WithInterval(time.Millisecond*100),
+			ttime.WithClock(q.clock))
+		q.ownWheel = true
+	}
+	return q
+}
+
+// Start 加载未完成的任务并定期扫描存储 ，ctx 结束或者 Stop 后停止
+func (q *Queue) Start(ctx context.Context) (err error) {
+	if q.store == nil || q.handler == nil {
+		err = errors.Errorf("Queue_Start_err store or handler is nil")
+		return
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	q.mu.Lock()
+	q.ctx, q.cancel = runCtx, cancel
+	q.mu.Unlock()
+	if q.ownWheel {
+		q.tw.Start()
+	}
+	if err = q.load(runCtx); err != nil {
+		q.Stop()
+		return
+	}
+	go q.scan(runCtx)
+	return
+}
+
+// Start 时创建的ctx ，未启动时返回nil
+func (q *Queue) runContext() context.Context {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.ctx
+}
+
+// Stop 停止扫描 ，已经加载到时间轮的任务不再执行 ，下次启动时重新加载
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	if q.cancel != nil {
+		q.cancel()
+	}
+	for id, t := range q.scheduled {
+		t.Stop()
+		delete(q.scheduled, id)
+	}
+	q.mu.Unlock()
+	if q.ownWheel {
+		q.tw.Stop(context.Background())
+	}
+}
+
+// Add 添加任务 ，ID 为空或者重复返回err ，RunAt 为零值时立即执行
+// Start 之前添加的任务只写入存储 ，Start 时加载
+func (q *Queue) Add(ctx context.Context, job *Job) (err error) {
+	if job == nil || job.ID == "" {
+		err = errors.Errorf("Queue_Add_err job id is empty")
+		return
+	}
+	job.Status = JobPending
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = q.clock.Now()
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = job.CreatedAt
+	}
+	if err = q.store.Save(ctx, job); err != nil {
+		return
+	}
+	if q.runContext() != nil && job.RunAt.Sub(q.clock.Now()) <= q.window() {
+		q.schedule(job)
+	}
+	return
+}
+
+// Cancel 取消任务 ，从存储中删除
+func (q *Queue) Cancel(ctx context.Context, id string) (err error) {
+	if err = q.store.Remove(ctx, id); err != nil {
+		return
+	}
+	q.mu.Lock()
+	t, ok := q.scheduled[id]
+	delete(q.scheduled, id)
+	q.mu.Unlock()
+	if ok {
+		t.Stop()
+	}
+	return
+}
+
+// 每次加载的时间范围
+func (q *Queue) window() time.Duration {
+	return q.scanInterval * 2
+}
+
+func (q *Queue) scan(ctx context.Context) {
+	ticker := q.clock.NewTicker(q.scanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			q.report(q.load(ctx))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// 加载即将到期的任务 ，已经在时间轮中的任务跳过
+func (q *Queue) load(ctx context.Context) (err error) {
+	jobs, err := q.store.LoadPending(ctx, q.clock.Now().Add(q.window()), q.loadLimit)
+	if err != nil {
+		err = errors.Wrapf(err, "Queue_load_err")
+		return
+	}
+	for i := 0; i < len(jobs); i++ {
+		q.schedule(jobs[i])
+	}
+	return
+}
+
+func (q *Queue) schedule(job *Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.scheduled[job.ID]; ok && t.Active() {
+		return
+	}
+	delay := job.RunAt.Sub(q.clock.Now())
+	if delay < 0 {
+		delay = 0
+	}
+	id := job.ID
+	t, err := q.tw.AddTimer(delay, nil, func(kv ...interface{}) { q.run(id) })
+	if err != nil {
+		q.report(errors.Wrapf(err, "Queue_schedule_err id = %s", id))
+		return
+	}
+	q.scheduled[id] = t
+}
+
+// 认领任务 ，确认未完成后执行并标记完成
+func (q *Queue) run(id string) {
+	q.mu.Lock()
+	delete(q.scheduled, id)
+	q.mu.Unlock()
+	ctx, cancel := context.WithTimeout(q.runContext(), q.runTimeout)
+	defer cancel()
+	if q.claimer != nil {
+		release, err := q.claimer.Claim(ctx, id)
+		if err == ErrClaimed {
+			return
+		}
+		if err != nil {
+			q.report(errors.Wrapf(err, "Queue_run_err claim id = %s", id))
+			return
+		}
+		defer release()
+	}
+	// 认领之后再检查状态 ，其他进程已经完成或者任务已经取消时跳过
+	job, err := q.store.Get(ctx, id)
+	if err == ErrJobNotFound {
+		return
+	}
+	if err != nil {
+		q.report(errors.Wrapf(err, "Queue_run_err get id = %s", id))
+		return
+	}
+	if job.Status != JobPending {
+		return
+	}
+	if err = q.handler(ctx, job); err != nil {
+		q.report(errors.Wrapf(err, "Queue_run_err handle id = %s", id))
+		return
+	}
+	if _, err = q.store.MarkDone(ctx, id, q.clock.Now()); err != nil {
+		q.report(errors.Wrapf(err, "Queue_run_err mark done id = %s", id))
+	}
+}
+
+func (q *Queue) report(err error) {
+	if err != nil && q.errFunc != nil {
+		q.errFunc(err)
+	}
+}
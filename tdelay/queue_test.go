@@ -0,0 +1,293 @@
+package tdelay
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/ttime"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		job := &Job{ID: strconv.Itoa(i), RunAt: now.Add(time.Duration(5-i) * time.Second), Status: JobPending}
+		if err := s.Save(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save(ctx, &Job{ID: "1"}); err != ErrJobExists {
+		t.Fatalf("duplicate save err = %v", err)
+	}
+	jobs, _ := s.LoadPending(ctx, now.Add(3*time.Second), 2)
+	if len(jobs) != 2 || jobs[0].ID != "4" || jobs[1].ID != "3" {
+		t.Fatalf("LoadPending = %+v", jobs)
+	}
+	if ok, err := s.MarkDone(ctx, "4", now); !ok || err != nil {
+		t.Fatalf("MarkDone = %v %v", ok, err)
+	}
+	if ok, err := s.MarkDone(ctx, "4", now); ok || err != nil {
+		t.Fatalf("second MarkDone = %v %v", ok, err)
+	}
+	if _, err := s.MarkDone(ctx, "x", now); err != ErrJobNotFound {
+		t.Fatalf("MarkDone missing err = %v", err)
+	}
+	if job, _ := s.Get(ctx, "4"); !job.DoneAt.Equal(now) {
+		t.Fatalf("DoneAt = %v , want %v", job.DoneAt, now)
+	}
+	jobs, _ = s.LoadPending(ctx, now.Add(time.Hour), 0)
+	if len(jobs) != 4 {
+		t.Fatalf("pending = %d", len(jobs))
+	}
+	_ = s.Remove(ctx, "0")
+	if _, err := s.Get(ctx, "0"); err != ErrJobNotFound {
+		t.Fatalf("Get removed err = %v", err)
+	}
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	runs map[string]int
+}
+
+func newRecorder() *recorder {
+	return &recorder{runs: make(map[string]int)}
+}
+
+func (r *recorder) handle(ctx context.Context, job *Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[job.ID]++
+	return nil
+}
+
+func (r *recorder) count(id string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[id]
+}
+
+// 任务在时间轮的回调协程中执行 ，推进时间后等待条件满足
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueue_RunAndReload(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	rec := newRecorder()
+	clock := ttime.NewFakeClock(time.Time{})
+	q := NewQueue(store, rec.handle, WithClock(clock), WithScanInterval(time.Second))
+	// Start 之前添加的任务在启动时加载
+	if err := q.Add(ctx, &Job{ID: "before-start"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	begin := clock.Now()
+	_ = q.Add(ctx, &Job{ID: "soon", RunAt: begin.Add(1500 * time.Millisecond)})
+	// 超出加载窗口 ，扫描时加载
+	_ = q.Add(ctx, &Job{ID: "later", RunAt: begin.Add(5 * time.Second)})
+	_ = q.Add(ctx, &Job{ID: "cancel", RunAt: begin.Add(time.Second)})
+	if err := q.Add(ctx, &Job{ID: "soon"}); err != ErrJobExists {
+		t.Fatalf("duplicate add err = %v", err)
+	}
+	if err := q.Cancel(ctx, "cancel"); err != nil {
+		t.Fatal(err)
+	}
+	// 重启前未到期的任务
+	_ = q.Add(ctx, &Job{ID: "after-restart", RunAt: begin.Add(10 * time.Second)})
+
+	clock.Advance(1400 * time.Millisecond)
+	waitFor(t, "before-start", func() bool { return rec.count("before-start") == 1 })
+	if rec.count("soon") != 0 {
+		t.Fatalf("job soon ran early")
+	}
+	clock.Advance(100 * time.Millisecond)
+	waitFor(t, "soon", func() bool { return rec.count("soon") == 1 })
+	clock.Advance(4 * time.Second)
+	waitFor(t, "later", func() bool { return rec.count("later") == 1 })
+	waitFor(t, "soon done", func() bool {
+		job, _ := store.Get(ctx, "soon")
+		return job.Status == JobDone
+	})
+	// 完成时间使用队列的时钟
+	if job, _ := store.Get(ctx, "soon"); job.DoneAt.Before(begin.Add(1500*time.Millisecond)) || job.DoneAt.After(clock.Now()) {
+		t.Fatalf("DoneAt = %v , want fake clock time", job.DoneAt)
+	}
+	q.Stop()
+	if rec.count("cancel") != 0 || rec.count("after-restart") != 0 || rec.count("soon") != 1 {
+		t.Fatalf("unexpected runs %+v", rec.runs)
+	}
+
+	// 重启后加载未完成的任务
+	q = NewQueue(store, rec.handle, WithClock(clock), WithScanInterval(time.Second))
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	clock.Advance(5 * time.Second)
+	waitFor(t, "after-restart", func() bool { return rec.count("after-restart") == 1 })
+	if rec.count("soon") != 1 || rec.count("before-start") != 1 {
+		t.Fatalf("after restart runs %+v", rec.runs)
+	}
+}
+
+// 在时间轮的提交协程中直接执行回调
+type syncExecutor struct{}
+
+func (syncExecutor) Submit(task func()) error {
+	task()
+	return nil
+}
+
+func TestQueue_FakeClock(t *testing.T) {
+	ctx := context.Background()
+	clock := ttime.NewFakeClock(time.Time{})
+	tw := ttime.NewTimeWheel(ttime.WithHierarchical(), ttime.WithInterval(time.Second), ttime.WithClock(clock),
+		ttime.WithExecutor(syncExecutor{}))
+	tw.Start()
+	defer tw.Stop(ctx)
+	var mu sync.Mutex
+	ran := make(map[string]bool)
+	q := NewQueue(NewMemoryStore(), func(ctx context.Context, job *Job) error {
+		mu.Lock()
+		ran[job.ID] = true
+		mu.Unlock()
+		return nil
+	}, WithTimeWheel(tw), WithClock(clock), WithScanInterval(time.Minute))
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	begin := clock.Now()
+	_ = q.Add(ctx, &Job{ID: "soon", RunAt: begin.Add(30 * time.Second)})
+	// 超出加载窗口 ，扫描时加载
+	_ = q.Add(ctx, &Job{ID: "later", RunAt: begin.Add(5 * time.Minute)})
+	// 按推进的时间判断执行时间 ，handler 中的 clock.Now 可能已经是之后的刻度
+	check := func(d time.Duration, want ...string) {
+		clock.Advance(d)
+		tw.Len()
+		// 回调在时间轮的提交协程中执行 ，等待到期的任务执行完
+		deadline := time.Now().Add(time.Second)
+		mu.Lock()
+		defer mu.Unlock()
+		for len(ran) < len(want) && time.Now().Before(deadline) {
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+		}
+		if len(ran) != len(want) {
+			t.Fatalf("after %s ran %v , want %v", clock.Now().Sub(begin), ran, want)
+		}
+		for _, id := range want {
+			if !ran[id] {
+				t.Fatalf("after %s job %s not ran", clock.Now().Sub(begin), id)
+			}
+		}
+	}
+	check(29 * time.Second)
+	check(time.Second, "soon")
+	check(4*time.Minute+29*time.Second, "soon")
+	check(time.Second, "soon", "later")
+}
+
+func TestQueue_RetryOnError(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	var calls int32
+	var errs int32
+	clock := ttime.NewFakeClock(time.Time{})
+	q := NewQueue(store, func(ctx context.Context, job *Job) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("temporary")
+		}
+		return nil
+	}, WithClock(clock), WithScanInterval(time.Second), WithErrFunc(func(err error) { atomic.AddInt32(&errs, 1) }))
+	if err := q.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Stop()
+	_ = q.Add(ctx, &Job{ID: "retry"})
+	clock.Advance(100 * time.Millisecond)
+	waitFor(t, "first run", func() bool { return atomic.LoadInt32(&errs) == 1 })
+	// 失败的任务保持未完成 ，下次扫描重新加载
+	clock.Advance(time.Second)
+	waitFor(t, "retry done", func() bool {
+		job, _ := store.Get(ctx, "retry")
+		return job.Status == JobDone
+	})
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("handler called %d times", n)
+	}
+	if n := atomic.LoadInt32(&errs); n != 1 {
+		t.Fatalf("err func called %d times", n)
+	}
+}
+
+// 进程内的认领 ，模拟 etcd
+type memClaimer struct {
+	mu     sync.Mutex
+	claims map[string]bool
+}
+
+func (c *memClaimer) Claim(ctx context.Context, id string) (func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claims[id] {
+		return nil, ErrClaimed
+	}
+	c.claims[id] = true
+	return func() {
+		c.mu.Lock()
+		delete(c.claims, id)
+		c.mu.Unlock()
+	}, nil
+}
+
+func TestQueue_Claim(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	claimer := &memClaimer{claims: make(map[string]bool)}
+	rec := newRecorder()
+	clock := ttime.NewFakeClock(time.Time{})
+	queues := make([]*Queue, 3)
+	for i := range queues {
+		queues[i] = NewQueue(store, rec.handle, WithClaimer(claimer), WithClock(clock), WithScanInterval(time.Second))
+		if err := queues[i].Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 三个进程扫描时都会加载全部任务
+	now := clock.Now()
+	for i := 0; i < 50; i++ {
+		_ = store.Save(ctx, &Job{ID: strconv.Itoa(i), RunAt: now.Add(time.Duration(i) * 10 * time.Millisecond), Status: JobPending})
+	}
+	clock.Advance(1500 * time.Millisecond)
+	waitFor(t, "all jobs done", func() bool {
+		jobs, _ := store.LoadPending(ctx, clock.Now(), 0)
+		return len(jobs) == 0
+	})
+	for i := range queues {
+		queues[i].Stop()
+	}
+	for i := 0; i < 50; i++ {
+		if n := rec.count(strconv.Itoa(i)); n != 1 {
+			t.Fatalf("job %d ran %d times", i, n)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tdelay
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrJobExists 保存时 ID 已经存在
+	ErrJobExists = errors.New("delay job already exists")
+	// ErrJobNotFound 任务不存在
+	ErrJobNotFound = errors.New("delay job not found")
+)
+
+// JobStatus 任务状态
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobDone    JobStatus = "done"
+)
+
+// Job 延时任务
+type Job struct {
+	// 唯一标识 ，重复添加返回 ErrJobExists
+	ID string `bson:"_id" json:"id"`
+	// 任务类型 ，处理函数根据 Topic 分发
+	Topic   string    `bson:"topic" json:"topic"`
+	Payload []byte    `bson:"payload" json:"payload"`
+	RunAt   time.Time `bson:"run_at" json:"run_at"`
+	Status  JobStatus `bson:"status" json:"status"`
+	// 创建时间、完成时间
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	DoneAt    time.Time `bson:"done_at,omitempty" json:"done_at,omitempty"`
+}
+
+// Store 任务存储
+type Store interface {
+	// Save 保存新任务 ，ID 已经存在时返回 ErrJobExists ，不覆盖
+	Save(ctx context.Context, job *Job) error
+	// Get 获取任务 ，不存在返回 ErrJobNotFound
+	Get(ctx context.Context, id string) (*Job, error)
+	// LoadPending 按 RunAt 从小到大返回 RunAt <= until 的未完成任务 ，最多 limit 个
+	LoadPending(ctx context.Context, until time.Time, limit int) ([]*Job, error)
+	// MarkDone 标记任务完成 ，doneAt 为队列时钟的当前时间 ，只有第一次标记返回true ，不存在返回 ErrJobNotFound
+	MarkDone(ctx context.Context, id string, doneAt time.Time) (bool, error)
+	// Remove 删除任务 ，不存在时不返回err
+	Remove(ctx context.Context, id string) error
+}
@@ -0,0 +1,66 @@
+package ttime
+
+import "time"
+
+// 按时区计算自然日、周、月的边界 ，loc 为nil 时使用 t 自身的时区
+// 结束时间为下一个周期开始前的最后一纳秒 ，区间为闭区间 [Start, End]
+// 使用 time.Date 计算 ，夏令时切换的日期也正确
+
+// StartOfDay loc 时区中 t 所在日的零点
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	t = inLocation(t, loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// EndOfDay loc 时区中 t 所在日的最后一纳秒
+func EndOfDay(t time.Time, loc *time.Location) time.Time {
+	start := StartOfDay(t, loc)
+	return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+}
+
+// StartOfWeek loc 时区中 t 所在周的第一天零点 ，weekStart 为每周的第一天 ，国内一般为 time.Monday
+func StartOfWeek(t time.Time, loc *time.Location, weekStart time.Weekday) time.Time {
+	start := StartOfDay(t, loc)
+	offset := (int(start.Weekday()) - int(weekStart) + 7) % 7
+	return time.Date(start.Year(), start.Month(), start.Day()-offset, 0, 0, 0, 0, start.Location())
+}
+
+// EndOfWeek loc 时区中 t 所在周的最后一纳秒
+func EndOfWeek(t time.Time, loc *time.Location, weekStart time.Weekday) time.Time {
+	start := StartOfWeek(t, loc, weekStart)
+	return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+}
+
+// StartOfMonth loc 时区中 t 所在月的第一天零点
+func StartOfMonth(t time.Time, loc *time.Location) time.Time {
+	t = inLocation(t, loc)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// EndOfMonth loc 时区中 t 所在月的最后一纳秒
+func EndOfMonth(t time.Time, loc *time.Location) time.Time {
+	start := StartOfMonth(t, loc)
+	return time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+}
+
+// IsSameDay a b 在 loc 时区中是否为同一天
+func IsSameDay(a, b time.Time, loc *time.Location) bool {
+	a, b = inLocation(a, loc), inLocation(b, loc)
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// DaysBetween loc 时区中 a 到 b 相差的自然日数 ，b 早于 a 时为负数
+func DaysBetween(a, b time.Time, loc *time.Location) int {
+	a, b = StartOfDay(a, loc), StartOfDay(b, loc)
+	// 按 UTC 计算日期差 ，不受夏令时影响
+	da := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	db := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da) / (24 * time.Hour))
+}
+
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return t
+	}
+	return t.In(loc)
+}
@@ -0,0 +1,224 @@
+package ttime
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时间来源 ，测试中使用 FakeClock 手动推进时间 ，不需要真的等待
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Ticker Clock 创建的周期触发器
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// WithClock 设置时间轮使用的时钟 ，默认使用系统时间
+func WithClock(clock Clock) Option {
+	return func(tw *TimeWheel) {
+		if clock != nil {
+			tw.clock = clock
+		}
+	}
+}
+
+// NewRealClock 使用系统时间的时钟
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock 手动推进的时钟 ，只有调用 Advance、Set 时时间才会变化
+// 和 time.Ticker 不同 ，Ticker 的每次触发都会等待接收方取走 ，不会丢弃 ，没有停止又不再接收的 Ticker 会让 Advance 阻塞
+// Advance 返回时接收方已经取走推进期间的所有触发 ，但最后一次触发可能还在处理
+// 接收方处理一次触发时 ，Advance 已经把时间推进到下一个到期点 ，此时调用 Now 得到的不是这次触发的时间
+// 测试中需要触发时间时 ，按推进的步长判断 ，不要在回调中记录 Now
+type FakeClock struct {
+	advanceMu sync.Mutex
+	mu        sync.Mutex
+	cond      *sync.Cond
+	now       time.Time
+	waiters   []*fakeWaiter
+}
+
+// 等待 After、Sleep 或者 Ticker 触发
+type fakeWaiter struct {
+	until  time.Time
+	period time.Duration
+	ch     chan time.Time
+	// Ticker 停止时关闭
+	done chan struct{}
+}
+
+// NewFakeClock 创建从 now 开始的时钟 ，now 为零值时从 2020-01-01 00:00:00 UTC 开始
+func NewFakeClock(now time.Time) *FakeClock {
+	if now.IsZero() {
+		now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker 和 time.NewTicker 一样 ，d 必须大于0
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("ttime: non-positive interval for FakeClock.NewTicker")
+	}
+	w := &fakeWaiter{period: d, ch: make(chan time.Time), done: make(chan struct{})}
+	c.mu.Lock()
+	w.until = c.now.Add(d)
+	c.addWaiter(w)
+	c.mu.Unlock()
+	return &fakeTicker{clock: c, w: w}
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	w := &fakeWaiter{ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		w.ch <- c.now
+		return w.ch
+	}
+	w.until = c.now.Add(d)
+	c.addWaiter(w)
+	return w.ch
+}
+
+// Sleep 阻塞到其他协程把时间推进 d 之后
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance 把时间推进 d ，按时间顺序触发到期的 After、Sleep 和 Ticker
+func (c *FakeClock) Advance(d time.Duration) {
+	c.advanceMu.Lock()
+	defer c.advanceMu.Unlock()
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		var next *fakeWaiter
+		for _, w := range c.waiters {
+			if !w.until.After(target) && (next == nil || w.until.Before(next.until)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.until.After(c.now) {
+			c.now = next.until
+		}
+		now := c.now
+		if next.period <= 0 {
+			c.removeWaiter(next)
+			next.ch <- now
+			continue
+		}
+		next.until = next.until.Add(next.period)
+		// 等待接收方时不持有锁 ，接收方可以调用 Now
+		c.mu.Unlock()
+		select {
+		case next.ch <- now:
+		case <-next.done:
+		}
+		c.mu.Lock()
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mu.Unlock()
+}
+
+// Set 把时间推进到 t ，t 早于当前时间时不变
+func (c *FakeClock) Set(t time.Time) {
+	c.Advance(t.Sub(c.Now()))
+}
+
+// BlockUntil 阻塞到至少有 n 个等待中的 After、Sleep 或者 Ticker
+// 用于确认被测协程已经开始等待 ，再推进时间
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// Waiters 等待中的 After、Sleep 和 Ticker 数量
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+func (c *FakeClock) addWaiter(w *fakeWaiter) {
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+func (c *FakeClock) removeWaiter(w *fakeWaiter) {
+	for i, v := range c.waiters {
+		if v == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	w     *fakeWaiter
+	once  sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+func (t *fakeTicker) Stop() {
+	t.once.Do(func() {
+		t.clock.mu.Lock()
+		t.clock.removeWaiter(t.w)
+		t.clock.mu.Unlock()
+		close(t.w.done)
+	})
+}
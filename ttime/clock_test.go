@@ -0,0 +1,117 @@
+package ttime
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 在提交协程中直接执行回调 ，advance 返回时回调已经执行完
+type syncExecutor struct{}
+
+func (syncExecutor) Submit(task func()) error {
+	task()
+	return nil
+}
+
+// 推进时间 ，返回时时间轮已经处理完推进期间的所有刻度 ，提交的回调也已经执行完
+func advance(clock *FakeClock, tw *TimeWheel, d time.Duration) {
+	clock.Advance(d)
+	tw.Len()
+	for {
+		tw.submitMu.Lock()
+		submitting := tw.submitting
+		tw.submitMu.Unlock()
+		if !submitting {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	if !clock.Now().Equal(start) {
+		t.Fatalf("now = %s", clock.Now())
+	}
+
+	after := clock.After(time.Second)
+	if at := <-clock.After(0); !at.Equal(start) {
+		t.Fatalf("After(0) = %s", at)
+	}
+	ticker := clock.NewTicker(300 * time.Millisecond)
+	slept := make(chan time.Time, 1)
+	go func() {
+		clock.Sleep(time.Second * 2)
+		slept <- clock.Now()
+	}()
+	clock.BlockUntil(3)
+
+	// Advance 等待接收方取走每次触发
+	done := make(chan struct{})
+	go func() {
+		clock.Advance(time.Second)
+		close(done)
+	}()
+	for i := 1; i <= 3; i++ {
+		if at := <-ticker.C(); !at.Equal(start.Add(time.Duration(i) * 300 * time.Millisecond)) {
+			t.Fatalf("tick %d at %s", i, at)
+		}
+	}
+	<-done
+	if at := <-after; !at.Equal(start.Add(time.Second)) {
+		t.Fatalf("After fired at %s", at)
+	}
+	select {
+	case <-slept:
+		t.Fatalf("Sleep returned early")
+	default:
+	}
+
+	// 停止的 Ticker 不再触发 ，也不会阻塞 Advance
+	ticker.Stop()
+	ticker.Stop()
+	clock.Set(start.Add(time.Second * 2))
+	if at := <-slept; !at.Equal(start.Add(time.Second * 2)) {
+		t.Fatalf("Sleep returned at %s", at)
+	}
+	if n := clock.Waiters(); n != 0 {
+		t.Fatalf("waiters = %d", n)
+	}
+	// 不能回退
+	clock.Set(start)
+	if !clock.Now().Equal(start.Add(time.Second * 2)) {
+		t.Fatalf("now = %s", clock.Now())
+	}
+}
+
+func TestTimeWheel_FakeClockClassic(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithSlotNum(60), WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.Start()
+	defer tw.Stop(context.Background())
+
+	fired := make(chan int, 4)
+	job := func(kv ...interface{}) { fired <- kv[0].(int) }
+	tw.AddTimer(time.Second*3, "a", job, 1)
+	tw.AddTimer(time.Second*90, "b", job, 2)
+	// 经典模式在到期后的下一个刻度执行
+	advance(clock, tw, time.Second*2)
+	if len(fired) != 0 {
+		t.Fatalf("fired early")
+	}
+	advance(clock, tw, time.Second*2)
+	if len(fired) != 1 || <-fired != 1 {
+		t.Fatalf("timer a not fired")
+	}
+	advance(clock, tw, time.Second*85)
+	if len(fired) != 0 {
+		t.Fatalf("timer b fired early")
+	}
+	advance(clock, tw, time.Second*2)
+	if len(fired) != 1 || <-fired != 2 {
+		t.Fatalf("timer b not fired after one circle")
+	}
+}
@@ -0,0 +1,244 @@
+package ttime
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CronSchedule 解析后的cron 表达式
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// 日和星期都不是 * 时 ，满足其中一个即可
+	domStar, dowStar bool
+	loc              *time.Location
+}
+
+type cronBounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSecond = cronBounds{min: 0, max: 59}
+	cronMinute = cronBounds{min: 0, max: 59}
+	cronHour   = cronBounds{min: 0, max: 23}
+	cronDom    = cronBounds{min: 1, max: 31}
+	cronMonth  = cronBounds{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 也表示星期日
+	cronDow = cronBounds{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// ParseCron 解析cron 表达式
+// 5 个字段: 分 时 日 月 星期 ，6 个字段: 秒 分 时 日 月 星期
+// 支持 * ? , - / 、月份和星期的英文缩写 ，以及 @yearly @monthly @weekly @daily @hourly
+// 时区使用 CRON_TZ= 或 TZ= 前缀 ，比如 "CRON_TZ=Asia/Shanghai 0 9 * * MON-FRI" ，默认 time.Local ，和 Parse 一致
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	s := &CronSchedule{loc: time.Local}
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, errors.Errorf("ParseCron_err missing fields spec = %s", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ParseCron_err bad time zone %s", name)
+		}
+		s.loc = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, errors.Errorf("ParseCron_err expected 5 or 6 fields , got %d spec = %s", len(fields), spec)
+	}
+	var err error
+	if s.second, _, err = parseCronField(fields[0], cronSecond); err != nil {
+		return nil, err
+	}
+	if s.minute, _, err = parseCronField(fields[1], cronMinute); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = parseCronField(fields[2], cronHour); err != nil {
+		return nil, err
+	}
+	if s.dom, s.domStar, err = parseCronField(fields[3], cronDom); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = parseCronField(fields[4], cronMonth); err != nil {
+		return nil, err
+	}
+	if s.dow, s.dowStar, err = parseCronField(fields[5], cronDow); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// 解析一个字段 ，返回每个取值对应的位
+func parseCronField(field string, b cronBounds) (bits uint64, star bool, err error) {
+	star = field == "*" || field == "?"
+	for _, part := range strings.Split(field, ",") {
+		var v uint64
+		if v, err = parseCronRange(part, b); err != nil {
+			return
+		}
+		bits |= v
+	}
+	return
+}
+
+func parseCronRange(part string, b cronBounds) (bits uint64, err error) {
+	rangeAndStep := strings.SplitN(part, "/", 2)
+	lowAndHigh := strings.SplitN(rangeAndStep[0], "-", 2)
+	start, end, step := b.min, b.max, 1
+	if lowAndHigh[0] != "*" && lowAndHigh[0] != "?" {
+		if start, err = parseCronValue(lowAndHigh[0], b); err != nil {
+			return
+		}
+		end = start
+		if len(lowAndHigh) == 2 {
+			if end, err = parseCronValue(lowAndHigh[1], b); err != nil {
+				return
+			}
+		} else if len(rangeAndStep) == 2 {
+			// a/n 表示从 a 到最大值
+			end = b.max
+		}
+	} else if len(lowAndHigh) == 2 {
+		err = errors.Errorf("ParseCron_err bad range %s", part)
+		return
+	}
+	if len(rangeAndStep) == 2 {
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+			err = errors.Errorf("ParseCron_err bad step %s", part)
+			return
+		}
+	}
+	if start > end {
+		err = errors.Errorf("ParseCron_err range start > end %s", part)
+		return
+	}
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return
+}
+
+func parseCronValue(v string, b cronBounds) (n int, err error) {
+	if i, ok := b.names[strings.ToLower(v)]; ok {
+		return i, nil
+	}
+	if n, err = strconv.Atoi(v); err != nil {
+		err = errors.Errorf("ParseCron_err bad value %s", v)
+		return
+	}
+	if n < b.min || n > b.max {
+		err = errors.Errorf("ParseCron_err value %d out of range [%d, %d]", n, b.min, b.max)
+	}
+	return
+}
+
+// Location 表达式使用的时区
+func (s *CronSchedule) Location() *time.Location {
+	return s.loc
+}
+
+// Next t 之后下一次触发的时间 ，5 年内没有满足的时间返回零值
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, s.loc).Add(time.Second)
+	yearLimit := t.Year() + 5
+	// 某个字段进位时 ，更小的字段从最小值开始
+	added := false
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for 1<<uint(t.Month())&s.month == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Hour())&s.hour == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Minute())&s.minute == 0 {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, s.loc)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+	for 1<<uint(t.Second())&s.second == 0 {
+		added = true
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto WRAP
+		}
+	}
+	return t.In(origLoc)
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&s.dom != 0
+	dowMatch := 1<<uint(t.Weekday())&s.dow != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
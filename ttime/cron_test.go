@@ -0,0 +1,93 @@
+package ttime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("no tzdata")
+	}
+	ny, _ := time.LoadLocation("America/New_York")
+	tests := []struct {
+		spec string
+		from string
+		want string
+		loc  *time.Location
+	}{
+		{"* * * * *", "2022-10-01 10:00:30", "2022-10-01 10:01:00", time.Local},
+		{"*/15 * * * * *", "2022-10-01 10:00:31", "2022-10-01 10:00:45", time.Local},
+		{"0 9 * * MON-FRI", "2022-10-01 10:00:00", "2022-10-03 09:00:00", time.Local},
+		{"30 2 1 * *", "2022-12-05 00:00:00", "2023-01-01 02:30:00", time.Local},
+		{"0 0 29 2 *", "2022-03-01 00:00:00", "2024-02-29 00:00:00", time.Local},
+		{"0 12 * * 7", "2022-10-01 13:00:00", "2022-10-02 12:00:00", time.Local},
+		{"0 0 1,15 * MON", "2022-10-02 00:00:00", "2022-10-03 00:00:00", time.Local},
+		{"5-10/5 8 * JAN,jul ?", "2022-01-31 08:10:00", "2022-07-01 08:05:00", time.Local},
+		{"@daily", "2022-10-01 23:59:59", "2022-10-02 00:00:00", time.Local},
+		{"@hourly", "2022-10-01 10:00:00", "2022-10-01 11:00:00", time.Local},
+		{"CRON_TZ=America/New_York 0 9 * * *", "2022-10-01 10:00:00", "2022-10-01 21:00:00", shanghai},
+		{"TZ=Asia/Shanghai 0 9 * * *", "2022-10-01 10:00:00", "2022-10-01 21:00:00", ny},
+		// 夏令时开始 ，02:30 不存在
+		{"CRON_TZ=America/New_York 30 2 * * *", "2022-03-13 00:00:00", "2022-03-14 02:30:00", ny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, _ := time.ParseInLocation("2006-01-02 15:04:05", tt.from, tt.loc)
+			want, _ := time.ParseInLocation("2006-01-02 15:04:05", tt.want, tt.loc)
+			if got := s.Next(from); !got.Equal(want) {
+				t.Fatalf("Next(%s) = %s , want %s", from, got, want)
+			}
+		})
+	}
+}
+
+func TestParseCron_Local(t *testing.T) {
+	s, err := ParseCron("0 0 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Location() != time.Local {
+		t.Fatalf("default location = %s", s.Location())
+	}
+	next := s.Next(time.Now()).In(time.Local)
+	if next.Hour() != 0 || next.Minute() != 0 || next.Second() != 0 {
+		t.Fatalf("next midnight = %s", next)
+	}
+}
+
+func TestParseCron_Err(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"*-5 * * * *",
+		"a * * * *",
+		"CRON_TZ=Nowhere/City * * * * *",
+		"CRON_TZ=Asia/Shanghai",
+	}
+	for _, spec := range specs {
+		if _, err := ParseCron(spec); err == nil {
+			t.Fatalf("ParseCron(%q) should fail", spec)
+		}
+	}
+	s, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Next(time.Now()).IsZero() {
+		t.Fatalf("Feb 30 should never fire")
+	}
+}
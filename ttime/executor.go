@@ -0,0 +1,165 @@
+package ttime
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/heyehang/go-im-pkg/util"
+)
+
+// Executor 执行到期的回调 ，ants.Pool 实现了该接口
+type Executor interface {
+	Submit(task func()) error
+}
+
+// WithExecutor 使用协程池执行回调 ，避免大量定时器同时到期时创建过多协程 ，默认每个回调一个协程
+// Submit 失败(比如非阻塞的池已满)时计入 Rejected ，回调改为在新协程中执行 ，保证不丢失
+// Submit 在单独的协程中按到期顺序调用 ，阻塞的池满时不会阻塞时间轮 ，回调中可以调用 Reset、Stop、AddTimer
+func WithExecutor(executor Executor) Option {
+	return func(tw *TimeWheel) {
+		tw.executor = executor
+	}
+}
+
+// WithPanicHandler 回调panic 时调用 ，panic 总是会被捕获 ，不会导致进程退出
+func WithPanicHandler(handler util.PanicErr) Option {
+	return func(tw *TimeWheel) {
+		tw.panicHandler = handler
+	}
+}
+
+// WithLateThreshold 回调实际执行时间比到期时间晚超过 threshold 时计入 Late ，默认为一个刻度
+func WithLateThreshold(threshold time.Duration) Option {
+	return func(tw *TimeWheel) {
+		tw.lateThreshold = threshold
+	}
+}
+
+// TimeWheelStats 回调执行统计
+type TimeWheelStats struct {
+	// 执行的回调数
+	Fired int64
+	// 延迟超过 WithLateThreshold 的回调数
+	Late int64
+	// panic 的回调数
+	Panics int64
+	// Executor 提交失败的回调数
+	Rejected int64
+	// WithSkipIfRunning 跳过的周期任务执行次数
+	Skipped int64
+	// 到期时间到开始执行的最大延迟、总延迟
+	MaxLag   time.Duration
+	TotalLag time.Duration
+}
+
+// AvgLag 平均延迟
+func (s TimeWheelStats) AvgLag() time.Duration {
+	if s.Fired == 0 {
+		return 0
+	}
+	return s.TotalLag / time.Duration(s.Fired)
+}
+
+type timeWheelStats struct {
+	fired    int64
+	late     int64
+	panics   int64
+	rejected int64
+	skipped  int64
+	maxLag   int64
+	totalLag int64
+}
+
+// Stats 当前回调执行统计 ，可以上报监控
+func (tw *TimeWheel) Stats() TimeWheelStats {
+	return TimeWheelStats{
+		Fired:    atomic.LoadInt64(&tw.stats.fired),
+		Late:     atomic.LoadInt64(&tw.stats.late),
+		Panics:   atomic.LoadInt64(&tw.stats.panics),
+		Rejected: atomic.LoadInt64(&tw.stats.rejected),
+		Skipped:  atomic.LoadInt64(&tw.stats.skipped),
+		MaxLag:   time.Duration(atomic.LoadInt64(&tw.stats.maxLag)),
+		TotalLag: time.Duration(atomic.LoadInt64(&tw.stats.totalLag)),
+	}
+}
+
+// 提交回调到 Executor ，deadline 为本次计划执行的时间
+func (tw *TimeWheel) execute(task *Task, deadline time.Time) {
+	if r := task.recurring; r != nil && !r.begin(tw) {
+		return
+	}
+	tw.submit(task, deadline, nil)
+}
+
+// 提交到 Executor ，done 在回调结束后调用
+func (tw *TimeWheel) submit(task *Task, deadline time.Time, done func()) {
+	run := func() {
+		if done != nil {
+			defer done()
+		}
+		tw.runJob(task, deadline)
+	}
+	if tw.executor == nil {
+		go run()
+		return
+	}
+	// 时间轮协程只入队 ，等待 Executor 的是提交协程
+	tw.submitMu.Lock()
+	tw.submitQueue = append(tw.submitQueue, run)
+	if !tw.submitting {
+		tw.submitting = true
+		go tw.submitLoop()
+	}
+	tw.submitMu.Unlock()
+}
+
+// 依次提交队列中的回调 ，队列为空时退出
+func (tw *TimeWheel) submitLoop() {
+	for {
+		tw.submitMu.Lock()
+		queue := tw.submitQueue
+		tw.submitQueue = nil
+		if len(queue) == 0 {
+			tw.submitting = false
+			tw.submitMu.Unlock()
+			return
+		}
+		tw.submitMu.Unlock()
+		for _, run := range queue {
+			if err := tw.executor.Submit(run); err != nil {
+				atomic.AddInt64(&tw.stats.rejected, 1)
+				go run()
+			}
+		}
+	}
+}
+
+func (tw *TimeWheel) runJob(task *Task, deadline time.Time) {
+	if task.recurring != nil {
+		defer task.recurring.end()
+	}
+	lag := tw.clock.Now().Sub(deadline)
+	if lag < 0 {
+		lag = 0
+	}
+	atomic.AddInt64(&tw.stats.fired, 1)
+	atomic.AddInt64(&tw.stats.totalLag, int64(lag))
+	for {
+		max := atomic.LoadInt64(&tw.stats.maxLag)
+		if int64(lag) <= max || atomic.CompareAndSwapInt64(&tw.stats.maxLag, max, int64(lag)) {
+			break
+		}
+	}
+	if lag > tw.lateThreshold {
+		atomic.AddInt64(&tw.stats.late, 1)
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			atomic.AddInt64(&tw.stats.panics, 1)
+			if tw.panicHandler != nil {
+				tw.panicHandler(err)
+			}
+		}
+	}()
+	task.job(task.args...)
+}
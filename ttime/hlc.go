@@ -0,0 +1,162 @@
+package ttime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HLC 时间戳低 16 位为逻辑计数 ，高 48 位为毫秒时间戳
+	hlcLogicalBits = 16
+	hlcLogicalMask = 1<<hlcLogicalBits - 1
+	// DefaultMaxDrift 默认允许远端时间戳超前本地时钟的最大值
+	DefaultMaxDrift = time.Millisecond * 500
+)
+
+var (
+	// ErrClockDrift 远端时间戳超前本地时钟太多 ，一般是某个节点的时钟不准
+	ErrClockDrift = errors.New("hlc remote timestamp too far ahead")
+	// ErrHLCFormat 解析 HLC 时间戳失败
+	ErrHLCFormat = errors.New("hlc timestamp format error")
+)
+
+// HLCTimestamp 混合逻辑时钟时间戳 ，高 48 位为毫秒时间戳 ，低 16 位为逻辑计数
+// 可以直接比较大小 ，按字节或者字符串排序的结果和数值一致
+type HLCTimestamp uint64
+
+// NewHLCTimestamp 由毫秒时间戳和逻辑计数组成时间戳
+func NewHLCTimestamp(ms int64, logical uint16) HLCTimestamp {
+	return HLCTimestamp(uint64(ms)<<hlcLogicalBits | uint64(logical))
+}
+
+// Physical 毫秒时间戳
+func (t HLCTimestamp) Physical() int64 {
+	return int64(t >> hlcLogicalBits)
+}
+
+// Logical 逻辑计数
+func (t HLCTimestamp) Logical() uint16 {
+	return uint16(t & hlcLogicalMask)
+}
+
+// Time 物理时间部分
+func (t HLCTimestamp) Time() time.Time {
+	return time.UnixMilli(t.Physical())
+}
+
+// Bytes 8 字节大端编码
+func (t HLCTimestamp) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t))
+	return b
+}
+
+// String 16 位十六进制 ，定长 ，可以按字符串排序
+func (t HLCTimestamp) String() string {
+	return fmt.Sprintf("%016x", uint64(t))
+}
+
+// HLCTimestampFromBytes 解码 Bytes 的结果
+func HLCTimestampFromBytes(b []byte) (HLCTimestamp, error) {
+	if len(b) != 8 {
+		return 0, errors.Wrapf(ErrHLCFormat, "HLCTimestampFromBytes_err length %d", len(b))
+	}
+	return HLCTimestamp(binary.BigEndian.Uint64(b)), nil
+}
+
+// ParseHLCTimestamp 解码 String 的结果
+func ParseHLCTimestamp(s string) (HLCTimestamp, error) {
+	if len(s) != 16 {
+		return 0, errors.Wrapf(ErrHLCFormat, "ParseHLCTimestamp_err length %d", len(s))
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, errors.Wrapf(ErrHLCFormat, "ParseHLCTimestamp_err %s", s)
+	}
+	return HLCTimestamp(v), nil
+}
+
+type HLCOption func(h *HLC)
+
+// WithHLCClock 设置物理时钟 ，默认使用系统时间
+func WithHLCClock(clock Clock) HLCOption {
+	return func(h *HLC) {
+		if clock != nil {
+			h.clock = clock
+		}
+	}
+}
+
+// WithMaxDrift 允许远端时间戳超前本地时钟的最大值 ，小于等于0 时不检查 ，默认 DefaultMaxDrift
+func WithMaxDrift(d time.Duration) HLCOption {
+	return func(h *HLC) {
+		h.maxDrift = d
+	}
+}
+
+// HLC 混合逻辑时钟 ，用于多个网关节点之间的消息排序
+// 本地生成的时间戳单调递增 ，收到其他节点的消息后调用 Update ，之后生成的时间戳都大于消息的时间戳
+// 物理时间接近本地时钟 ，节点时钟回拨时逻辑计数继续递增 ，同一毫秒内逻辑计数用完时借用下一毫秒
+// 可以并发调用
+type HLC struct {
+	mu       sync.Mutex
+	clock    Clock
+	maxDrift time.Duration
+	last     HLCTimestamp
+}
+
+func NewHLC(opts ...HLCOption) *HLC {
+	h := &HLC{
+		clock:    NewRealClock(),
+		maxDrift: DefaultMaxDrift,
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](h)
+	}
+	return h
+}
+
+// Now 生成新的时间戳 ，比之前生成或者 Update 的时间戳都大
+func (h *HLC) Now() HLCTimestamp {
+	pt := NewHLCTimestamp(h.clock.Now().UnixMilli(), 0)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = maxHLC(h.last+1, pt)
+	return h.last
+}
+
+// Update 收到远端时间戳时更新时钟 ，返回的时间戳比远端和本地之前的时间戳都大
+// 远端时间戳超前本地时钟超过 WithMaxDrift 时不更新 ，返回 ErrClockDrift
+func (h *HLC) Update(remote HLCTimestamp) (HLCTimestamp, error) {
+	now := h.clock.Now().UnixMilli()
+	if h.maxDrift > 0 {
+		if drift := time.Duration(remote.Physical()-now) * time.Millisecond; drift > h.maxDrift {
+			return 0, errors.Wrapf(ErrClockDrift, "HLC_Update_err remote %s ahead , max drift %s", drift, h.maxDrift)
+		}
+	}
+	pt := NewHLCTimestamp(now, 0)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// 物理时间相同时逻辑计数取较大值加1 ，本地时钟更大时逻辑计数从0 开始
+	h.last = maxHLC(maxHLC(h.last, remote)+1, pt)
+	return h.last, nil
+}
+
+// Last 最后一次生成的时间戳 ，不会递增
+func (h *HLC) Last() HLCTimestamp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+func maxHLC(a, b HLCTimestamp) HLCTimestamp {
+	if a > b {
+		return a
+	}
+	return b
+}
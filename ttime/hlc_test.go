@@ -0,0 +1,184 @@
+package ttime
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestHLC_Now(t *testing.T) {
+	clock := NewFakeClock(time.UnixMilli(1654041600000))
+	h := NewHLC(WithHLCClock(clock))
+	cases := []struct {
+		advance time.Duration
+		ms      int64
+		logical uint16
+	}{
+		{0, 1654041600000, 0},
+		{0, 1654041600000, 1},
+		{time.Microsecond * 500, 1654041600000, 2},
+		{time.Millisecond, 1654041600001, 0},
+		{time.Millisecond * 10, 1654041600011, 0},
+		{0, 1654041600011, 1},
+	}
+	for i, c := range cases {
+		clock.Advance(c.advance)
+		ts := h.Now()
+		if ts.Physical() != c.ms || ts.Logical() != c.logical {
+			t.Fatalf("case %d Now = %d.%d , want %d.%d", i, ts.Physical(), ts.Logical(), c.ms, c.logical)
+		}
+	}
+	if h.Last() != NewHLCTimestamp(1654041600011, 1) {
+		t.Fatalf("Last = %s", h.Last())
+	}
+
+	// 逻辑计数用完时借用下一毫秒
+	h = NewHLC(WithHLCClock(clock))
+	ms := clock.Now().UnixMilli()
+	var ts HLCTimestamp
+	for i := 0; i <= hlcLogicalMask+1; i++ {
+		ts = h.Now()
+	}
+	if ts != NewHLCTimestamp(ms+1, 0) {
+		t.Fatalf("overflow Now = %d.%d", ts.Physical(), ts.Logical())
+	}
+}
+
+func TestHLC_Update(t *testing.T) {
+	const base = 1654041600000
+	cases := []struct {
+		name    string
+		last    HLCTimestamp
+		remote  HLCTimestamp
+		want    HLCTimestamp
+		drifted bool
+	}{
+		{"local clock ahead", NewHLCTimestamp(base-5, 3), NewHLCTimestamp(base-2, 7), NewHLCTimestamp(base, 0), false},
+		{"remote ahead", NewHLCTimestamp(base, 2), NewHLCTimestamp(base+100, 5), NewHLCTimestamp(base+100, 6), false},
+		{"last ahead", NewHLCTimestamp(base+50, 9), NewHLCTimestamp(base+10, 20), NewHLCTimestamp(base+50, 10), false},
+		{"same physical", NewHLCTimestamp(base+50, 9), NewHLCTimestamp(base+50, 20), NewHLCTimestamp(base+50, 21), false},
+		{"same as clock", NewHLCTimestamp(base-1, 0), NewHLCTimestamp(base, 4), NewHLCTimestamp(base, 5), false},
+		{"max drift", 0, NewHLCTimestamp(base+500, 0), NewHLCTimestamp(base+500, 1), false},
+		{"too far ahead", NewHLCTimestamp(base, 1), NewHLCTimestamp(base+501, 0), NewHLCTimestamp(base, 1), true},
+	}
+	for _, c := range cases {
+		h := NewHLC(WithHLCClock(NewFakeClock(time.UnixMilli(base))))
+		h.last = c.last
+		got, err := h.Update(c.remote)
+		if c.drifted != (errors.Cause(err) == ErrClockDrift) {
+			t.Fatalf("%s err = %v", c.name, err)
+		}
+		if !c.drifted && got != c.want {
+			t.Fatalf("%s Update = %d.%d , want %d.%d", c.name, got.Physical(), got.Logical(), c.want.Physical(), c.want.Logical())
+		}
+		if h.Last() != c.want {
+			t.Fatalf("%s Last = %d.%d", c.name, h.Last().Physical(), h.Last().Logical())
+		}
+	}
+
+	h := NewHLC(WithHLCClock(NewFakeClock(time.UnixMilli(base))), WithMaxDrift(0))
+	if _, err := h.Update(NewHLCTimestamp(base+int64(time.Hour/time.Millisecond), 0)); err != nil {
+		t.Fatalf("drift check disabled err = %v", err)
+	}
+}
+
+// 两个节点互相发消息 ，接收方的时间戳总是大于发送方
+func TestHLC_CausalOrder(t *testing.T) {
+	clock := NewFakeClock(time.UnixMilli(1654041600000))
+	// b 的时钟慢 200ms
+	slow := NewFakeClock(time.UnixMilli(1654041600000 - 200))
+	a, b := NewHLC(WithHLCClock(clock)), NewHLC(WithHLCClock(slow))
+	sent := a.Now()
+	for i := 0; i < 100; i++ {
+		recv, err := b.Update(sent)
+		if err != nil || recv <= sent {
+			t.Fatalf("round %d recv %s <= sent %s , err = %v", i, recv, sent, err)
+		}
+		reply := b.Now()
+		if reply <= recv {
+			t.Fatalf("round %d reply not monotonic", i)
+		}
+		if sent, err = a.Update(reply); err != nil || sent <= reply {
+			t.Fatalf("round %d a %s <= reply %s , err = %v", i, sent, reply, err)
+		}
+	}
+}
+
+func TestHLC_Concurrent(t *testing.T) {
+	h := NewHLC()
+	const n = 1000
+	results := make([]HLCTimestamp, 0, n*4)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make([]HLCTimestamp, 0, n)
+			for i := 0; i < n; i++ {
+				ts := h.Now()
+				if len(local) > 0 && ts <= local[len(local)-1] {
+					t.Errorf("Now not monotonic")
+					return
+				}
+				local = append(local, ts)
+			}
+			mu.Lock()
+			results = append(results, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	seen := make(map[HLCTimestamp]bool, len(results))
+	for _, ts := range results {
+		if seen[ts] {
+			t.Fatalf("duplicate timestamp %s", ts)
+		}
+		seen[ts] = true
+	}
+}
+
+func TestHLCTimestamp_Encode(t *testing.T) {
+	values := []HLCTimestamp{
+		NewHLCTimestamp(1654041600000, 0),
+		NewHLCTimestamp(1654041600000, 1),
+		NewHLCTimestamp(1654041600000, 0xffff),
+		NewHLCTimestamp(1654041600001, 0),
+		NewHLCTimestamp(1<<48-1, 0xffff),
+		0,
+	}
+	for _, v := range values {
+		b := v.Bytes()
+		if got, err := HLCTimestampFromBytes(b); err != nil || got != v {
+			t.Fatalf("bytes round trip %x = %v %v", b, got, err)
+		}
+		s := v.String()
+		if got, err := ParseHLCTimestamp(s); err != nil || got != v {
+			t.Fatalf("string round trip %s = %v %v", s, got, err)
+		}
+	}
+	if NewHLCTimestamp(1654041600123, 7).Time().UnixMilli() != 1654041600123 {
+		t.Fatalf("Time mismatch")
+	}
+	// 编码后的排序和数值一致
+	sorted := append([]HLCTimestamp(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i := 1; i < len(sorted); i++ {
+		if bytes.Compare(sorted[i-1].Bytes(), sorted[i].Bytes()) >= 0 || sorted[i-1].String() >= sorted[i].String() {
+			t.Fatalf("encoding order mismatch %s %s", sorted[i-1], sorted[i])
+		}
+	}
+
+	for _, s := range []string{"", "123", "zzzzzzzzzzzzzzzz", "0000000000000000ff"} {
+		if _, err := ParseHLCTimestamp(s); errors.Cause(err) != ErrHLCFormat {
+			t.Fatalf("ParseHLCTimestamp(%q) err = %v", s, err)
+		}
+	}
+	if _, err := HLCTimestampFromBytes([]byte{1, 2, 3}); errors.Cause(err) != ErrHLCFormat {
+		t.Fatalf("HLCTimestampFromBytes err = %v", err)
+	}
+}
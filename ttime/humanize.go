@@ -0,0 +1,67 @@
+package ttime
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lang 人性化时间的语言
+type Lang string
+
+const (
+	LangEN Lang = "en"
+	LangZH Lang = "zh"
+)
+
+// LastSeen 人性化的最后在线时间 ，比如 刚刚、5分钟前、昨天
+// 日期按 now 的时区计算 ，传入用户时区的 now 即可按用户所在地显示
+// 1 分钟内或者 t 晚于 now: 刚刚
+// 1 小时内: x分钟前 ，跨过零点也按分钟显示 ，比如 00:10 看 23:50 是 20分钟前 ，不是昨天
+// 超过 1 小时 ，同一天: x小时前 ，前一天: 昨天
+// 7 天内: x天前 ，同一年: 01月02日 ，其他: 2006年01月02日
+// 不支持的语言使用英文
+func LastSeen(t, now time.Time, lang Lang) string {
+	t = t.In(now.Location())
+	d := now.Sub(t)
+	zh := lang == LangZH
+	switch {
+	case d < time.Minute:
+		if zh {
+			return "刚刚"
+		}
+		return "just now"
+	case d < time.Hour:
+		return agoText(int(d/time.Minute), "分钟前", "minute", zh)
+	}
+	days := DaysBetween(t, now, nil)
+	switch {
+	case days == 0:
+		return agoText(int(d/time.Hour), "小时前", "hour", zh)
+	case days == 1:
+		if zh {
+			return "昨天"
+		}
+		return "yesterday"
+	case days < 7:
+		return agoText(days, "天前", "day", zh)
+	case t.Year() == now.Year():
+		if zh {
+			return t.Format(LayoutCNMonthDay)
+		}
+		return t.Format("Jan 2")
+	}
+	if zh {
+		return t.Format(LayoutCNDate)
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+func agoText(n int, zhUnit, enUnit string, zh bool) string {
+	if zh {
+		return fmt.Sprintf("%d%s", n, zhUnit)
+	}
+	if n == 1 {
+		return fmt.Sprintf("1 %s ago", enUnit)
+	}
+	return fmt.Sprintf("%d %ss ago", n, enUnit)
+}
@@ -0,0 +1,106 @@
+package ttime
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	// 多层时间轮模式的默认刻度
+	DefaultHierarchicalInterval = time.Millisecond * 10
+)
+
+// WithHierarchical 多层时间轮模式 ，适合大量高精度的定时器 ，比如心跳超时、消息重传
+// 第一层每个槽为一个刻度 ，上一层每个槽为下一层转一圈的时间 ，延迟超过当前所有层时自动增加一层
+// 上层的槽到期时任务降级到下层 ，每个任务最多移动层数次 ，添加和删除都是O(1)
+// 刻度最小为1毫秒 ，默认 DefaultHierarchicalInterval
+func WithHierarchical() Option {
+	return func(tw *TimeWheel) {
+		tw.hierarchical = true
+	}
+}
+
+// 时间轮的一层
+type wheelLevel struct {
+	// 每个槽的跨度 ，单位为刻度
+	span  int64
+	slots []*list.List
+}
+
+func newWheelLevel(span int64, slotNum int) *wheelLevel {
+	l := &wheelLevel{span: span, slots: make([]*list.List, slotNum)}
+	for i := 0; i < slotNum; i++ {
+		l.slots[i] = list.New()
+	}
+	return l
+}
+
+// 当前层转一圈的跨度
+func (l *wheelLevel) interval() int64 {
+	return l.span * int64(len(l.slots))
+}
+
+func (l *wheelLevel) slot(expire int64) *list.List {
+	return l.slots[(expire/l.span)%int64(len(l.slots))]
+}
+
+// 新增任务 ，到期的刻度按到期时间和启动时间计算 ，避免指针滞后时延迟变长
+func (tw *TimeWheel) addLevelTask(task *Task) {
+	elapsed := task.deadline.Sub(tw.startTime)
+	task.expire = int64((elapsed + tw.interval - 1) / tw.interval)
+	tw.placeTask(task)
+}
+
+// 把任务放入合适的层 ，已经到期的立即执行
+func (tw *TimeWheel) placeTask(task *Task) {
+	if task.expire <= tw.tick {
+		tw.fire(task)
+		return
+	}
+	for i := 0; ; i++ {
+		if i == len(tw.levels) {
+			// 溢出 ，增加一层
+			tw.levels = append(tw.levels, newWheelLevel(tw.levels[i-1].interval(), tw.slotNum))
+		}
+		l := tw.levels[i]
+		// 当前层的起始刻度
+		current := tw.tick - tw.tick%l.span
+		if task.expire < current+l.interval() {
+			task.bucket = l.slot(task.expire)
+			task.elem = task.bucket.PushBack(task)
+			return
+		}
+	}
+}
+
+// 指针移动到 now 对应的刻度 ，ticker 丢失的刻度会补上
+func (tw *TimeWheel) advanceTo(now time.Time) {
+	target := int64(now.Sub(tw.startTime) / tw.interval)
+	for tw.tick < target {
+		tw.tick++
+		// 从上往下处理 ，上层到期的任务降级后 ，同一个刻度的下层槽会一起处理
+		for i := len(tw.levels) - 1; i >= 0; i-- {
+			l := tw.levels[i]
+			if tw.tick%l.span != 0 {
+				continue
+			}
+			tw.flushSlot(l.slot(tw.tick))
+		}
+	}
+}
+
+// 取出槽中所有任务重新放置 ，到期的执行 ，未到期的降级到下层
+func (tw *TimeWheel) flushSlot(bucket *list.List) {
+	if bucket.Len() == 0 {
+		return
+	}
+	for e := bucket.Front(); e != nil; {
+		next := e.Next()
+		task := e.Value.(*Task)
+		bucket.Remove(e)
+		task.bucket = nil
+		task.elem = nil
+		tw.placeTask(task)
+		e = next
+	}
+}
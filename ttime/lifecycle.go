@@ -0,0 +1,299 @@
+package ttime
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// PendingTimer Stop 时未触发的定时器 ，可以持久化后重新添加
+type PendingTimer struct {
+	Key      interface{}
+	Deadline time.Time
+	Job      JobFunc
+	Args     []interface{}
+	// 是否为 AddTicker AddCron 添加的周期任务
+	Recurring bool
+}
+
+type stopOptions struct {
+	drain         bool
+	returnPending bool
+}
+
+type StopOption func(o *stopOptions)
+
+// WithStopDrain 停止时立即执行所有未触发的一次性定时器 ，并等待执行完成或者ctx 结束
+// 周期任务不执行
+func WithStopDrain() StopOption {
+	return func(o *stopOptions) {
+		o.drain = true
+	}
+}
+
+// WithStopReturnPending 停止时返回未触发的定时器 ，使用 WithStopDrain 时只返回周期任务
+func WithStopReturnPending() StopOption {
+	return func(o *stopOptions) {
+		o.returnPending = true
+	}
+}
+
+// Start 启动时间轮 ，重复调用无影响 ，停止后可以再次启动
+// 停止期间添加、重置、停止定时器不会阻塞 ，启动后按调用顺序生效
+func (tw *TimeWheel) Start() {
+	tw.lifeMu.Lock()
+	defer tw.lifeMu.Unlock()
+	if tw.running {
+		return
+	}
+	tw.running = true
+	tw.startTime = tw.clock.Now()
+	tw.tick = 0
+	if tw.hierarchical {
+		tw.levels = tw.levels[:1]
+	}
+	tw.ticker = tw.clock.NewTicker(tw.interval)
+	ops := tw.stoppedOps
+	tw.stoppedOps = nil
+	go tw.start(ops)
+}
+
+// Stop 停止时间轮 ，未启动或者已经停止时只处理停止期间添加的定时器
+// 默认未触发的定时器全部停止丢弃 ，可以通过 WithStopDrain 立即执行 ，WithStopReturnPending 返回给调用方
+// ctx 结束时不再等待 WithStopDrain 的回调 ，返回 ctx.Err()
+func (tw *TimeWheel) Stop(ctx context.Context, opts ...StopOption) (pending []PendingTimer, err error) {
+	o := stopOptions{}
+	for i := 0; i < len(opts); i++ {
+		opts[i](&o)
+	}
+	tw.lifeMu.Lock()
+	var tasks []*Task
+	if tw.running {
+		reply := make(chan []*Task, 1)
+		tw.stopChannel <- reply
+		tasks = <-reply
+		tw.running = false
+	}
+	// 停止期间暂存的定时器还没有放入槽中 ，一起处理 ，不留到下次启动
+	for _, op := range tw.stoppedPending() {
+		task := op.timer.task
+		task.gen = op.gen
+		task.deadline = op.deadline
+		tasks = append(tasks, task)
+	}
+	tw.stoppedOps = nil
+	tw.lifeMu.Unlock()
+
+	wg := sync.WaitGroup{}
+	for _, task := range tasks {
+		t := task.timer
+		if task.recurring == nil && o.drain {
+			if !t.state.CompareAndSwap(task.gen<<1|1, task.gen<<1) {
+				continue
+			}
+			tw.unregister(t)
+			wg.Add(1)
+			tw.submit(task, task.deadline, wg.Done)
+			continue
+		}
+		// 任务已经从槽中取出 ，不需要再通知时间轮协程
+		if !t.deactivate() {
+			continue
+		}
+		if o.returnPending {
+			pending = append(pending, PendingTimer{
+				Key:       task.key,
+				Deadline:  task.deadline,
+				Job:       task.job,
+				Args:      task.args,
+				Recurring: task.recurring != nil,
+			})
+		}
+	}
+	if !o.drain {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// 时间轮协程中执行 ，先处理已经提交的操作 ，再取出所有等待触发的任务
+func (tw *TimeWheel) takeAll() []*Task {
+	for {
+		select {
+		case op := <-tw.opChannel:
+			tw.handleOp(op)
+			continue
+		default:
+		}
+		break
+	}
+	tasks := make([]*Task, 0, 16)
+	take := func(l *list.List) {
+		for e := l.Front(); e != nil; e = e.Next() {
+			task := e.Value.(*Task)
+			task.bucket = nil
+			task.elem = nil
+			tasks = append(tasks, task)
+		}
+		l.Init()
+	}
+	for _, l := range tw.slots {
+		take(l)
+	}
+	for _, level := range tw.levels {
+		for _, l := range level.slots {
+			take(l)
+		}
+	}
+	return tasks
+}
+
+// 提交定时器操作 ，运行中交给时间轮协程 ，停止期间暂存到启动时处理 ，避免操作channel 满时阻塞
+func (tw *TimeWheel) sendOp(op timerOp) {
+	tw.lifeMu.RLock()
+	if tw.running {
+		tw.opChannel <- op
+		tw.lifeMu.RUnlock()
+		return
+	}
+	tw.lifeMu.RUnlock()
+	tw.lifeMu.Lock()
+	defer tw.lifeMu.Unlock()
+	// 等待写锁期间可能已经启动
+	if tw.running {
+		tw.opChannel <- op
+		return
+	}
+	tw.stoppedOps = append(tw.stoppedOps, op)
+}
+
+// 停止期间暂存的操作中仍然有效的放置 ，每个等待触发的定时器对应一个 ，调用方持有 lifeMu
+func (tw *TimeWheel) stoppedPending() (ops []timerOp) {
+	for _, op := range tw.stoppedOps {
+		if !op.stop && op.timer.state.Load() == op.gen<<1|1 {
+			ops = append(ops, op)
+		}
+	}
+	return
+}
+
+// 停止期间暂存的定时器在此刻启动时放入的层和槽 ，启动时立即到期的返回false
+func (tw *TimeWheel) stoppedSlot(op timerOp) (level, pos int, ok bool) {
+	delay := op.deadline.Sub(tw.clock.Now())
+	if !tw.hierarchical {
+		if delay < 0 {
+			delay = 0
+		}
+		pos, _ = tw.getPositionAndCircle(delay)
+		return 0, pos, true
+	}
+	// 启动时 startTime 为当前时间 ，刻度从0 开始
+	expire := int64((delay + tw.interval - 1) / tw.interval)
+	if expire <= 0 {
+		return
+	}
+	slotNum := int64(tw.slotNum)
+	for span := int64(1); ; span *= slotNum {
+		if expire < span*slotNum {
+			return level, int(expire / span % slotNum), true
+		}
+		level++
+	}
+}
+
+// 读取时间轮协程维护的状态 ，运行中在时间轮协程中按操作顺序执行 ，停止时直接执行
+func (tw *TimeWheel) query(fn func()) {
+	tw.lifeMu.RLock()
+	defer tw.lifeMu.RUnlock()
+	if !tw.running {
+		fn()
+		return
+	}
+	done := make(chan struct{})
+	tw.opChannel <- timerOp{query: func() {
+		fn()
+		close(done)
+	}}
+	<-done
+}
+
+// Running 是否运行中
+func (tw *TimeWheel) Running() bool {
+	tw.lifeMu.RLock()
+	defer tw.lifeMu.RUnlock()
+	return tw.running
+}
+
+// Len 等待触发的定时器数量 ，包含调用之前添加的定时器 ，停止期间添加的也计入
+func (tw *TimeWheel) Len() (n int) {
+	tw.query(func() {
+		n = len(tw.stoppedPending())
+		for _, l := range tw.slots {
+			n += l.Len()
+		}
+		for _, level := range tw.levels {
+			for _, l := range level.slots {
+				n += l.Len()
+			}
+		}
+	})
+	return
+}
+
+// Pending key 对应的定时器距离触发的剩余时间 ，不存在或者已经触发时返回false
+func (tw *TimeWheel) Pending(key interface{}) (remaining time.Duration, ok bool) {
+	if key == nil {
+		return
+	}
+	tw.mu.Lock()
+	t := tw.timerKeys[key]
+	tw.mu.Unlock()
+	if t == nil || !t.Active() {
+		return
+	}
+	return t.Remaining(), true
+}
+
+// SlotCounts 每个槽中的任务数 ，经典模式只有一层 ，多层时间轮模式按层返回
+// 停止期间添加的定时器按此刻启动时放入的槽计入
+func (tw *TimeWheel) SlotCounts() (counts [][]int) {
+	tw.query(func() {
+		if !tw.hierarchical {
+			level := make([]int, len(tw.slots))
+			for i, l := range tw.slots {
+				level[i] = l.Len()
+			}
+			counts = append(counts, level)
+		} else {
+			for _, wl := range tw.levels {
+				level := make([]int, len(wl.slots))
+				for i, l := range wl.slots {
+					level[i] = l.Len()
+				}
+				counts = append(counts, level)
+			}
+		}
+		for _, op := range tw.stoppedPending() {
+			level, pos, ok := tw.stoppedSlot(op)
+			if !ok {
+				continue
+			}
+			for len(counts) <= level {
+				counts = append(counts, make([]int, tw.slotNum))
+			}
+			counts[level][pos]++
+		}
+	})
+	return
+}
@@ -0,0 +1,324 @@
+package ttime
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimeWheel_StopIdempotent(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(syncExecutor{}))
+	// 未启动时直接返回
+	if _, err := tw.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tw.Start()
+	tw.Start()
+	if !tw.Running() {
+		t.Fatal("not running")
+	}
+	tw.Stop(context.Background())
+	tw.Stop(context.Background())
+	if tw.Running() {
+		t.Fatal("still running")
+	}
+
+	// 停止后可以再次启动
+	var fired int32
+	tw.AddTimer(time.Millisecond*10, "k", func(kv ...interface{}) { atomic.AddInt32(&fired, 1) })
+	tw.Start()
+	defer tw.Stop(context.Background())
+	clock.BlockUntil(1)
+	advance(clock, tw, time.Millisecond*5)
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("timer fired early after restart")
+	}
+	advance(clock, tw, time.Millisecond*5)
+	if atomic.LoadInt32(&fired) != 1 {
+		t.Fatal("timer not fired after restart")
+	}
+}
+
+func TestTimeWheel_StopDrain(t *testing.T) {
+	tw := NewTimeWheel(WithInterval(time.Second))
+	tw.Start()
+	var fired int32
+	for i := 0; i < 20; i++ {
+		tw.AddTimer(time.Hour, i, func(kv ...interface{}) {
+			time.Sleep(time.Millisecond * 10)
+			atomic.AddInt32(&fired, 1)
+		})
+	}
+	ticker, _ := tw.AddTicker(time.Hour, "ticker", func(kv ...interface{}) { atomic.AddInt32(&fired, 100) })
+	pending, err := tw.Stop(context.Background(), WithStopDrain(), WithStopReturnPending())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fired); n != 20 {
+		t.Fatalf("fired = %d", n)
+	}
+	if len(pending) != 1 || pending[0].Key != "ticker" || !pending[0].Recurring {
+		t.Fatalf("pending = %+v", pending)
+	}
+	if ticker.Active() {
+		t.Fatal("ticker still active")
+	}
+	if _, ok := tw.Pending(0); ok {
+		t.Fatal("drained timer still pending")
+	}
+}
+
+func TestTimeWheel_StopDrainTimeout(t *testing.T) {
+	tw := NewTimeWheel(WithInterval(time.Second))
+	tw.Start()
+	release := make(chan struct{})
+	defer close(release)
+	tw.AddTimer(time.Hour, nil, func(kv ...interface{}) { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := tw.Stop(ctx, WithStopDrain()); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestTimeWheel_StopReturnPending(t *testing.T) {
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*10))
+	tw.Start()
+	var fired int32
+	job := func(kv ...interface{}) { atomic.AddInt32(&fired, 1) }
+	timers := make([]*Timer, 0, 20)
+	for i := 0; i < 20; i++ {
+		timer, _ := tw.AddTimer(time.Hour+time.Duration(i)*time.Minute, i, job, i)
+		timers = append(timers, timer)
+	}
+	timers[0].Stop()
+	pending, err := tw.Stop(context.Background(), WithStopReturnPending())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 19 {
+		t.Fatalf("pending = %d", len(pending))
+	}
+	for _, p := range pending {
+		i := p.Key.(int)
+		if p.Args[0] != i || p.Recurring {
+			t.Fatalf("pending = %+v", p)
+		}
+		if d := time.Until(p.Deadline); d < time.Hour+time.Duration(i-1)*time.Minute {
+			t.Fatalf("key %d deadline in %v", i, d)
+		}
+		if timers[i].Active() {
+			t.Fatalf("key %d still active", i)
+		}
+	}
+	if atomic.LoadInt32(&fired) != 0 {
+		t.Fatal("pending timer fired")
+	}
+	if n := tw.Len(); n != 0 {
+		t.Fatalf("Len = %d", n)
+	}
+}
+
+func TestTimeWheel_Introspection(t *testing.T) {
+	tw := NewTimeWheel(WithInterval(time.Second), WithSlotNum(60))
+	tw.Start()
+	defer tw.Stop(context.Background())
+	job := func(kv ...interface{}) {}
+	tw.AddTimer(time.Second*3, "a", job)
+	tw.AddTimer(time.Second*3, "b", job)
+	tw.AddTimer(time.Minute*2, "c", job)
+	if n := tw.Len(); n != 3 {
+		t.Fatalf("Len = %d", n)
+	}
+	if d, ok := tw.Pending("c"); !ok || d <= time.Minute {
+		t.Fatalf("Pending = %v %v", d, ok)
+	}
+	if _, ok := tw.Pending("x"); ok {
+		t.Fatal("unknown key pending")
+	}
+	counts := tw.SlotCounts()
+	if len(counts) != 1 || len(counts[0]) != 60 {
+		t.Fatalf("SlotCounts shape = %d", len(counts))
+	}
+	total, busiest := 0, 0
+	for _, n := range counts[0] {
+		total += n
+		if n > busiest {
+			busiest = n
+		}
+	}
+	if total != 3 || busiest != 2 {
+		t.Fatalf("total = %d busiest = %d", total, busiest)
+	}
+
+	htw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*10), WithSlotNum(64))
+	htw.Start()
+	defer htw.Stop(context.Background())
+	htw.AddTimer(time.Millisecond*100, nil, job)
+	htw.AddTimer(time.Hour, nil, job)
+	if n := htw.Len(); n != 2 {
+		t.Fatalf("hierarchical Len = %d", n)
+	}
+	if levels := len(htw.SlotCounts()); levels < 2 {
+		t.Fatalf("levels = %d", levels)
+	}
+}
+
+// 停止期间的操作超过操作channel 容量也不会阻塞 ，启动后按顺序生效
+func TestTimeWheel_OpsWhileStopped(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.Start()
+	tw.Stop(context.Background())
+
+	var fired int32
+	job := func(kv ...interface{}) { atomic.AddInt32(&fired, 1) }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 30; i++ {
+			timer, _ := tw.AddTimer(10*time.Millisecond, i, job)
+			switch i % 3 {
+			case 0:
+				timer.Stop()
+			case 1:
+				timer.Reset(time.Second)
+			}
+		}
+		tw.AddTicker(time.Second, "ticker", job)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timer ops blocked while stopped")
+	}
+
+	tw.Start()
+	defer tw.Stop(context.Background())
+	if n := tw.Len(); n != 21 {
+		t.Fatalf("len = %d , want 21", n)
+	}
+	advance(clock, tw, 20*time.Millisecond)
+	if n := atomic.LoadInt32(&fired); n != 10 {
+		t.Fatalf("fired = %d , want 10", n)
+	}
+}
+
+// 未启动时添加的定时器计入 Len ，Stop 可以返回 ，之后启动不会触发
+func TestTimeWheel_StopNeverStarted(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(syncExecutor{}))
+	var fired int32
+	job := func(kv ...interface{}) { atomic.AddInt32(&fired, 1) }
+	timer, _ := tw.AddTimer(time.Millisecond*10, "a", job, 1)
+	stopped, _ := tw.AddTimer(time.Millisecond*10, "b", job)
+	stopped.Stop()
+	ticker, _ := tw.AddTicker(time.Second, "ticker", job)
+	ticker.Reset(time.Minute)
+
+	if _, ok := tw.Pending("a"); !ok {
+		t.Fatal("a not pending")
+	}
+	if n := tw.Len(); n != 2 {
+		t.Fatalf("Len = %d , want 2", n)
+	}
+	pending, err := tw.Stop(context.Background(), WithStopReturnPending())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("pending = %+v", pending)
+	}
+	for _, p := range pending {
+		switch p.Key {
+		case "a":
+			if p.Recurring || p.Args[0] != 1 || !p.Deadline.Equal(clock.Now().Add(time.Millisecond*10)) {
+				t.Fatalf("pending = %+v", p)
+			}
+		case "ticker":
+			if !p.Recurring || !p.Deadline.Equal(clock.Now().Add(time.Minute)) {
+				t.Fatalf("pending = %+v", p)
+			}
+		default:
+			t.Fatalf("pending = %+v", p)
+		}
+	}
+	if timer.Active() || ticker.Active() {
+		t.Fatal("returned timer still active")
+	}
+	if n := tw.Len(); n != 0 {
+		t.Fatalf("Len after stop = %d", n)
+	}
+
+	tw.Start()
+	defer tw.Stop(context.Background())
+	clock.BlockUntil(1)
+	advance(clock, tw, time.Minute*2)
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Fatalf("fired = %d after restart", n)
+	}
+}
+
+// 停止期间添加的定时器 ，Len 和 SlotCounts 与启动后一致 ，WithStopDrain 立即执行
+func TestTimeWheel_StoppedCounts(t *testing.T) {
+	for _, hierarchical := range []bool{false, true} {
+		clock := NewFakeClock(time.Time{})
+		opts := []Option{WithInterval(time.Millisecond * 10), WithSlotNum(8), WithClock(clock), WithExecutor(syncExecutor{})}
+		if hierarchical {
+			opts = append(opts, WithHierarchical())
+		}
+		tw := NewTimeWheel(opts...)
+		tw.Start()
+		tw.Stop(context.Background())
+
+		job := func(kv ...interface{}) {}
+		for i, d := range []time.Duration{0, 30, 50, 50, 200, 900} {
+			timer, _ := tw.AddTimer(d*time.Millisecond, i, job)
+			if i == 2 {
+				timer.Reset(time.Millisecond * 70)
+			}
+		}
+		stopped, _ := tw.AddTimer(time.Millisecond*40, "stopped", job)
+		stopped.Stop()
+		counts := tw.SlotCounts()
+		if n := tw.Len(); n != 6 {
+			t.Fatalf("hierarchical = %v , Len = %d , want 6", hierarchical, n)
+		}
+
+		// 多层时间轮模式下已经到期的定时器启动时立即执行 ，不占用槽
+		want := 6
+		if hierarchical {
+			want = 5
+		}
+		tw.Start()
+		if n := tw.Len(); n != want {
+			t.Fatalf("hierarchical = %v , Len after start = %d , want %d", hierarchical, n, want)
+		}
+		if started := tw.SlotCounts(); !reflect.DeepEqual(counts, started) {
+			t.Fatalf("hierarchical = %v , stopped counts = %v , started = %v", hierarchical, counts, started)
+		}
+		tw.Stop(context.Background())
+
+		var fired int32
+		for i := 0; i < 3; i++ {
+			tw.AddTimer(time.Hour, i, func(kv ...interface{}) { atomic.AddInt32(&fired, 1) })
+		}
+		tw.AddTicker(time.Hour, "ticker", job)
+		pending, err := tw.Stop(context.Background(), WithStopDrain(), WithStopReturnPending())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&fired); n != 3 {
+			t.Fatalf("fired = %d , want 3", n)
+		}
+		if len(pending) != 1 || pending[0].Key != "ticker" {
+			t.Fatalf("pending = %+v", pending)
+		}
+		if n := tw.Len(); n != 0 {
+			t.Fatalf("Len after drain = %d", n)
+		}
+	}
+}
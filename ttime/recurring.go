@@ -0,0 +1,165 @@
+package ttime
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidInterval 周期小于等于0
+var ErrInvalidInterval = errors.New("ticker interval must be positive")
+
+// 周期任务的参数
+type recurring struct {
+	// 根据上一次计划时间和当前时间计算下一次计划时间
+	next          func(prev, now time.Time) time.Time
+	jitter        time.Duration
+	skipIfRunning bool
+	// 上一次计划时间 ，不包含抖动
+	base    time.Time
+	running int32
+}
+
+type RecurringOption func(r *recurring)
+
+// WithJitter 每次执行时间随机延后 [0, jitter) ，避免多个进程同时执行
+func WithJitter(jitter time.Duration) RecurringOption {
+	return func(r *recurring) {
+		if jitter > 0 {
+			r.jitter = jitter
+		}
+	}
+}
+
+// WithSkipIfRunning 上一次执行还没结束时跳过本次执行 ，计入 Skipped
+func WithSkipIfRunning() RecurringOption {
+	return func(r *recurring) {
+		r.skipIfRunning = true
+	}
+}
+
+// AddTicker 添加周期任务 ，每隔 interval 执行一次 ，fn 的参数为空
+// 执行落后超过一个周期时跳过错过的周期 ，不会连续补执行
+// 返回的 Timer 停止后不再执行 ，Reset(d) 把下一次执行改为 d 之后 ，之后按周期继续
+// 周期小于刻度时按刻度执行
+func (tw *TimeWheel) AddTicker(interval time.Duration, key interface{}, fn JobFunc, opts ...RecurringOption) (*Timer, error) {
+	if interval <= 0 {
+		return nil, ErrInvalidInterval
+	}
+	next := func(prev, now time.Time) time.Time {
+		t := prev.Add(interval)
+		if t.After(now) {
+			return t
+		}
+		return now.Add(interval - now.Sub(prev)%interval)
+	}
+	now := tw.clock.Now()
+	return tw.addRecurring(next(now, now), next, key, fn, opts)
+}
+
+// AddCron 按cron 表达式执行任务 ，表达式见 ParseCron ，fn 的参数为空
+// 返回的 Timer 停止后不再执行 ，Reset(d) 把下一次执行改为 d 之后 ，之后按表达式继续
+func (tw *TimeWheel) AddCron(spec string, key interface{}, fn JobFunc, opts ...RecurringOption) (*Timer, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	now := tw.clock.Now()
+	first := schedule.Next(now)
+	if first.IsZero() {
+		return nil, errors.Errorf("ParseCron_err spec never fires %s", spec)
+	}
+	next := func(prev, now time.Time) time.Time {
+		if now.After(prev) {
+			prev = now
+		}
+		return schedule.Next(prev)
+	}
+	return tw.addRecurring(first, next, key, fn, opts)
+}
+
+func (tw *TimeWheel) addRecurring(first time.Time, next func(prev, now time.Time) time.Time, key interface{},
+	fn JobFunc, opts []RecurringOption) (*Timer, error) {
+	r := &recurring{next: next, base: first}
+	for i := 0; i < len(opts); i++ {
+		opts[i](r)
+	}
+	deadline := r.withJitter(first)
+	t := newTimer(tw, &Task{delay: deadline.Sub(tw.clock.Now()), key: key, job: fn, recurring: r}, deadline)
+	if err := tw.register(t); err != nil {
+		return nil, err
+	}
+	tw.sendOp(timerOp{timer: t, deadline: deadline})
+	return t, nil
+}
+
+func (r *recurring) withJitter(t time.Time) time.Time {
+	if r.jitter <= 0 {
+		return t
+	}
+	return t.Add(time.Duration(rand.Int63n(int64(r.jitter))))
+}
+
+// 时间轮协程中执行 ，先放置下一次任务再执行本次 ，执行时间不影响周期
+func (tw *TimeWheel) fireRecurring(task *Task) {
+	t := task.timer
+	if t.state.Load() != task.gen<<1|1 {
+		return
+	}
+	r := task.recurring
+	scheduled := task.deadline
+	now := tw.clock.Now()
+	r.base = r.next(r.base, now)
+	if r.base.IsZero() {
+		// cron 之后不会再触发
+		if t.state.CompareAndSwap(task.gen<<1|1, task.gen<<1) {
+			tw.unregister(t)
+			tw.execute(task, scheduled)
+		}
+		return
+	}
+	task.deadline = r.withJitter(r.base)
+	t.deadline.Store(task.deadline.UnixNano())
+	tw.replaceTask(task)
+	tw.execute(task, scheduled)
+}
+
+// 重新放置周期任务 ，至少在下一个刻度执行 ，避免在正在扫描的槽中重复执行
+func (tw *TimeWheel) replaceTask(task *Task) {
+	if tw.hierarchical {
+		task.expire = int64((task.deadline.Sub(tw.startTime) + tw.interval - 1) / tw.interval)
+		if task.expire <= tw.tick {
+			task.expire = tw.tick + 1
+		}
+		tw.placeTask(task)
+		return
+	}
+	task.delay = task.deadline.Sub(tw.clock.Now())
+	if task.delay < tw.interval {
+		task.delay = tw.interval
+	}
+	pos, circle := tw.getPositionAndCircle(task.delay)
+	task.circle = circle
+	task.bucket = tw.slots[pos]
+	task.elem = task.bucket.PushBack(task)
+}
+
+// 上一次还在执行时跳过 ，返回false
+func (r *recurring) begin(tw *TimeWheel) bool {
+	if !r.skipIfRunning {
+		return true
+	}
+	if !atomic.CompareAndSwapInt32(&r.running, 0, 1) {
+		atomic.AddInt64(&tw.stats.skipped, 1)
+		return false
+	}
+	return true
+}
+
+func (r *recurring) end() {
+	if r.skipIfRunning {
+		atomic.StoreInt32(&r.running, 0)
+	}
+}
@@ -1,8 +1,155 @@
 package ttime
 
-import "time"
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// 常用的时间格式
+const (
+	LayoutDateTime      = "2006-01-02 15:04:05"
+	LayoutDateTimeMilli = "2006-01-02 15:04:05.000"
+	LayoutDate          = "2006-01-02"
+	LayoutTime          = "15:04:05"
+	LayoutCompact       = "20060102150405"
+	LayoutRFC3339       = time.RFC3339
+	LayoutRFC3339Milli  = "2006-01-02T15:04:05.000Z07:00"
+	LayoutCNDateTime    = "2006年01月02日 15:04:05"
+	LayoutCNDate        = "2006年01月02日"
+	LayoutCNMonthDay    = "01月02日"
+)
+
+// ParseAny 依次尝试的格式
+var anyLayouts = []string{
+	LayoutDateTime,
+	LayoutDateTimeMilli,
+	LayoutRFC3339,
+	LayoutRFC3339Milli,
+	time.RFC3339Nano,
+	LayoutDate,
+	LayoutCompact,
+	LayoutCNDateTime,
+	LayoutCNDate,
+}
+
+// ErrUnknownLayout ParseAny 没有匹配的格式
+var ErrUnknownLayout = errors.New("ttime: unknown time layout")
+
+// 时区缓存 ，time.LoadLocation 每次都会读取时区文件
+var locations sync.Map
 
 // Parse 避免time.local 忘记，因为标准库 少了时区，会导致解析时间少了 8小时
 func Parse(layout, value string) (time.Time, error) {
 	return time.ParseInLocation(layout, value, time.Local)
 }
+
+// ParseIn 按指定时区解析 ，loc 为nil 时使用 time.Local
+// 比如按用户设置的时区解析用户输入的时间
+func ParseIn(layout, value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// ParseInZone 按时区名解析 ，比如 "Asia/Shanghai" ，zone 为空时使用 time.Local
+func ParseInZone(layout, value, zone string) (time.Time, error) {
+	loc, err := LoadLocation(zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(layout, value, loc)
+}
+
+// ParseAny 依次尝试常用格式解析 ，带时区的格式使用字符串中的时区 ，其他使用 loc
+func ParseAny(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, layout := range anyLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrUnknownLayout
+}
+
+// LoadLocation 加载并缓存时区 ，name 为空或者 "Local" 时返回 time.Local
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return time.Local, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Format 转换到指定时区后格式化 ，loc 为nil 时使用 time.Local
+func Format(t time.Time, layout string, loc *time.Location) string {
+	if loc == nil {
+		loc = time.Local
+	}
+	return t.In(loc).Format(layout)
+}
+
+// UnixMilli 毫秒时间戳 ，零值返回0
+func UnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// UnixSec 秒时间戳 ，零值返回0
+func UnixSec(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// FromUnixMilli 毫秒时间戳转换为时间 ，0 返回零值
+func FromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// FromUnixSec 秒时间戳转换为时间 ，0 返回零值
+func FromUnixSec(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// 绝对值大于这个值的时间戳按毫秒处理 ，秒时间戳要到 5138 年才会超过
+const unixMilliThreshold = 1e11
+
+// FromUnix 自动识别秒或者毫秒时间戳 ，兼容不同客户端上报的时间
+// 绝对值大于 1e11 按毫秒 ，否则按秒 ，秒时间戳支持到 5138-11-16 ，毫秒时间戳支持 1973-03-03 之后
+// 1966-10-31 到 1973-03-03 之间的毫秒时间戳会被当成秒 ，这类数据请使用 FromUnixMilli、FromUnixSec 明确单位
+func FromUnix(v int64) time.Time {
+	if v > unixMilliThreshold || v < -unixMilliThreshold {
+		return FromUnixMilli(v)
+	}
+	return FromUnixSec(v)
+}
+
+// MilliToSec 毫秒时间戳转换为秒时间戳
+func MilliToSec(ms int64) int64 {
+	return ms / 1000
+}
+
+// SecToMilli 秒时间戳转换为毫秒时间戳
+func SecToMilli(sec int64) int64 {
+	return sec * 1000
+}
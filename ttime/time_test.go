@@ -0,0 +1,207 @@
+package ttime
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestParseInZone(t *testing.T) {
+	cases := []struct {
+		layout, value, zone string
+		want                int64
+		err                 bool
+	}{
+		{LayoutDateTime, "2022-06-01 08:00:00", "Asia/Shanghai", 1654041600, false},
+		{LayoutDateTime, "2022-06-01 00:00:00", "UTC", 1654041600, false},
+		{LayoutDateTime, "2022-06-01 04:00:00", "America/New_York", 1654070400, false},
+		{LayoutCNDateTime, "2022年06月01日 08:00:00", "Asia/Shanghai", 1654041600, false},
+		{LayoutDateTimeMilli, "2022-06-01 08:00:00.250", "Asia/Shanghai", 1654041600, false},
+		{LayoutDateTime, "2022-06-01 08:00:00", "Mars/Olympus", 0, true},
+		{LayoutDate, "2022/06/01", "UTC", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ParseInZone(c.layout, c.value, c.zone)
+		if (err != nil) != c.err {
+			t.Fatalf("ParseInZone(%q, %q) err = %v", c.value, c.zone, err)
+		}
+		if err == nil && got.Unix() != c.want {
+			t.Fatalf("ParseInZone(%q, %q) = %d , want %d", c.value, c.zone, got.Unix(), c.want)
+		}
+	}
+	if loc, _ := LoadLocation(""); loc != time.Local {
+		t.Fatalf("empty zone should be Local")
+	}
+	if a, b := mustLoad(t, "Asia/Tokyo"), mustLoad(t, "Asia/Tokyo"); a != b {
+		t.Fatalf("location not cached")
+	}
+}
+
+func TestParseAny(t *testing.T) {
+	shanghai := mustLoad(t, "Asia/Shanghai")
+	cases := []struct {
+		value string
+		want  int64
+	}{
+		{"2022-06-01 08:00:00", 1654041600000},
+		{"2022-06-01 08:00:00.123", 1654041600123},
+		{"2022-06-01T00:00:00Z", 1654041600000},
+		{"2022-06-01T09:00:00.500+09:00", 1654041600500},
+		{"2022-06-01", 1654012800000},
+		{"20220601080000", 1654041600000},
+		{"2022年06月01日 08:00:00", 1654041600000},
+		{"2022年06月01日", 1654012800000},
+	}
+	for _, c := range cases {
+		got, err := ParseAny(c.value, shanghai)
+		if err != nil || got.UnixMilli() != c.want {
+			t.Fatalf("ParseAny(%q) = %d %v , want %d", c.value, got.UnixMilli(), err, c.want)
+		}
+	}
+	if _, err := ParseAny("yesterday", shanghai); err != ErrUnknownLayout {
+		t.Fatalf("ParseAny bad value err = %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ts := time.Unix(1654041600, int64(250*time.Millisecond))
+	cases := []struct {
+		layout, zone, want string
+	}{
+		{LayoutDateTime, "Asia/Shanghai", "2022-06-01 08:00:00"},
+		{LayoutDateTimeMilli, "UTC", "2022-06-01 00:00:00.250"},
+		{LayoutRFC3339, "Asia/Shanghai", "2022-06-01T08:00:00+08:00"},
+		{LayoutRFC3339Milli, "UTC", "2022-06-01T00:00:00.250Z"},
+		{LayoutCNDate, "America/Los_Angeles", "2022年05月31日"},
+		{LayoutCompact, "Asia/Shanghai", "20220601080000"},
+	}
+	for _, c := range cases {
+		if got := Format(ts, c.layout, mustLoad(t, c.zone)); got != c.want {
+			t.Fatalf("Format(%q, %s) = %q , want %q", c.layout, c.zone, got, c.want)
+		}
+	}
+}
+
+func TestUnix(t *testing.T) {
+	ts := time.Unix(1654041600, int64(123*time.Millisecond))
+	if UnixMilli(ts) != 1654041600123 || UnixSec(ts) != 1654041600 {
+		t.Fatalf("UnixMilli = %d UnixSec = %d", UnixMilli(ts), UnixSec(ts))
+	}
+	if UnixMilli(time.Time{}) != 0 || UnixSec(time.Time{}) != 0 {
+		t.Fatalf("zero time should be 0")
+	}
+	cases := []struct {
+		v    int64
+		want time.Time
+	}{
+		{0, time.Time{}},
+		{1654041600, time.Unix(1654041600, 0)},
+		{1654041600123, time.Unix(1654041600, int64(123*time.Millisecond))},
+		{-1654041600123, time.UnixMilli(-1654041600123)},
+		// 阈值两侧
+		{1e11, time.Unix(1e11, 0)},
+		{1e11 + 1, time.UnixMilli(1e11 + 1)},
+	}
+	for _, c := range cases {
+		if got := FromUnix(c.v); !got.Equal(c.want) {
+			t.Fatalf("FromUnix(%d) = %s , want %s", c.v, got, c.want)
+		}
+	}
+	if !FromUnixMilli(1654041600123).Equal(cases[2].want) || !FromUnixSec(1654041600).Equal(cases[1].want) {
+		t.Fatalf("FromUnixMilli FromUnixSec mismatch")
+	}
+	if MilliToSec(1654041600999) != 1654041600 || SecToMilli(1654041600) != 1654041600000 {
+		t.Fatalf("MilliToSec SecToMilli mismatch")
+	}
+}
+
+func TestBoundary(t *testing.T) {
+	shanghai := mustLoad(t, "Asia/Shanghai")
+	newYork := mustLoad(t, "America/New_York")
+	// 2022-06-01 03:30 UTC ，上海为 6月1日 周三 ，纽约为 5月31日 周二
+	ts := time.Date(2022, 6, 1, 3, 30, 0, 0, time.UTC)
+	const layout = "2006-01-02 15:04:05.000000000 -0700"
+	cases := []struct {
+		name string
+		got  time.Time
+		want string
+	}{
+		{"StartOfDay shanghai", StartOfDay(ts, shanghai), "2022-06-01 00:00:00.000000000 +0800"},
+		{"EndOfDay shanghai", EndOfDay(ts, shanghai), "2022-06-01 23:59:59.999999999 +0800"},
+		{"StartOfDay new york", StartOfDay(ts, newYork), "2022-05-31 00:00:00.000000000 -0400"},
+		{"StartOfDay nil", StartOfDay(ts, nil), "2022-06-01 00:00:00.000000000 +0000"},
+		{"StartOfWeek monday", StartOfWeek(ts, shanghai, time.Monday), "2022-05-30 00:00:00.000000000 +0800"},
+		{"StartOfWeek sunday", StartOfWeek(ts, shanghai, time.Sunday), "2022-05-29 00:00:00.000000000 +0800"},
+		{"EndOfWeek monday", EndOfWeek(ts, shanghai, time.Monday), "2022-06-05 23:59:59.999999999 +0800"},
+		{"StartOfWeek new york", StartOfWeek(ts, newYork, time.Monday), "2022-05-30 00:00:00.000000000 -0400"},
+		{"StartOfMonth new york", StartOfMonth(ts, newYork), "2022-05-01 00:00:00.000000000 -0400"},
+		{"EndOfMonth new york", EndOfMonth(ts, newYork), "2022-05-31 23:59:59.999999999 -0400"},
+		{"EndOfMonth leap", EndOfMonth(time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC), nil), "2024-02-29 23:59:59.999999999 +0000"},
+		// 夏令时开始的那天只有 23 小时
+		{"EndOfDay dst", EndOfDay(time.Date(2022, 3, 13, 12, 0, 0, 0, newYork), nil), "2022-03-13 23:59:59.999999999 -0400"},
+		{"StartOfDay dst", StartOfDay(time.Date(2022, 3, 13, 12, 0, 0, 0, newYork), nil), "2022-03-13 00:00:00.000000000 -0500"},
+	}
+	for _, c := range cases {
+		if got := c.got.Format(layout); got != c.want {
+			t.Fatalf("%s = %s , want %s", c.name, got, c.want)
+		}
+	}
+	if !IsSameDay(ts, ts.Add(10*time.Hour), shanghai) || IsSameDay(ts, ts.Add(21*time.Hour), shanghai) {
+		t.Fatalf("IsSameDay mismatch")
+	}
+	if n := DaysBetween(time.Date(2022, 3, 12, 23, 0, 0, 0, newYork), time.Date(2022, 3, 14, 1, 0, 0, 0, newYork), nil); n != 2 {
+		t.Fatalf("DaysBetween across dst = %d", n)
+	}
+}
+
+func TestLastSeen(t *testing.T) {
+	shanghai := mustLoad(t, "Asia/Shanghai")
+	now := time.Date(2022, 6, 15, 10, 0, 0, 0, shanghai)
+	cases := []struct {
+		ago    time.Duration
+		en, zh string
+	}{
+		{-time.Minute, "just now", "刚刚"},
+		{30 * time.Second, "just now", "刚刚"},
+		{time.Minute, "1 minute ago", "1分钟前"},
+		{5 * time.Minute, "5 minutes ago", "5分钟前"},
+		{59*time.Minute + 59*time.Second, "59 minutes ago", "59分钟前"},
+		{time.Hour, "1 hour ago", "1小时前"},
+		{9*time.Hour + 59*time.Minute, "9 hours ago", "9小时前"},
+		{10*time.Hour + time.Minute, "yesterday", "昨天"},
+		{33 * time.Hour, "yesterday", "昨天"},
+		{35 * time.Hour, "2 days ago", "2天前"},
+		{6 * 24 * time.Hour, "6 days ago", "6天前"},
+		{7 * 24 * time.Hour, "Jun 8", "06月08日"},
+		{200 * 24 * time.Hour, "Nov 27, 2021", "2021年11月27日"},
+	}
+	for _, c := range cases {
+		seen := now.Add(-c.ago).UTC()
+		if got := LastSeen(seen, now, LangEN); got != c.en {
+			t.Fatalf("LastSeen en %s ago = %q , want %q", c.ago, got, c.en)
+		}
+		if got := LastSeen(seen, now, LangZH); got != c.zh {
+			t.Fatalf("LastSeen zh %s ago = %q , want %q", c.ago, got, c.zh)
+		}
+	}
+	// 按 now 的时区计算日期 ，纽约时间已经是前一天
+	newYork := mustLoad(t, "America/New_York")
+	if got := LastSeen(now.Add(-11*time.Hour), now.In(newYork), LangEN); got != "11 hours ago" {
+		t.Fatalf("LastSeen new york = %q", got)
+	}
+	// 跨过零点但在 1 小时内 ，仍按分钟显示
+	midnight := time.Date(2022, 6, 15, 0, 10, 0, 0, shanghai)
+	if got := LastSeen(midnight.Add(-20*time.Minute), midnight, LangZH); got != "20分钟前" {
+		t.Fatalf("LastSeen across midnight = %q", got)
+	}
+	if got := LastSeen(now.Add(-time.Hour), now, Lang("fr")); got != "1 hour ago" {
+		t.Fatalf("LastSeen unknown lang = %q", got)
+	}
+}
@@ -0,0 +1,206 @@
+package ttime
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrTimerExists DuplicateReject 策略下 key 已经存在未触发的定时器
+	ErrTimerExists = errors.New("timer key already exists")
+	// ErrInvalidDelay 延迟时间小于0
+	ErrInvalidDelay = errors.New("timer delay must not be negative")
+)
+
+// DuplicatePolicy AddTimer 时 key 已经存在未触发的定时器的处理策略
+type DuplicatePolicy int
+
+const (
+	// DuplicateReplace 停止旧的定时器 ，使用新的定时器 ，默认
+	DuplicateReplace DuplicatePolicy = iota
+	// DuplicateReject 保留旧的定时器 ，AddTimer 返回 ErrTimerExists
+	DuplicateReject
+)
+
+// WithDuplicatePolicy 设置重复key 的处理策略
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(tw *TimeWheel) {
+		tw.duplicatePolicy = policy
+	}
+}
+
+// Timer AddTimer 返回的定时器句柄 ，可以并发调用
+type Timer struct {
+	tw   *TimeWheel
+	task *Task
+	// 高位为版本号 ，最低位表示是否等待触发 ，每次 Stop、Reset 版本号加1
+	// 时间轮只触发版本号和放置时一致的任务 ，旧的调度不会生效
+	state atomic.Uint64
+	// 到期时间 UnixNano
+	deadline atomic.Int64
+}
+
+func newTimer(tw *TimeWheel, task *Task, deadline time.Time) *Timer {
+	t := &Timer{tw: tw, task: task}
+	task.timer = t
+	t.state.Store(1)
+	t.deadline.Store(deadline.UnixNano())
+	return t
+}
+
+// Key 定时器唯一标识
+func (t *Timer) Key() interface{} {
+	return t.task.key
+}
+
+// Stop 停止定时器 ，返回false 表示定时器已经触发或者已经停止
+func (t *Timer) Stop() bool {
+	if !t.deactivate() {
+		return false
+	}
+	t.tw.sendOp(timerOp{timer: t, stop: true})
+	return true
+}
+
+// 标记为停止并注销key ，不通知时间轮协程
+func (t *Timer) deactivate() bool {
+	for {
+		s := t.state.Load()
+		if s&1 == 0 {
+			return false
+		}
+		if t.state.CompareAndSwap(s, (s>>1+1)<<1) {
+			break
+		}
+	}
+	t.tw.unregister(t)
+	return true
+}
+
+// Reset 把到期时间改为 d 之后 ，比如收到心跳后延后超时时间
+// 只对等待触发的定时器生效 ，返回false 表示定时器已经触发或者已经停止 ，需要重新 AddTimer
+func (t *Timer) Reset(d time.Duration) bool {
+	if d < 0 {
+		d = 0
+	}
+	deadline := t.tw.clock.Now().Add(d)
+	var gen uint64
+	for {
+		s := t.state.Load()
+		if s&1 == 0 {
+			return false
+		}
+		gen = s>>1 + 1
+		if t.state.CompareAndSwap(s, gen<<1|1) {
+			break
+		}
+	}
+	t.deadline.Store(deadline.UnixNano())
+	t.tw.sendOp(timerOp{timer: t, gen: gen, deadline: deadline})
+	return true
+}
+
+// Remaining 距离触发的剩余时间 ，已经触发或者停止时返回0
+func (t *Timer) Remaining() time.Duration {
+	if t.state.Load()&1 == 0 {
+		return 0
+	}
+	d := time.Unix(0, t.deadline.Load()).Sub(t.tw.clock.Now())
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Active 是否等待触发
+func (t *Timer) Active() bool {
+	return t.state.Load()&1 == 1
+}
+
+// 时间轮协程处理的定时器操作 ，添加、重置、停止共用一个channel ，保证按调用顺序执行
+type timerOp struct {
+	timer    *Timer
+	gen      uint64
+	deadline time.Time
+	stop     bool
+	// 读取时间轮状态 ，在之前提交的操作之后执行
+	query func()
+}
+
+// 时间轮协程触发任务 ，定时器在放置之后被停止或者重置时不执行
+func (tw *TimeWheel) fire(task *Task) {
+	if task.recurring != nil {
+		tw.fireRecurring(task)
+		return
+	}
+	t := task.timer
+	if !t.state.CompareAndSwap(task.gen<<1|1, task.gen<<1) {
+		return
+	}
+	tw.unregister(t)
+	tw.execute(task, task.deadline)
+}
+
+// 注册key ，按重复key 策略处理已经存在的定时器
+func (tw *TimeWheel) register(t *Timer) error {
+	key := t.task.key
+	if key == nil {
+		return nil
+	}
+	tw.mu.Lock()
+	old, ok := tw.timerKeys[key]
+	if ok && old.Active() && tw.duplicatePolicy == DuplicateReject {
+		tw.mu.Unlock()
+		return ErrTimerExists
+	}
+	tw.timerKeys[key] = t
+	tw.mu.Unlock()
+	if ok {
+		old.Stop()
+	}
+	return nil
+}
+
+func (tw *TimeWheel) unregister(t *Timer) {
+	key := t.task.key
+	if key == nil {
+		return
+	}
+	tw.mu.Lock()
+	if tw.timerKeys[key] == t {
+		delete(tw.timerKeys, key)
+	}
+	tw.mu.Unlock()
+}
+
+// 时间轮协程中执行 ，放置、重置或者移除任务
+func (tw *TimeWheel) handleOp(op timerOp) {
+	if op.query != nil {
+		op.query()
+		return
+	}
+	task := op.timer.task
+	if task.bucket != nil {
+		task.bucket.Remove(task.elem)
+		task.bucket = nil
+		task.elem = nil
+	}
+	if op.stop {
+		return
+	}
+	// 之后还有 Stop 或者 Reset ，这次的放置已经过期
+	if op.timer.state.Load() != op.gen<<1|1 {
+		return
+	}
+	task.gen = op.gen
+	task.deadline = op.deadline
+	if task.recurring != nil {
+		task.recurring.base = op.deadline
+	}
+	if tw.hierarchical {
+		tw.addLevelTask(task)
+	} else {
+		tw.addTask(task)
+	}
+}
@@ -2,9 +2,11 @@ package ttime
 
 import (
 	"container/list"
-	"fmt"
 	"runtime"
+	"sync"
 	"time"
+
+	"github.com/heyehang/go-im-pkg/util"
 )
 
 // JobFunc 延时任务回调函数
@@ -15,15 +17,38 @@ type Option func(tw *TimeWheel)
 // TimeWheel 时间轮
 type TimeWheel struct {
 	interval time.Duration // 指针每隔多久往前移动一格
-	ticker   *time.Ticker
+	ticker   Ticker
 	slots    []*list.List // 时间轮槽
-	// key: 定时器唯一标识 value: 定时器所在的槽, 主要用于删除定时器, 不会出现并发读写，不加锁直接访问
-	timerKeys         map[interface{}]int
-	currentPos        int              // 当前指针指向哪一个槽
-	slotNum           int              // 槽数量
-	addTaskChannel    chan Task        // 新增任务channel
-	removeTaskChannel chan interface{} // 删除任务channel
-	stopChannel       chan bool        // 停止定时器channel
+	// key: 定时器唯一标识 value: 定时器 ，主要用于删除定时器和处理重复key
+	timerKeys       map[interface{}]*Timer
+	mu              sync.Mutex
+	duplicatePolicy DuplicatePolicy
+	currentPos      int               // 当前指针指向哪一个槽
+	slotNum         int               // 槽数量
+	opChannel       chan timerOp      // 新增、重置、删除任务channel
+	stopChannel     chan chan []*Task // 停止定时器channel ，返回未触发的任务
+	// 保护启动停止 ，运行中的查询持有读锁
+	lifeMu  sync.RWMutex
+	running bool
+	// 停止期间提交的操作 ，启动时按顺序处理 ，lifeMu 保护
+	stoppedOps []timerOp
+
+	// 多层时间轮模式 ，见 WithHierarchical
+	hierarchical bool
+	levels       []*wheelLevel
+	// 启动后经过的刻度数
+	tick      int64
+	startTime time.Time
+	clock     Clock
+
+	// 回调执行 ，见 executor.go
+	executor      Executor
+	submitMu      sync.Mutex
+	submitQueue   []func()
+	submitting    bool
+	panicHandler  util.PanicErr
+	lateThreshold time.Duration
+	stats         timeWheelStats
 }
 
 // Task 延时任务
@@ -33,47 +58,65 @@ type Task struct {
 	key    interface{}   // 定时器唯一标识, 用于删除定时器
 	job    JobFunc       // 回调函数
 	args   []interface{} // 回调函数参数
+
+	timer     *Timer
+	recurring *recurring    // 周期任务 ，见 AddTicker AddCron
+	gen       uint64        // 放置时定时器的版本号
+	deadline  time.Time     // 到期时间
+	expire    int64         // 多层时间轮模式下到期的刻度
+	bucket    *list.List    // 所在的槽
+	elem      *list.Element // 在槽中的位置 ，用于O(1) 删除
 }
 
 // NewTimeWheel New 创建时间轮
 // 初始化时间轮
 // interval 第一个参数为tick刻度, 即时间轮多久转动一次
 // slotNum 第二个参数为时间轮槽slot数量
-// interval 精确到秒 , 精度越高越耗性能 ，建议到秒级别
+// interval 精确到秒 , 精度越高越耗性能 ，建议到秒级别 ，需要毫秒级精度请使用 WithHierarchical
 // 使用步骤 1、newtimewheel  2、start 3、addtimer 4 stop
 func NewTimeWheel(opts ...Option) *TimeWheel {
 	tw := &TimeWheel{
-		timerKeys:         make(map[interface{}]int, 10),
-		currentPos:        0,
-		addTaskChannel:    make(chan Task, 10),
-		removeTaskChannel: make(chan interface{}, 10),
-		stopChannel:       make(chan bool, 1),
+		timerKeys:   make(map[interface{}]*Timer, 10),
+		currentPos:  0,
+		opChannel:   make(chan timerOp, 10),
+		stopChannel: make(chan chan []*Task),
 	}
 	// 设置其他参数
 	for i := 0; i < len(opts); i++ {
 		opts[i](tw)
 	}
 	// 默认
-	if len(opts) == 0 {
-		tw.interval = time.Second
-		tw.slotNum = 3600
-		tw.slots = make([]*list.List, tw.slotNum)
-	}
 	if tw.slotNum <= 10 {
 		tw.slotNum = 3600
 	}
-	if tw.interval <= 0 {
-		tw.interval = time.Second
+	if tw.hierarchical {
+		if tw.interval <= 0 {
+			tw.interval = DefaultHierarchicalInterval
+		} else if tw.interval < time.Millisecond {
+			tw.interval = time.Millisecond
+		}
+		tw.levels = []*wheelLevel{newWheelLevel(1, tw.slotNum)}
+	} else {
+		if tw.interval < time.Second {
+			tw.interval = time.Second
+		}
+		// 初始化槽，每个槽指向一个双向链表
+		tw.slots = make([]*list.List, tw.slotNum)
+		for i := 0; i < tw.slotNum; i++ {
+			tw.slots[i] = list.New()
+		}
+	}
+	if tw.clock == nil {
+		tw.clock = NewRealClock()
 	}
-	// 初始化槽，每个槽指向一个双向链表
-	for i := 0; i < tw.slotNum; i++ {
-		tw.slots[i] = list.New()
+	if tw.lateThreshold <= 0 {
+		tw.lateThreshold = tw.interval
 	}
 
 	return tw
 }
 
-// WithSlotNum 设置槽的数量
+// WithSlotNum 设置槽的数量 ，多层时间轮模式下为每一层的槽数量
 func WithSlotNum(slotNum int) Option {
 	return func(tw *TimeWheel) {
 		if slotNum < 10 {
@@ -81,37 +124,30 @@ func WithSlotNum(slotNum int) Option {
 		} else {
 			tw.slotNum = slotNum
 		}
-		tw.slots = make([]*list.List, tw.slotNum)
 	}
 }
 
+// WithInterval 设置刻度 ，最小为1秒 ，多层时间轮模式下最小为1毫秒
 func WithInterval(interval time.Duration) Option {
 	return func(tw *TimeWheel) {
-		if interval < time.Second {
-			tw.interval = time.Second
-		} else {
-			tw.interval = interval
-		}
+		tw.interval = interval
 	}
 }
 
-// Start 启动时间轮
-func (tw *TimeWheel) Start() {
-	tw.ticker = time.NewTicker(tw.interval)
-	go tw.start()
-}
-
-// Stop 停止时间轮
-func (tw *TimeWheel) Stop() {
-	tw.stopChannel <- true
-}
-
-// AddTimer 添加定时器 key为定时器唯一标识
-func (tw *TimeWheel) AddTimer(delay time.Duration, key interface{}, callBack JobFunc, args ...interface{}) {
+// AddTimer 添加定时器 key为定时器唯一标识 ，可以为nil
+// key 已经存在未触发的定时器时按 WithDuplicatePolicy 处理 ，默认停止旧的定时器
+// 返回的 Timer 可以停止、重置定时器
+func (tw *TimeWheel) AddTimer(delay time.Duration, key interface{}, callBack JobFunc, args ...interface{}) (*Timer, error) {
 	if delay < 0 {
-		return
+		return nil, ErrInvalidDelay
 	}
-	tw.addTaskChannel <- Task{delay: delay, key: key, job: callBack, args: args}
+	deadline := tw.clock.Now().Add(delay)
+	t := newTimer(tw, &Task{delay: delay, key: key, job: callBack, args: args}, deadline)
+	if err := tw.register(t); err != nil {
+		return nil, err
+	}
+	tw.sendOp(timerOp{timer: t, deadline: deadline})
+	return t, nil
 }
 
 // RemoveTimer 删除定时器 key为添加定时器时传递的定时器唯一标识
@@ -119,23 +155,31 @@ func (tw *TimeWheel) RemoveTimer(key interface{}) {
 	if key == nil {
 		return
 	}
-	tw.removeTaskChannel <- key
+	tw.mu.Lock()
+	t := tw.timerKeys[key]
+	tw.mu.Unlock()
+	if t != nil {
+		t.Stop()
+	}
 }
 
-func (tw *TimeWheel) start() {
-	defer func() {
-		fmt.Println("stop wheel ", time.Now().String())
-	}()
+func (tw *TimeWheel) start(ops []timerOp) {
+	for _, op := range ops {
+		tw.handleOp(op)
+	}
 	for {
 		select {
-		case <-tw.ticker.C:
-			tw.tickHandler()
-		case task := <-tw.addTaskChannel:
-			tw.addTask(&task)
-		case key := <-tw.removeTaskChannel:
-			tw.removeTask(key)
-		case <-tw.stopChannel:
+		case now := <-tw.ticker.C():
+			if tw.hierarchical {
+				tw.advanceTo(now)
+			} else {
+				tw.tickHandler()
+			}
+		case op := <-tw.opChannel:
+			tw.handleOp(op)
+		case reply := <-tw.stopChannel:
 			tw.ticker.Stop()
+			reply <- tw.takeAll()
 			return
 		}
 		runtime.Gosched()
@@ -164,24 +208,25 @@ func (tw *TimeWheel) scanAndRunTask(l *list.List) {
 			e = e.Next()
 			continue
 		}
-		go task.job(task.args...)
 		next := e.Next()
 		l.Remove(e)
-		if task.key != nil {
-			delete(tw.timerKeys, task.key)
-		}
+		task.bucket = nil
+		task.elem = nil
+		tw.fire(task)
 		e = next
 	}
 }
 
-// 新增任务到链表中
+// 新增任务到链表中 ，延迟按到期时间重新计算
 func (tw *TimeWheel) addTask(task *Task) {
+	task.delay = task.deadline.Sub(tw.clock.Now())
+	if task.delay < 0 {
+		task.delay = 0
+	}
 	pos, circle := tw.getPositionAndCircle(task.delay)
 	task.circle = circle
-	tw.slots[pos].PushBack(task)
-	if task.key != nil {
-		tw.timerKeys[task.key] = pos
-	}
+	task.bucket = tw.slots[pos]
+	task.elem = task.bucket.PushBack(task)
 }
 
 // 获取定时器在槽中的位置, 时间轮需要转动的圈数
@@ -196,22 +241,3 @@ func (tw *TimeWheel) getPositionAndCircle(d time.Duration) (pos int, circle int)
 	pos = (tw.currentPos + delaySeconds/intervalSeconds) % tw.slotNum
 	return
 }
-
-// 从链表中删除任务
-func (tw *TimeWheel) removeTask(key interface{}) {
-	// 获取定时器所在的槽
-	position, ok := tw.timerKeys[key]
-	if !ok {
-		return
-	}
-	// 获取槽指向的链表
-	l := tw.slots[position]
-	for e := l.Front(); e != nil; {
-		task := e.Value.(*Task)
-		if task.key == key {
-			delete(tw.timerKeys, task.key)
-			l.Remove(e)
-		}
-		e = e.Next()
-	}
-}
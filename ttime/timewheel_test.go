@@ -1,26 +1,552 @@
 package ttime
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/panjf2000/ants/v2"
 )
 
 func BenchmarkTimeWheel_AddTimer(b *testing.B) {
 	rand.Seed(time.Now().UnixNano())
 	tw := NewTimeWheel(WithInterval(time.Second*1), WithSlotNum(3600))
+	tw.Start()
+	defer tw.Stop(context.Background())
 	for i := 0; i < b.N; i++ {
 		key := fmt.Sprintf("key-%d", i)
 		tw.AddTimer(time.Second*time.Duration(rand.Intn(10)), key, func(kv ...interface{}) {
 			fmt.Println("args ", kv, " now ", time.Now().Unix())
 		}, i)
 	}
-	tw.start()
-	// defer tw.Stop()
 }
 
 func TestParse(t *testing.T) {
 	t1, _ := Parse("2006-01-02 15:04:05", "2020-01-02 13:03:01")
 	fmt.Println(t1)
 }
+
+// 测试中不启动时间轮协程 ，手动处理操作
+func drainOps(tw *TimeWheel) {
+	for len(tw.opChannel) > 0 {
+		tw.handleOp(<-tw.opChannel)
+	}
+	for len(tw.stoppedOps) > 0 {
+		tw.handleOp(takeOp(tw))
+	}
+}
+
+// 取出停止期间暂存的第一个操作
+func takeOp(tw *TimeWheel) timerOp {
+	op := tw.stoppedOps[0]
+	tw.stoppedOps = tw.stoppedOps[1:]
+	return op
+}
+
+// 手动推进指针 ，检查每个任务恰好在到期的刻度执行
+func TestTimeWheel_HierarchicalExpire(t *testing.T) {
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond), WithSlotNum(16))
+	start := time.Now()
+	tw.startTime = start
+	var fired int32
+	done := make(chan struct{}, 2000)
+	job := func(kv ...interface{}) {
+		atomic.AddInt32(&fired, 1)
+		done <- struct{}{}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	timers := make(map[*Timer]int64, 2000)
+	for i := 0; i < 2000; i++ {
+		// 覆盖第1层到第4层
+		ticks := int64(r.Intn(70000))
+		timer := newTimer(tw, &Task{key: i, job: job}, start)
+		if err := tw.register(timer); err != nil {
+			t.Fatal(err)
+		}
+		tw.handleOp(timerOp{timer: timer, deadline: start.Add(time.Duration(ticks) * time.Millisecond)})
+		timers[timer] = ticks
+	}
+	if len(tw.levels) != 5 {
+		t.Fatalf("levels = %d , want 5", len(tw.levels))
+	}
+	// 删除一部分 ，重置一部分
+	i := 0
+	for timer, ticks := range timers {
+		i++
+		switch i % 3 {
+		case 0:
+			tw.RemoveTimer(timer.Key())
+			delete(timers, timer)
+		case 1:
+			ticks = ticks/2 + 1
+			drainOps(tw)
+			timer.Reset(0)
+			// Reset 按当前时间计算 ，测试中改为固定的到期时间
+			op := takeOp(tw)
+			op.deadline = start.Add(time.Duration(ticks) * time.Millisecond)
+			tw.handleOp(op)
+			timers[timer] = ticks
+		}
+	}
+	drainOps(tw)
+	want := int32(len(timers))
+	for tick := int64(1); tick <= 70000; tick++ {
+		tw.advanceTo(start.Add(time.Duration(tick) * time.Millisecond))
+		if tick%97 != 0 && tick != 70000 {
+			continue
+		}
+		for timer, expire := range timers {
+			if timer.Active() != (expire > tick) {
+				t.Fatalf("tick %d key %v expire %d active %v", tick, timer.Key(), expire, timer.Active())
+			}
+		}
+	}
+	if len(tw.timerKeys) != 0 {
+		t.Fatalf("%d timers not fired", len(tw.timerKeys))
+	}
+	for _, l := range tw.levels {
+		for _, b := range l.slots {
+			if b.Len() != 0 {
+				t.Fatalf("slot not empty")
+			}
+		}
+	}
+	// 回调在单独的协程中执行 ，等待全部结束
+	for i := int32(0); i < want; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("fired %d , want %d", atomic.LoadInt32(&fired), want)
+		}
+	}
+	if n := atomic.LoadInt32(&fired); n != want {
+		t.Fatalf("fired %d , want %d", n, want)
+	}
+}
+
+func TestTimeWheel_Hierarchical(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithSlotNum(16), WithClock(clock), WithExecutor(syncExecutor{}))
+	if tw.interval != time.Millisecond*5 {
+		t.Fatalf("interval = %s", tw.interval)
+	}
+	tw.Start()
+	defer tw.Stop(context.Background())
+	clock.BlockUntil(1)
+
+	ch := make(chan int, 10)
+	delays := []time.Duration{0, 10 * time.Millisecond, 45 * time.Millisecond, 120 * time.Millisecond, 400 * time.Millisecond}
+	for i, d := range delays {
+		tw.AddTimer(d, i, func(kv ...interface{}) { ch <- kv[0].(int) }, i)
+	}
+	tw.AddTimer(50*time.Millisecond, "cancel", func(kv ...interface{}) { ch <- -1 })
+	tw.RemoveTimer("cancel")
+
+	// 每次推进一个刻度 ，按推进的时间判断触发时间 ，第一次只等待已经到期的回调
+	fired := 0
+	for elapsed := time.Duration(0); elapsed <= 500*time.Millisecond; elapsed += tw.interval {
+		step := tw.interval
+		if elapsed == 0 {
+			step = 0
+		}
+		advance(clock, tw, step)
+		for len(ch) > 0 {
+			key := <-ch
+			if key < 0 {
+				t.Fatalf("removed timer fired")
+			}
+			if delays[key] != elapsed {
+				t.Fatalf("timer %d delay %s fired after %s", key, delays[key], elapsed)
+			}
+			fired++
+		}
+	}
+	if fired != len(delays) {
+		t.Fatalf("fired = %d , want %d", fired, len(delays))
+	}
+}
+
+func TestNewTimeWheel_Interval(t *testing.T) {
+	if tw := NewTimeWheel(WithInterval(time.Millisecond)); tw.interval != time.Second {
+		t.Fatalf("classic interval = %s , want 1s", tw.interval)
+	}
+	if tw := NewTimeWheel(WithHierarchical()); tw.interval != DefaultHierarchicalInterval {
+		t.Fatalf("hierarchical default interval = %s", tw.interval)
+	}
+	if tw := NewTimeWheel(WithInterval(time.Microsecond), WithHierarchical()); tw.interval != time.Millisecond {
+		t.Fatalf("hierarchical min interval = %s", tw.interval)
+	}
+	if tw := NewTimeWheel(WithInterval(2 * time.Second)); len(tw.slots) != 3600 {
+		t.Fatalf("slots = %d", len(tw.slots))
+	}
+}
+
+func TestTimer_StopResetRemaining(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.Start()
+	defer tw.Stop(context.Background())
+
+	fired := make(chan struct{}, 4)
+	timer, err := tw.AddTimer(100*time.Millisecond, "heartbeat", func(kv ...interface{}) { fired <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := timer.Remaining(); r != 100*time.Millisecond {
+		t.Fatalf("remaining = %s", r)
+	}
+	advance(clock, tw, 60*time.Millisecond)
+	if r := timer.Remaining(); r != 40*time.Millisecond {
+		t.Fatalf("remaining = %s", r)
+	}
+	// 收到心跳 ，延后超时
+	if !timer.Reset(100 * time.Millisecond) {
+		t.Fatalf("reset active timer failed")
+	}
+	advance(clock, tw, 60*time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("timer fired at old deadline")
+	}
+	advance(clock, tw, 35*time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("timer fired before new deadline")
+	}
+	// 重置后在 160ms 触发
+	advance(clock, tw, 5*time.Millisecond)
+	select {
+	case <-fired:
+	default:
+		t.Fatalf("timer not fired")
+	}
+	if timer.Active() || timer.Remaining() != 0 || timer.Stop() || timer.Reset(time.Second) {
+		t.Fatalf("fired timer should be inactive")
+	}
+
+	stopped, _ := tw.AddTimer(20*time.Millisecond, nil, func(kv ...interface{}) { fired <- struct{}{} })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatalf("stop should succeed once")
+	}
+	if stopped.Reset(time.Millisecond) {
+		t.Fatalf("reset stopped timer should fail")
+	}
+	advance(clock, tw, 80*time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatalf("stopped timer fired")
+	}
+
+	if _, err = tw.AddTimer(-time.Second, nil, nil); err != ErrInvalidDelay {
+		t.Fatalf("negative delay err = %v", err)
+	}
+}
+
+func TestTimeWheel_DuplicatePolicy(t *testing.T) {
+	for _, policy := range []DuplicatePolicy{DuplicateReplace, DuplicateReject} {
+		tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithDuplicatePolicy(policy))
+		tw.Start()
+		fired := make(chan int, 4)
+		job := func(kv ...interface{}) { fired <- kv[0].(int) }
+		first, err := tw.AddTimer(30*time.Millisecond, "room", job, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := tw.AddTimer(30*time.Millisecond, "room", job, 2)
+		want := 2
+		if policy == DuplicateReject {
+			want = 1
+			if err != ErrTimerExists || second != nil || !first.Active() {
+				t.Fatalf("reject policy err = %v", err)
+			}
+		} else if err != nil || first.Active() {
+			t.Fatalf("replace policy err = %v , first active %v", err, first.Active())
+		}
+		select {
+		case got := <-fired:
+			if got != want {
+				t.Fatalf("policy %d fired %d , want %d", policy, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timer not fired")
+		}
+		select {
+		case got := <-fired:
+			t.Fatalf("policy %d duplicate fired %d", policy, got)
+		case <-time.After(80 * time.Millisecond):
+		}
+		// 触发后可以再次添加
+		if _, err = tw.AddTimer(time.Second, "room", job, 3); err != nil {
+			t.Fatalf("add after fired err = %v", err)
+		}
+		tw.Stop(context.Background())
+	}
+}
+
+// 经典模式下重复添加再删除 ，所有任务都从槽中移除
+func TestTimeWheel_RemoveTimer(t *testing.T) {
+	tw := NewTimeWheel()
+	for i := 0; i < 3; i++ {
+		if _, err := tw.AddTimer(time.Second*5, "k", func(kv ...interface{}) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, _ := tw.AddTimer(time.Second*5, "other", func(kv ...interface{}) {})
+	drainOps(tw)
+	tw.RemoveTimer("k")
+	drainOps(tw)
+	total := 0
+	for _, l := range tw.slots {
+		total += l.Len()
+	}
+	if total != 1 || !other.Active() {
+		t.Fatalf("%d tasks left in slots", total)
+	}
+	if _, ok := tw.timerKeys["k"]; ok {
+		t.Fatalf("key not removed")
+	}
+}
+
+var _ Executor = (*ants.Pool)(nil)
+
+type testExecutor struct {
+	submitted int32
+	full      bool
+}
+
+func (e *testExecutor) Submit(task func()) error {
+	if e.full {
+		return errors.New("pool full")
+	}
+	atomic.AddInt32(&e.submitted, 1)
+	go task()
+	return nil
+}
+
+func TestTimeWheel_ExecutorAndPanic(t *testing.T) {
+	for _, full := range []bool{false, true} {
+		executor := &testExecutor{full: full}
+		panics := make(chan interface{}, 4)
+		tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithExecutor(executor),
+			WithPanicHandler(func(err any) { panics <- err }))
+		tw.Start()
+		done := make(chan struct{})
+		tw.AddTimer(10*time.Millisecond, "panic", func(kv ...interface{}) { panic("boom") })
+		tw.AddTimer(20*time.Millisecond, "ok", func(kv ...interface{}) { close(done) })
+		select {
+		case err := <-panics:
+			if err != "boom" {
+				t.Fatalf("panic err = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("panic handler not called")
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("timer after panic not fired")
+		}
+		tw.Stop(context.Background())
+		stats := tw.Stats()
+		if stats.Fired != 2 || stats.Panics != 1 {
+			t.Fatalf("stats = %+v", stats)
+		}
+		if full && (stats.Rejected != 2 || executor.submitted != 0) {
+			t.Fatalf("full executor stats = %+v", stats)
+		}
+		if !full && (stats.Rejected != 0 || atomic.LoadInt32(&executor.submitted) != 2) {
+			t.Fatalf("executor submitted %d , stats = %+v", executor.submitted, stats)
+		}
+		if stats.MaxLag < 0 || stats.AvgLag() > stats.MaxLag {
+			t.Fatalf("lag stats = %+v", stats)
+		}
+	}
+}
+
+func TestTimeWheel_LateStats(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond), WithLateThreshold(time.Millisecond*20),
+		WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.startTime = clock.Now()
+	done := make(chan struct{}, 2)
+	job := func(kv ...interface{}) { done <- struct{}{} }
+	tw.AddTimer(time.Millisecond, nil, job)
+	tw.AddTimer(time.Millisecond*200, nil, job)
+	drainOps(tw)
+	// 指针滞后 100ms ，第一个定时器晚触发
+	clock.Advance(time.Millisecond * 100)
+	tw.advanceTo(clock.Now())
+	<-done
+	stats := tw.Stats()
+	if stats.Fired != 1 || stats.Late != 1 || stats.MaxLag != time.Millisecond*99 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestTimeWheel_AddTicker(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*2), WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.Start()
+	defer tw.Stop(context.Background())
+
+	var cnt int32
+	ticker, err := tw.AddTicker(20*time.Millisecond, "sweep", func(kv ...interface{}) { atomic.AddInt32(&cnt, 1) },
+		WithJitter(time.Millisecond*5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	advance(clock, tw, 215*time.Millisecond)
+	if !ticker.Active() || ticker.Remaining() <= 0 {
+		t.Fatalf("ticker should stay active")
+	}
+	ticker.Stop()
+	n := atomic.LoadInt32(&cnt)
+	if n != 10 {
+		t.Fatalf("ticker fired %d times in 215ms , want 10", n)
+	}
+	advance(clock, tw, 50*time.Millisecond)
+	if atomic.LoadInt32(&cnt) != n {
+		t.Fatalf("stopped ticker still fired")
+	}
+
+	if _, err = tw.AddTicker(0, nil, nil); err != ErrInvalidInterval {
+		t.Fatalf("zero interval err = %v", err)
+	}
+}
+
+func TestTimeWheel_TickerSkipIfRunning(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	// 回调在单独的协程中执行 ，阻塞时不影响推进时间
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*2), WithClock(clock))
+	tw.Start()
+	defer tw.Stop(context.Background())
+	clock.BlockUntil(1)
+
+	var running, overlap, cnt int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	ticker, _ := tw.AddTicker(10*time.Millisecond, nil, func(kv ...interface{}) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlap, 1)
+		}
+		atomic.AddInt32(&cnt, 1)
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&running, -1)
+	}, WithSkipIfRunning())
+	defer ticker.Stop()
+
+	advance(clock, tw, 10*time.Millisecond)
+	<-started
+	// 第一次执行还没结束 ，之后的3 个周期跳过
+	advance(clock, tw, 30*time.Millisecond)
+	if n := tw.Stats().Skipped; n != 3 {
+		t.Fatalf("skipped = %d , want 3 , stats = %+v", n, tw.Stats())
+	}
+	release <- struct{}{}
+	// 等待执行结束的标记清除
+	for atomic.LoadInt32(&ticker.task.recurring.running) != 0 {
+		runtime.Gosched()
+	}
+	advance(clock, tw, 10*time.Millisecond)
+	<-started
+	release <- struct{}{}
+	if atomic.LoadInt32(&overlap) != 0 {
+		t.Fatalf("ticker runs overlapped")
+	}
+	if c := atomic.LoadInt32(&cnt); c != 2 {
+		t.Fatalf("ticker ran %d times , want 2", c)
+	}
+}
+
+func TestTimeWheel_AddCron(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, int(300*time.Millisecond), time.Local))
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(syncExecutor{}))
+	tw.Start()
+	defer tw.Stop(context.Background())
+
+	var cnt int32
+	cron, err := tw.AddCron("* * * * * *", "cron", func(kv ...interface{}) { atomic.AddInt32(&cnt, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 每秒的开始执行 ，按推进的时间判断 ，回调中的 clock.Now 可能已经是之后的刻度
+	steps := []struct {
+		d    time.Duration
+		want int32
+	}{
+		{695 * time.Millisecond, 0},
+		{5 * time.Millisecond, 1},
+		{995 * time.Millisecond, 1},
+		{5 * time.Millisecond, 2},
+	}
+	for _, step := range steps {
+		advance(clock, tw, step.d)
+		if n := atomic.LoadInt32(&cnt); n != step.want {
+			t.Fatalf("cron fired %d times at %s , want %d", n, clock.Now().Format("15:04:05.000"), step.want)
+		}
+	}
+	tw.RemoveTimer("cron")
+	if cron.Active() {
+		t.Fatalf("removed cron still active")
+	}
+	if _, err = tw.AddCron("bad spec", nil, nil); err == nil {
+		t.Fatalf("bad spec should fail")
+	}
+}
+
+// 阻塞的池满时时间轮不等待提交 ，回调中可以继续调用 Reset
+func TestTimeWheel_BlockingExecutorReset(t *testing.T) {
+	pool, err := ants.NewPool(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	clock := NewFakeClock(time.Time{})
+	tw := NewTimeWheel(WithHierarchical(), WithInterval(time.Millisecond*5), WithClock(clock), WithExecutor(pool))
+	tw.Start()
+	defer tw.Stop(context.Background())
+	clock.BlockUntil(1)
+
+	// 重置次数超过 opChannel 的容量
+	timers := make([]*Timer, 0, 30)
+	for i := 0; i < 30; i++ {
+		timer, _ := tw.AddTimer(time.Hour, i, func(kv ...interface{}) {})
+		timers = append(timers, timer)
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	var calls int32
+	job := func(kv ...interface{}) {
+		defer wg.Done()
+		// 第一个回调等待下一个回调阻塞在池上
+		if atomic.AddInt32(&calls, 1) == 1 {
+			for pool.Waiting() == 0 {
+				runtime.Gosched()
+			}
+		}
+		for _, timer := range timers {
+			timer.Reset(time.Hour)
+		}
+	}
+	tw.AddTimer(10*time.Millisecond, "a", job)
+	tw.AddTimer(10*time.Millisecond, "b", job)
+	clock.Advance(10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("time wheel blocked by executor")
+	}
+	if tw.Len() != 30 {
+		t.Fatalf("len = %d , want 30", tw.Len())
+	}
+}
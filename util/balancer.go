@@ -0,0 +1,22 @@
+package util
+
+// Balancer 根据key 选择节点 ，不同算法的对比:
+// HashRing 一致性hash 环 ，支持权重、多副本、有界负载 ，查找 O(log n)
+// Rendezvous 最高随机权重hash ，支持权重 ，节点变化只迁移必要的key ，查找 O(n) 适合节点较少的场景
+// JumpBalancer 跳跃一致性hash ，不占内存分布最均匀 ，只适合编号分片 ，只有删除最后一个节点时迁移最少
+// Maglev 查找表 ，查找 O(1) 分布均匀 ，节点变化需要重建查找表 ，迁移比一致性hash 略多
+type Balancer interface {
+	// AddNode 添加节点 ，节点已存在时更新权重
+	AddNode(nodeKey string, weight int)
+	// RemoveNode 删除节点
+	RemoveNode(nodeKey string)
+	// GetNode 获取key 对应的节点 ，没有节点时返回空字符串
+	GetNode(key string) string
+}
+
+var (
+	_ Balancer = (*HashRing)(nil)
+	_ Balancer = (*Rendezvous)(nil)
+	_ Balancer = (*JumpBalancer)(nil)
+	_ Balancer = (*Maglev)(nil)
+)
@@ -0,0 +1,177 @@
+package util
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+var balancerTests = []struct {
+	name string
+	new  func() Balancer
+	// 是否支持权重
+	weighted bool
+	// 每个节点分到的key 数和期望值的最大偏差
+	maxDeviation float64
+}{
+	{"hashring", func() Balancer { return NewHashRing(200, WithFixedSpots(), WithHashFunc(HashXXHash)) }, true, 0.2},
+	{"rendezvous", func() Balancer { return NewRendezvous(nil) }, true, 0.05},
+	{"jump", func() Balancer { return NewJumpBalancer(nil) }, false, 0.05},
+	{"maglev", func() Balancer { return NewMaglev(0, nil) }, true, 0.05},
+}
+
+func balancerNode(i int) string {
+	return "10.0.0." + strconv.Itoa(i) + ":80"
+}
+
+// 统计 keyCnt 个key 在各节点的分布 ，检查和按权重计算的期望值的偏差
+func checkDistribution(t *testing.T, b Balancer, weights map[string]int, keyCnt int, maxDeviation float64) []string {
+	t.Helper()
+	var totalW int
+	for _, w := range weights {
+		totalW += w
+	}
+	placement := make([]string, keyCnt)
+	counts := make(map[string]int, len(weights))
+	for i := 0; i < keyCnt; i++ {
+		node := b.GetNode("key-" + strconv.Itoa(i))
+		if _, ok := weights[node]; !ok {
+			t.Fatalf("key %d placed on unknown node %q", i, node)
+		}
+		placement[i] = node
+		counts[node]++
+	}
+	for node, w := range weights {
+		expect := float64(keyCnt) * float64(w) / float64(totalW)
+		if dev := math.Abs(float64(counts[node])-expect) / expect; dev > maxDeviation {
+			t.Fatalf("node %s got %d keys , expect %.0f , deviation %.3f > %.3f", node, counts[node], expect, dev, maxDeviation)
+		}
+	}
+	return placement
+}
+
+func TestBalancer_Distribution(t *testing.T) {
+	const nodeCnt = 10
+	const keyCnt = 200000
+	for _, tt := range balancerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.new()
+			if b.GetNode("k") != "" {
+				t.Fatalf("empty balancer should return empty node")
+			}
+			weights := make(map[string]int, nodeCnt)
+			for i := 0; i < nodeCnt; i++ {
+				weights[balancerNode(i)] = 1
+				b.AddNode(balancerNode(i), 1)
+			}
+			checkDistribution(t, b, weights, keyCnt, tt.maxDeviation)
+			if !tt.weighted {
+				return
+			}
+			// 一半节点权重为 3
+			for i := 0; i < nodeCnt/2; i++ {
+				weights[balancerNode(i)] = 3
+				b.AddNode(balancerNode(i), 3)
+			}
+			checkDistribution(t, b, weights, keyCnt, tt.maxDeviation)
+		})
+	}
+}
+
+func TestBalancer_Movement(t *testing.T) {
+	const nodeCnt = 10
+	const keyCnt = 100000
+	for _, tt := range balancerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.new()
+			weights := make(map[string]int, nodeCnt+1)
+			for i := 0; i < nodeCnt; i++ {
+				weights[balancerNode(i)] = 1
+				b.AddNode(balancerNode(i), 1)
+			}
+			before := checkDistribution(t, b, weights, keyCnt, tt.maxDeviation)
+
+			// 添加一个节点 ，迁移的key 约为 1/(N+1) ，Maglev 允许少量额外迁移
+			added := balancerNode(nodeCnt)
+			weights[added] = 1
+			b.AddNode(added, 1)
+			after := checkDistribution(t, b, weights, keyCnt, tt.maxDeviation)
+			moved, movedToNew := 0, 0
+			for i := 0; i < keyCnt; i++ {
+				if before[i] != after[i] {
+					moved++
+					if after[i] == added {
+						movedToNew++
+					}
+				}
+			}
+			expect := float64(keyCnt) / (nodeCnt + 1)
+			if float64(moved) > expect*1.5 {
+				t.Fatalf("add node moved %d keys , expect about %.0f", moved, expect)
+			}
+			if float64(movedToNew) < float64(moved)*0.9 {
+				t.Fatalf("add node moved %d keys , only %d to new node", moved, movedToNew)
+			}
+
+			// 删除刚添加的节点 ，恢复原来的分布
+			delete(weights, added)
+			b.RemoveNode(added)
+			restored := checkDistribution(t, b, weights, keyCnt, tt.maxDeviation)
+			for i := 0; i < keyCnt; i++ {
+				if restored[i] != before[i] {
+					t.Fatalf("key %d not restored after remove %s -> %s", i, before[i], restored[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	if JumpHash(1, 0) != -1 {
+		t.Fatalf("JumpHash with no buckets should return -1")
+	}
+	for key := uint64(0); key < 10000; key++ {
+		prev := JumpHash(key, 1)
+		if prev != 0 {
+			t.Fatalf("JumpHash(%d, 1) = %d", key, prev)
+		}
+		// 分片数增加时只会迁移到新分片
+		for n := 2; n <= 32; n++ {
+			b := JumpHash(key, n)
+			if b != prev && int(b) != n-1 {
+				t.Fatalf("JumpHash(%d, %d) = %d moved from %d", key, n, b, prev)
+			}
+			prev = b
+		}
+	}
+}
+
+func TestNextPrime(t *testing.T) {
+	tests := []struct{ n, want int }{{0, 2}, {2, 2}, {4, 5}, {100, 101}, {65537, 65537}}
+	for _, tt := range tests {
+		if got := nextPrime(tt.n); got != tt.want {
+			t.Fatalf("nextPrime(%d) = %d , want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkBalancer_GetNode(b *testing.B) {
+	for _, nodeCnt := range []int{10, 100} {
+		for _, tt := range balancerTests {
+			b.Run(tt.name+"/"+strconv.Itoa(nodeCnt), func(b *testing.B) {
+				bl := tt.new()
+				for i := 0; i < nodeCnt; i++ {
+					bl.AddNode(balancerNode(i), 1)
+				}
+				keys := make([]string, 1024)
+				for i := range keys {
+					keys[i] = "key-" + strconv.Itoa(i)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					bl.GetNode(keys[i&1023])
+				}
+			})
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+
+	"github.com/cespare/xxhash/v2"
 )
 
 const (
@@ -15,20 +17,113 @@ const (
 
 type node struct {
 	nodeKey   string
-	spotValue uint32
+	spotValue uint64
 }
 
 type nodesArray []node
 
-func (p nodesArray) Len() int           { return len(p) }
-func (p nodesArray) Less(i, j int) bool { return p[i].spotValue < p[j].spotValue }
-func (p nodesArray) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p nodesArray) Sort()              { sort.Sort(p) }
+func (p nodesArray) Len() int { return len(p) }
+func (p nodesArray) Less(i, j int) bool {
+	// 位置相同时按节点名排序 ，保证结果和节点添加顺序无关
+	if p[i].spotValue == p[j].spotValue {
+		return p[i].nodeKey < p[j].nodeKey
+	}
+	return p[i].spotValue < p[j].spotValue
+}
+func (p nodesArray) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p nodesArray) Sort()         { sort.Sort(p) }
+
+// HashFunc 计算key 在环上的位置
+type HashFunc func(key string) uint64
+
+var (
+	// HashCRC32 默认hash ，位置只分布在低32 位 ，和旧版本的分布一致
+	HashCRC32 HashFunc = func(key string) uint64 {
+		return uint64(crc32.ChecksumIEEE([]byte(key)))
+	}
+	// HashFNV64 FNV-1a 64 位 ，高位分布较差 ，结果经过 fmix64 打散
+	HashFNV64 HashFunc = func(key string) uint64 {
+		return fmix64(Sum64(key))
+	}
+	// HashXXHash xxhash 64 位 ，速度快 ，分布均匀
+	HashXXHash HashFunc = xxhash.Sum64String
+)
+
+// murmur3 的 fmix64
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+const (
+	HashNameCRC32  = "crc32"
+	HashNameFNV64  = "fnv64"
+	HashNameXXHash = "xxhash"
+)
+
+// 按名字注册的hash 函数 ，快照中记录hash 的名字
+var hashFuncs = map[string]HashFunc{
+	HashNameCRC32:  HashCRC32,
+	HashNameFNV64:  HashFNV64,
+	HashNameXXHash: HashXXHash,
+}
 
-//HashRing 结构体
+// RegisterHashFunc 注册自定义hash 函数 ，之后可以通过 WithHashName 使用并从快照恢复 ，需要在 init 中调用
+func RegisterHashFunc(name string, fn HashFunc) {
+	if name != "" && fn != nil {
+		hashFuncs[name] = fn
+	}
+}
+
+type HashRingOption func(h *HashRing)
+
+// WithHashFunc 指定hash 函数 ，默认 HashCRC32
+// 节点较多时建议使用 64 位的 HashXXHash 或 HashFNV64 ，减少位置冲突
+// 同一个服务的所有进程必须使用相同的hash 函数
+// 快照中不会记录通过该方法指定的hash 的名字 ，需要快照时请使用 WithHashName
+func WithHashFunc(fn HashFunc) HashRingOption {
+	return func(h *HashRing) {
+		if fn != nil {
+			h.hashFunc = fn
+			h.hashName = ""
+		}
+	}
+}
+
+// WithHashName 按名字指定hash 函数 ，名字未注册时不生效
+func WithHashName(name string) HashRingOption {
+	return func(h *HashRing) {
+		if fn, ok := hashFuncs[name]; ok {
+			h.hashFunc = fn
+			h.hashName = name
+		}
+	}
+}
+
+// WithFixedSpots 固定虚拟节点模式 ，每个节点的虚拟节点数 = spots * 权重
+// 添加删除节点不再调整虚拟节点数 ，key 的分布只和当前节点集合有关 ，节点变化时只迁移约 1/N 的key
+func WithFixedSpots() HashRingOption {
+	return func(h *HashRing) {
+		h.fixedSpots = true
+	}
+}
+
+// HashRing 结构体
 type HashRing struct {
-	// 虚拟槽节点数
+	// 虚拟槽节点数 ，固定模式下为每单位权重的虚拟节点数
 	virualSpots int
+	// 是否固定虚拟节点数
+	fixedSpots bool
+	hashFunc   HashFunc
+	hashName   string
+	// 每次节点变化加1
+	version uint64
+	// 节点所在可用区 ，GetNodes 优先选择不同可用区的节点
+	zones map[string]string
 	// 节点数组
 	nodes nodesArray
 	// 权重map
@@ -36,26 +131,35 @@ type HashRing struct {
 	mu      sync.RWMutex
 }
 
-//创建一个hashring
+// 创建一个hashring
 // 建议值  虚拟节点数 =  已知总节点数 * 100 如果知道总节点数为6 个，那么虚拟节点数值 = 6 *100     差值最小，分布最均匀,差值在千分之5以内
-func NewHashRing(spots int) *HashRing {
-	if spots == 0 {
+// 默认模式下添加删除节点会调整虚拟节点数 ，key 的分布和节点添加顺序有关 ，多个实例需要一致的路由请使用 WithFixedSpots
+func NewHashRing(spots int, opts ...HashRingOption) *HashRing {
+	if spots <= 0 {
 		spots = DefaultVirualSpots
 	}
 	h := &HashRing{
 		virualSpots: spots,
 		weights:     make(map[string]int),
+		hashFunc:    HashCRC32,
+		hashName:    HashNameCRC32,
+		zones:       make(map[string]string),
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](h)
 	}
 	return h
 }
 
-//AddNodes 添加一个节点到换上
+// AddNodes 添加一个节点到换上
 func (h *HashRing) AddNodes(nodeWeight map[string]int) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	//// 动态调整虚拟节点数，根据添加节点进行平均
-	h.virualSpots = h.virualSpots + h.virualSpots*len(nodeWeight)
+	if !h.fixedSpots {
+		h.virualSpots = h.virualSpots + h.virualSpots*len(nodeWeight)
+	}
 
 	for nodeKey, w := range nodeWeight {
 		h.weights[nodeKey] = w
@@ -63,22 +167,24 @@ func (h *HashRing) AddNodes(nodeWeight map[string]int) {
 	h.generate()
 }
 
-//AddNode 添加一个节点 ， weight 权重
+// AddNode 添加一个节点 ， weight 权重
 func (h *HashRing) AddNode(nodeKey string, weight int) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	// 动态调整虚拟节点数，根据添加节点进行平均
-	h.virualSpots = h.virualSpots + h.virualSpots*1
+	if !h.fixedSpots {
+		h.virualSpots = h.virualSpots + h.virualSpots*1
+	}
 
 	h.weights[nodeKey] = weight
 	h.generate()
 }
 
-//RemoveNode 移除一个节点
+// RemoveNode 移除一个节点
 func (h *HashRing) RemoveNode(nodeKey string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	if len(h.weights) > 0 {
+	if !h.fixedSpots && len(h.weights) > 0 {
 		// 动态调整虚拟节点数
 		h.virualSpots = h.virualSpots - h.virualSpots/len(h.weights)
 	}
@@ -86,7 +192,52 @@ func (h *HashRing) RemoveNode(nodeKey string) {
 	h.generate()
 }
 
-//UpdateNode 更新一个节点的权重
+// SetNodes 使用新的节点集合替换当前所有节点 ，不调整虚拟节点数
+func (h *HashRing) SetNodes(nodeWeight map[string]int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.weights = make(map[string]int, len(nodeWeight))
+	for nodeKey, w := range nodeWeight {
+		h.weights[nodeKey] = w
+	}
+	h.generate()
+}
+
+// Nodes 当前所有节点及权重
+func (h *HashRing) Nodes() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	nodes := make(map[string]int, len(h.weights))
+	for nodeKey, w := range h.weights {
+		nodes[nodeKey] = w
+	}
+	return nodes
+}
+
+// SetZone 设置节点所在可用区 ，zone 为空时删除
+func (h *HashRing) SetZone(nodeKey, zone string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if zone == "" {
+		delete(h.zones, nodeKey)
+		return
+	}
+	h.zones[nodeKey] = zone
+}
+
+// SetZones 使用新的可用区替换所有节点的可用区
+func (h *HashRing) SetZones(zones map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.zones = make(map[string]string, len(zones))
+	for nodeKey, zone := range zones {
+		if zone != "" {
+			h.zones[nodeKey] = zone
+		}
+	}
+}
+
+// UpdateNode 更新一个节点的权重
 func (h *HashRing) UpdateNode(nodeKey string, weight int) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -95,6 +246,7 @@ func (h *HashRing) UpdateNode(nodeKey string, weight int) {
 }
 
 func (h *HashRing) generate() {
+	h.version++
 	var totalW int
 	for _, w := range h.weights {
 		totalW += w
@@ -107,8 +259,11 @@ func (h *HashRing) generate() {
 	for nodeKey, w := range h.weights {
 		// 计算 单个节点的虚拟节点数  = 当前节点权重值 / 总权重值  * 总虚拟节点数
 		spots := int(math.Floor(float64(w) / float64(totalW) * float64(totalVirtualSpots)))
+		if h.fixedSpots {
+			spots = h.virualSpots * w
+		}
 		for i := 1; i <= spots; i++ {
-			spValue := crc32.Checksum([]byte(nodeKey+":"+strconv.Itoa(i)), crc32.IEEETable)
+			spValue := h.hashFunc(nodeKey + ":" + strconv.Itoa(i))
 			n := node{
 				nodeKey:   nodeKey,
 				spotValue: spValue,
@@ -120,17 +275,211 @@ func (h *HashRing) generate() {
 	h.nodes.Sort()
 }
 
-//GetNode 根据一个key 获取一个节点主机
+// GetNode 根据一个key 获取一个节点主机
 func (h *HashRing) GetNode(key string) string {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	if len(h.nodes) == 0 {
 		return ""
 	}
-	v := crc32.Checksum([]byte(key), crc32.IEEETable)
+	v := h.hash(key)
 	i := sort.Search(len(h.nodes), func(i int) bool { return h.nodes[i].spotValue >= v })
 	if i == len(h.nodes) {
 		i = 0
 	}
 	return h.nodes[i].nodeKey
 }
+
+// GetNodes 从key 的位置顺时针获取 n 个不同的节点 ，第一个节点和 GetNode 一致
+// 设置了可用区时优先选择不同可用区的节点 ，可用区不足 n 个时再按顺序补充同可用区的节点
+// 没有可用区的节点各自视为一个独立的可用区 ，节点数不足 n 时返回所有节点
+func (h *HashRing) GetNodes(key string, n int) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	cnt := len(h.nodes)
+	if cnt == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(h.weights) {
+		n = len(h.weights)
+	}
+	v := h.hash(key)
+	start := sort.Search(cnt, func(i int) bool { return h.nodes[i].spotValue >= v })
+	res := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	usedZones := make(map[string]struct{}, n)
+	// 可用区重复的节点 ，按环上顺序备用
+	var spare []string
+	for k := 0; k < cnt && len(res) < n && len(seen) < len(h.weights); k++ {
+		nodeKey := h.nodes[(start+k)%cnt].nodeKey
+		if _, ok := seen[nodeKey]; ok {
+			continue
+		}
+		seen[nodeKey] = struct{}{}
+		if zone, ok := h.zones[nodeKey]; ok {
+			if _, used := usedZones[zone]; used {
+				spare = append(spare, nodeKey)
+				continue
+			}
+			usedZones[zone] = struct{}{}
+		}
+		res = append(res, nodeKey)
+	}
+	for i := 0; i < len(spare) && len(res) < n; i++ {
+		res = append(res, spare[i])
+	}
+	return res
+}
+
+// GetNodeBounded 有界负载的一致性hash ，参考 Consistent Hashing with Bounded Loads
+// loads 为各节点当前负载 ，c 为负载系数(大于1 ，常用 1.25)
+// 节点容量 = ceil(c * (总负载+1) * 节点权重 / 总权重) ，从key 的位置顺时针查找第一个未超过容量的节点
+// 所有节点都超过容量时返回 GetNode 的结果
+func (h *HashRing) GetNodeBounded(key string, loads map[string]int64, c float64) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := len(h.nodes)
+	if n == 0 {
+		return ""
+	}
+	if c < 1 {
+		c = 1
+	}
+	var totalLoad int64
+	for nodeKey, l := range loads {
+		if _, ok := h.weights[nodeKey]; ok && l > 0 {
+			totalLoad += l
+		}
+	}
+	var totalW int
+	for _, w := range h.weights {
+		totalW += w
+	}
+	v := h.hash(key)
+	start := sort.Search(n, func(i int) bool { return h.nodes[i].spotValue >= v })
+	checked := make(map[string]struct{}, len(h.weights))
+	for k := 0; k < n && len(checked) < len(h.weights); k++ {
+		nodeKey := h.nodes[(start+k)%n].nodeKey
+		if _, ok := checked[nodeKey]; ok {
+			continue
+		}
+		checked[nodeKey] = struct{}{}
+		capacity := math.Ceil(c * float64(totalLoad+1) / float64(len(h.weights)))
+		if totalW > 0 {
+			capacity = math.Ceil(c * float64(totalLoad+1) * float64(h.weights[nodeKey]) / float64(totalW))
+		}
+		if float64(loads[nodeKey]+1) <= capacity {
+			return nodeKey
+		}
+	}
+	return h.nodes[start%n].nodeKey
+}
+
+// Hash key 在环上的位置 ，配合 RingRange 判断key 是否发生迁移
+func (h *HashRing) Hash(key string) uint64 {
+	return h.hash(key)
+}
+
+func (h *HashRing) hash(key string) uint64 {
+	return h.hashFunc(key)
+}
+
+// RingRange 环上的一段区间 [Start, End] ，区间内的key 都落在 Node 上
+type RingRange struct {
+	Start uint64
+	End   uint64
+	Node  string
+}
+
+// Contains 位置是否在区间内
+func (r RingRange) Contains(pos uint64) bool {
+	return pos >= r.Start && pos <= r.End
+}
+
+// RangeMove 区间 [Start, End] 的归属从 From 迁移到 To ，From 为空表示之前没有节点
+type RangeMove struct {
+	Start uint64
+	End   uint64
+	From  string
+	To    string
+}
+
+// Contains 位置是否在区间内
+func (m RangeMove) Contains(pos uint64) bool {
+	return pos >= m.Start && pos <= m.End
+}
+
+// Ranges 按位置排序的所有区间 ，覆盖整个环 ，没有节点时返回nil
+func (h *HashRing) Ranges() []RingRange {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := len(h.nodes)
+	if n == 0 {
+		return nil
+	}
+	ranges := make([]RingRange, 0, n+1)
+	// 第一个节点负责 [0, 第一个位置] 以及最后一个位置之后的部分
+	ranges = append(ranges, RingRange{Start: 0, End: h.nodes[0].spotValue, Node: h.nodes[0].nodeKey})
+	for i := 1; i < n; i++ {
+		// 位置相同时前一个节点生效
+		if h.nodes[i].spotValue == h.nodes[i-1].spotValue {
+			continue
+		}
+		ranges = append(ranges, RingRange{Start: h.nodes[i-1].spotValue + 1, End: h.nodes[i].spotValue, Node: h.nodes[i].nodeKey})
+	}
+	if last := h.nodes[n-1].spotValue; last != math.MaxUint64 {
+		ranges = append(ranges, RingRange{Start: last + 1, End: math.MaxUint64, Node: h.nodes[0].nodeKey})
+	}
+	return ranges
+}
+
+// DiffRanges 比较两次 Ranges 的结果 ，返回归属发生变化的区间 ，相邻且迁移方向相同的区间会合并
+func DiffRanges(old, new []RingRange) []RangeMove {
+	moves := make([]RangeMove, 0, 8)
+	if len(new) == 0 {
+		for i := 0; i < len(old); i++ {
+			moves = appendMove(moves, RangeMove{Start: old[i].Start, End: old[i].End, From: old[i].Node})
+		}
+		return moves
+	}
+	if len(old) == 0 {
+		for i := 0; i < len(new); i++ {
+			moves = appendMove(moves, RangeMove{Start: new[i].Start, End: new[i].End, To: new[i].Node})
+		}
+		return moves
+	}
+	// 两组区间都从0 覆盖到 MaxUint64 ，按位置同时遍历
+	i, j := 0, 0
+	var pos uint64
+	for i < len(old) && j < len(new) {
+		end := old[i].End
+		if new[j].End < end {
+			end = new[j].End
+		}
+		if old[i].Node != new[j].Node {
+			moves = appendMove(moves, RangeMove{Start: pos, End: end, From: old[i].Node, To: new[j].Node})
+		}
+		if end == math.MaxUint64 {
+			break
+		}
+		if old[i].End == end {
+			i++
+		}
+		if new[j].End == end {
+			j++
+		}
+		pos = end + 1
+	}
+	return moves
+}
+
+func appendMove(moves []RangeMove, m RangeMove) []RangeMove {
+	if n := len(moves); n > 0 {
+		last := &moves[n-1]
+		if last.From == m.From && last.To == m.To && last.End+1 == m.Start {
+			last.End = m.End
+			return moves
+		}
+	}
+	return append(moves, m)
+}
@@ -0,0 +1,337 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// 二进制快照的格式版本
+	snapshotBinaryVersion = 1
+)
+
+var (
+	snapshotMagic = []byte("HR")
+	// ErrSnapshotFormat 二进制快照格式错误
+	ErrSnapshotFormat = errors.New("hashring snapshot format error")
+)
+
+// HashRingSnapshot HashRing 的快照 ，可以恢复出位置完全一致的环
+type HashRingSnapshot struct {
+	// 环的版本 ，每次节点变化加1
+	Version uint64 `json:"version"`
+	// 当前虚拟节点数 ，固定模式下为每单位权重的虚拟节点数
+	Spots      int  `json:"spots"`
+	FixedSpots bool `json:"fixed_spots"`
+	// hash 函数名 ，自定义的hash 为空
+	Hash  string                 `json:"hash"`
+	Nodes []HashRingSnapshotNode `json:"nodes"`
+}
+
+// HashRingSnapshotNode 快照中的节点 ，按 Key 排序
+type HashRingSnapshotNode struct {
+	Key    string `json:"key"`
+	Weight int    `json:"weight"`
+	Zone   string `json:"zone,omitempty"`
+}
+
+// Version 环的版本 ，每次节点变化加1
+func (h *HashRing) Version() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.version
+}
+
+// Snapshot 导出当前环的快照
+func (h *HashRing) Snapshot() *HashRingSnapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := &HashRingSnapshot{
+		Version:    h.version,
+		Spots:      h.virualSpots,
+		FixedSpots: h.fixedSpots,
+		Hash:       h.hashName,
+		Nodes:      make([]HashRingSnapshotNode, 0, len(h.weights)),
+	}
+	for nodeKey, w := range h.weights {
+		s.Nodes = append(s.Nodes, HashRingSnapshotNode{Key: nodeKey, Weight: w, Zone: h.zones[nodeKey]})
+	}
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Key < s.Nodes[j].Key })
+	return s
+}
+
+// RestoreHashRing 从快照恢复环 ，opts 在快照配置之后生效
+// 快照的hash 为空或者未注册时需要通过 WithHashFunc 指定 ，否则返回err
+func RestoreHashRing(s *HashRingSnapshot, opts ...HashRingOption) (h *HashRing, err error) {
+	if s == nil {
+		err = errors.Errorf("RestoreHashRing_err snapshot is nil")
+		return
+	}
+	h = NewHashRing(s.Spots)
+	h.fixedSpots = s.FixedSpots
+	h.hashName = ""
+	h.hashFunc = nil
+	if fn, ok := hashFuncs[s.Hash]; ok {
+		h.hashFunc = fn
+		h.hashName = s.Hash
+	}
+	for i := 0; i < len(opts); i++ {
+		opts[i](h)
+	}
+	if h.hashFunc == nil {
+		h = nil
+		err = errors.Errorf("RestoreHashRing_err unknown hash %q , use WithHashFunc", s.Hash)
+		return
+	}
+	for i := 0; i < len(s.Nodes); i++ {
+		h.weights[s.Nodes[i].Key] = s.Nodes[i].Weight
+		if s.Nodes[i].Zone != "" {
+			h.zones[s.Nodes[i].Key] = s.Nodes[i].Zone
+		}
+	}
+	h.generate()
+	h.version = s.Version
+	return
+}
+
+// MarshalBinary 紧凑的二进制格式
+// magic "HR" | 格式版本 | version | spots | fixed | hash | 节点数 | (key | weight | zone)...
+// 整数使用 varint 编码 ，字符串为 长度 + 内容
+func (s *HashRingSnapshot) MarshalBinary() ([]byte, error) {
+	size := len(snapshotMagic) + 1 + 3*binary.MaxVarintLen64 + 1 + len(s.Hash)
+	for i := 0; i < len(s.Nodes); i++ {
+		size += 3*binary.MaxVarintLen64 + len(s.Nodes[i].Key) + len(s.Nodes[i].Zone)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, snapshotMagic...)
+	buf = append(buf, snapshotBinaryVersion)
+	buf = binary.AppendUvarint(buf, s.Version)
+	buf = binary.AppendVarint(buf, int64(s.Spots))
+	if s.FixedSpots {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = appendSnapshotString(buf, s.Hash)
+	buf = binary.AppendUvarint(buf, uint64(len(s.Nodes)))
+	for i := 0; i < len(s.Nodes); i++ {
+		buf = appendSnapshotString(buf, s.Nodes[i].Key)
+		buf = binary.AppendVarint(buf, int64(s.Nodes[i].Weight))
+		buf = appendSnapshotString(buf, s.Nodes[i].Zone)
+	}
+	return buf, nil
+}
+
+func appendSnapshotString(buf []byte, str string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(str)))
+	return append(buf, str...)
+}
+
+// UnmarshalBinary 解析 MarshalBinary 的结果
+func (s *HashRingSnapshot) UnmarshalBinary(data []byte) error {
+	r := &snapshotReader{data: data}
+	magic := r.bytes(len(snapshotMagic))
+	if r.err != nil || string(magic) != string(snapshotMagic) {
+		return ErrSnapshotFormat
+	}
+	if v := r.bytes(1); r.err != nil || v[0] != snapshotBinaryVersion {
+		return errors.Wrapf(ErrSnapshotFormat, "unsupported version")
+	}
+	res := HashRingSnapshot{}
+	res.Version = r.uvarint()
+	res.Spots = int(r.varint())
+	if fixed := r.bytes(1); r.err == nil {
+		res.FixedSpots = fixed[0] == 1
+	}
+	res.Hash = r.string()
+	cnt := r.uvarint()
+	// 每个节点至少3 个字节 ，避免错误数据申请过大的内存
+	if r.err == nil && cnt > uint64(len(r.data)-r.off)/3 {
+		return ErrSnapshotFormat
+	}
+	res.Nodes = make([]HashRingSnapshotNode, 0, cnt)
+	for i := uint64(0); i < cnt && r.err == nil; i++ {
+		n := HashRingSnapshotNode{}
+		n.Key = r.string()
+		n.Weight = int(r.varint())
+		n.Zone = r.string()
+		res.Nodes = append(res.Nodes, n)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if r.off != len(r.data) {
+		return errors.Wrapf(ErrSnapshotFormat, "trailing data")
+	}
+	*s = res
+	return nil
+}
+
+type snapshotReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *snapshotReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data)-r.off < n {
+		r.err = ErrSnapshotFormat
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.off:])
+	if n <= 0 {
+		r.err = ErrSnapshotFormat
+		return 0
+	}
+	r.off += n
+	return v
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.off:])
+	if n <= 0 {
+		r.err = ErrSnapshotFormat
+		return 0
+	}
+	r.off += n
+	return v
+}
+
+func (r *snapshotReader) string() string {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(r.data)-r.off) {
+		r.err = ErrSnapshotFormat
+		return ""
+	}
+	return string(r.bytes(int(n)))
+}
+
+// KeySpaceMove 从 From 迁移到 To 的key 空间占比 ，From 为空表示之前没有节点 ，To 为空表示之后没有节点
+type KeySpaceMove struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Percent float64 `json:"percent"`
+}
+
+// SnapshotDiff 两个快照之间key 空间的迁移情况
+type SnapshotDiff struct {
+	OldVersion uint64 `json:"old_version"`
+	NewVersion uint64 `json:"new_version"`
+	// 发生迁移的key 空间总占比 ，0 - 100
+	Percent float64 `json:"percent"`
+	// 按占比从大到小排序
+	Moves []KeySpaceMove `json:"moves"`
+}
+
+// DiffSnapshots 比较两个快照 ，统计节点之间迁移的key 空间占比
+// 两个快照的hash 不同时所有key 都会重新分布 ，返回err
+func DiffSnapshots(old, new *HashRingSnapshot, opts ...HashRingOption) (diff *SnapshotDiff, err error) {
+	if old == nil || new == nil {
+		err = errors.Errorf("DiffSnapshots_err snapshot is nil")
+		return
+	}
+	if old.Hash != new.Hash {
+		err = errors.Errorf("DiffSnapshots_err hash differs %q != %q", old.Hash, new.Hash)
+		return
+	}
+	oldRing, err := RestoreHashRing(old, opts...)
+	if err != nil {
+		return
+	}
+	newRing, err := RestoreHashRing(new, opts...)
+	if err != nil {
+		return
+	}
+	// crc32 的位置只分布在低32 位
+	space := uint64(math.MaxUint64)
+	if old.Hash == HashNameCRC32 {
+		space = math.MaxUint32
+	}
+	diff = &SnapshotDiff{OldVersion: old.Version, NewVersion: new.Version}
+	idx := make(map[[2]string]int)
+	for _, m := range DiffRanges(oldRing.Ranges(), newRing.Ranges()) {
+		p := rangePercent(m.Start, m.End, space)
+		if p == 0 {
+			continue
+		}
+		k := [2]string{m.From, m.To}
+		i, ok := idx[k]
+		if !ok {
+			i = len(diff.Moves)
+			idx[k] = i
+			diff.Moves = append(diff.Moves, KeySpaceMove{From: m.From, To: m.To})
+		}
+		diff.Moves[i].Percent += p
+		diff.Percent += p
+	}
+	sort.Slice(diff.Moves, func(i, j int) bool {
+		if diff.Moves[i].Percent == diff.Moves[j].Percent {
+			return diff.Moves[i].From+diff.Moves[i].To < diff.Moves[j].From+diff.Moves[j].To
+		}
+		return diff.Moves[i].Percent > diff.Moves[j].Percent
+	})
+	return
+}
+
+// 区间 [start, end] 在 [0, space] 中的百分比
+func rangePercent(start, end, space uint64) float64 {
+	if start > space {
+		return 0
+	}
+	if end > space {
+		end = space
+	}
+	return (float64(end-start) + 1) / (float64(space) + 1) * 100
+}
+
+// Dump 输出环的调试信息 ，包括配置和每个节点的虚拟节点数、key 空间占比
+func (h *HashRing) Dump(w io.Writer) error {
+	s := h.Snapshot()
+	ranges := h.Ranges()
+	space := uint64(math.MaxUint64)
+	if s.Hash == HashNameCRC32 {
+		space = math.MaxUint32
+	}
+	owned := make(map[string]float64, len(s.Nodes))
+	for i := 0; i < len(ranges); i++ {
+		owned[ranges[i].Node] += rangePercent(ranges[i].Start, ranges[i].End, space)
+	}
+	h.mu.RLock()
+	spots := make(map[string]int, len(s.Nodes))
+	for i := 0; i < len(h.nodes); i++ {
+		spots[h.nodes[i].nodeKey]++
+	}
+	h.mu.RUnlock()
+
+	if _, err := fmt.Fprintf(w, "version: %d  spots: %d  fixed: %t  hash: %s  nodes: %d\n",
+		s.Version, s.Spots, s.FixedSpots, s.Hash, len(s.Nodes)); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tWEIGHT\tZONE\tSPOTS\tKEYSPACE\n")
+	for _, n := range s.Nodes {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%.3f%%\n", n.Key, n.Weight, n.Zone, spots[n.Key], owned[n.Key])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,207 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func newSnapshotRing(opts ...HashRingOption) *HashRing {
+	ring := NewHashRing(100, opts...)
+	for i := 0; i < 5; i++ {
+		ring.AddNode("10.0.0."+strconv.Itoa(i)+":80", i%2+1)
+	}
+	ring.SetZone("10.0.0.1:80", "zone-a")
+	return ring
+}
+
+func TestHashRing_SnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []HashRingOption
+	}{
+		{"default", nil},
+		{"fixed-xxhash", []HashRingOption{WithFixedSpots(), WithHashName(HashNameXXHash)}},
+		{"fixed-fnv64", []HashRingOption{WithFixedSpots(), WithHashName(HashNameFNV64)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := newSnapshotRing(tt.opts...)
+			snap := ring.Snapshot()
+
+			jsonData, err := json.Marshal(snap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fromJSON := &HashRingSnapshot{}
+			if err = json.Unmarshal(jsonData, fromJSON); err != nil {
+				t.Fatal(err)
+			}
+			binData, err := snap.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(binData) >= len(jsonData) {
+				t.Fatalf("binary snapshot %d bytes not smaller than json %d bytes", len(binData), len(jsonData))
+			}
+			fromBin := &HashRingSnapshot{}
+			if err = fromBin.UnmarshalBinary(binData); err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range []*HashRingSnapshot{fromJSON, fromBin} {
+				if !reflect.DeepEqual(s, snap) {
+					t.Fatalf("decoded snapshot %+v != %+v", s, snap)
+				}
+				restored, err := RestoreHashRing(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if restored.Version() != ring.Version() {
+					t.Fatalf("restored version %d != %d", restored.Version(), ring.Version())
+				}
+				if !reflect.DeepEqual(restored.Ranges(), ring.Ranges()) {
+					t.Fatalf("restored ring ranges differ")
+				}
+				for i := 0; i < 1000; i++ {
+					key := "key-" + strconv.Itoa(i)
+					if !reflect.DeepEqual(restored.GetNodes(key, 3), ring.GetNodes(key, 3)) {
+						t.Fatalf("key %s restored placement differ", key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreHashRing_CustomHash(t *testing.T) {
+	ring := newSnapshotRing(WithHashFunc(HashXXHash))
+	snap := ring.Snapshot()
+	if snap.Hash != "" {
+		t.Fatalf("custom hash name = %q", snap.Hash)
+	}
+	if _, err := RestoreHashRing(snap); err == nil {
+		t.Fatalf("restore custom hash without WithHashFunc should fail")
+	}
+	restored, err := RestoreHashRing(snap, WithHashFunc(HashXXHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Ranges(), ring.Ranges()) {
+		t.Fatalf("restored ring ranges differ")
+	}
+}
+
+func TestHashRingSnapshot_UnmarshalBinaryErr(t *testing.T) {
+	data, _ := newSnapshotRing().Snapshot().MarshalBinary()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", append([]byte("XX"), data[2:]...)},
+		{"version", append([]byte("HR\x09"), data[3:]...)},
+		{"truncated", data[:len(data)-1]},
+		{"trailing", append(append([]byte{}, data...), 0)},
+		{"huge count", []byte("HR\x01\x01\x02\x00\x00\xff\xff\xff\xff\x0f")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &HashRingSnapshot{}
+			if err := s.UnmarshalBinary(tt.data); errors.Cause(err) != ErrSnapshotFormat {
+				t.Fatalf("UnmarshalBinary err = %v", err)
+			}
+		})
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	ring := NewHashRing(200, WithFixedSpots(), WithHashName(HashNameXXHash))
+	for i := 0; i < 4; i++ {
+		ring.AddNode("node-"+strconv.Itoa(i), 1)
+	}
+	old := ring.Snapshot()
+
+	same, err := DiffSnapshots(old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Percent != 0 || len(same.Moves) != 0 {
+		t.Fatalf("diff of same snapshot = %+v", same)
+	}
+
+	ring.AddNode("node-4", 1)
+	diff, err := DiffSnapshots(old, ring.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.OldVersion != old.Version || diff.NewVersion != ring.Version() {
+		t.Fatalf("diff versions %d %d", diff.OldVersion, diff.NewVersion)
+	}
+	// 新节点接收约 1/5 的key 空间 ，只从旧节点迁移到新节点
+	if diff.Percent < 10 || diff.Percent > 30 {
+		t.Fatalf("moved percent %.2f , expect about 20", diff.Percent)
+	}
+	var sum float64
+	for i, m := range diff.Moves {
+		if m.To != "node-4" || m.From == "" {
+			t.Fatalf("unexpected move %+v", m)
+		}
+		if i > 0 && m.Percent > diff.Moves[i-1].Percent {
+			t.Fatalf("moves not sorted by percent")
+		}
+		sum += m.Percent
+	}
+	if math.Abs(sum-diff.Percent) > 1e-9 {
+		t.Fatalf("moves sum %.4f != total %.4f", sum, diff.Percent)
+	}
+
+	// 按key 抽样验证占比
+	oldRing, _ := RestoreHashRing(old)
+	moved := 0
+	const keyCnt = 100000
+	for i := 0; i < keyCnt; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if oldRing.GetNode(key) != ring.GetNode(key) {
+			moved++
+		}
+	}
+	if sample := float64(moved) / keyCnt * 100; math.Abs(sample-diff.Percent) > 1 {
+		t.Fatalf("sampled moved percent %.2f , diff %.2f", sample, diff.Percent)
+	}
+
+	// crc32 的key 空间为32 位 ，清空所有节点时迁移 100%
+	crc := NewHashRing(10, WithFixedSpots())
+	crc.AddNode("a", 1)
+	before := crc.Snapshot()
+	crc.RemoveNode("a")
+	all, err := DiffSnapshots(before, crc.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(all.Percent-100) > 1e-6 || len(all.Moves) != 1 || all.Moves[0].From != "a" || all.Moves[0].To != "" {
+		t.Fatalf("remove all nodes diff = %+v", all)
+	}
+
+	if _, err = DiffSnapshots(before, old); err == nil {
+		t.Fatalf("diff snapshots with different hash should fail")
+	}
+}
+
+func TestHashRing_Dump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := newSnapshotRing().Dump(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, s := range []string{"hash: crc32", "10.0.0.1:80", "zone-a", "KEYSPACE"} {
+		if !strings.Contains(out, s) {
+			t.Fatalf("dump missing %q\n%s", s, out)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package util
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"strconv"
 	"testing"
 	"time"
@@ -130,3 +132,298 @@ func BenchmarkHashRing_GetNode(b *testing.B) {
 		host1Cnt, host2Cnt, host3Cnt, host4Cnt, host5Cnt, host6Cnt, getnodeEmptyCnt, total, cnt)
 
 }
+
+func TestHashRing_Ranges(t *testing.T) {
+	ring := NewHashRing(100)
+	ring.SetNodes(map[string]int{"10.0.0.1:80": 1, "10.0.0.2:80": 2, "10.0.0.3:80": 1})
+
+	ranges := ring.Ranges()
+	if len(ranges) == 0 || ranges[0].Start != 0 || ranges[len(ranges)-1].End != math.MaxUint64 {
+		t.Fatalf("ranges not cover the ring %+v", ranges)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].Start != ranges[i-1].End+1 {
+			t.Fatalf("ranges not continuous at %d %+v %+v", i, ranges[i-1], ranges[i])
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		pos := ring.Hash(key)
+		idx := sort.Search(len(ranges), func(i int) bool { return ranges[i].End >= pos })
+		if ranges[idx].Node != ring.GetNode(key) {
+			t.Fatalf("key %s range node %s , GetNode %s", key, ranges[idx].Node, ring.GetNode(key))
+		}
+	}
+}
+
+func TestDiffRanges(t *testing.T) {
+	nodes := map[string]int{"10.0.0.1:80": 1, "10.0.0.2:80": 1, "10.0.0.3:80": 1}
+	before := NewHashRing(100)
+	before.SetNodes(nodes)
+	nodes["10.0.0.4:80"] = 1
+	delete(nodes, "10.0.0.1:80")
+	after := NewHashRing(100)
+	after.SetNodes(nodes)
+
+	moves := DiffRanges(before.Ranges(), after.Ranges())
+	if len(moves) == 0 {
+		t.Fatal("want moves")
+	}
+	for i := 0; i < 10000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		from, to := before.GetNode(key), after.GetNode(key)
+		pos := before.Hash(key)
+		var hit *RangeMove
+		for j := range moves {
+			if moves[j].Contains(pos) {
+				hit = &moves[j]
+				break
+			}
+		}
+		if from == to && hit != nil {
+			t.Fatalf("key %s not moved but in move %+v", key, *hit)
+		}
+		if from != to && (hit == nil || hit.From != from || hit.To != to) {
+			t.Fatalf("key %s moved %s -> %s , move %+v", key, from, to, hit)
+		}
+	}
+
+	if got := DiffRanges(before.Ranges(), before.Ranges()); len(got) != 0 {
+		t.Fatalf("same ranges want no moves , got %d", len(got))
+	}
+	if got := DiffRanges(nil, after.Ranges()); len(got) == 0 || got[0].From != "" {
+		t.Fatalf("empty old ranges want moves from nobody , got %+v", got)
+	}
+}
+
+func TestHashRing_FixedSpotsOrderIndependent(t *testing.T) {
+	hosts := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80", "10.0.0.5:80"}
+	a := NewHashRing(100, WithFixedSpots())
+	for i := 0; i < len(hosts); i++ {
+		a.AddNode(hosts[i], 1)
+	}
+	b := NewHashRing(100, WithFixedSpots())
+	for i := len(hosts) - 1; i >= 0; i-- {
+		b.AddNode(hosts[i], 1)
+	}
+	// 添加后删除不影响结果
+	b.AddNode("10.0.0.9:80", 3)
+	b.RemoveNode("10.0.0.9:80")
+	c := NewHashRing(100, WithFixedSpots())
+	c.AddNodes(map[string]int{hosts[0]: 1, hosts[1]: 1, hosts[2]: 1, hosts[3]: 1, hosts[4]: 1})
+
+	for i := 0; i < 100000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		if a.GetNode(key) != b.GetNode(key) || a.GetNode(key) != c.GetNode(key) {
+			t.Fatalf("key %s placement depends on insertion order %s %s %s", key, a.GetNode(key), b.GetNode(key), c.GetNode(key))
+		}
+	}
+}
+
+func TestHashRing_FixedSpotsMinimalMovement(t *testing.T) {
+	const nodeCnt = 10
+	const keyCnt = 100000
+	ring := NewHashRing(200, WithFixedSpots())
+	for i := 0; i < nodeCnt; i++ {
+		ring.AddNode("10.0.0."+strconv.Itoa(i)+":80", 1)
+	}
+	before := make([]string, keyCnt)
+	for i := 0; i < keyCnt; i++ {
+		before[i] = ring.GetNode("user-" + strconv.Itoa(i))
+	}
+
+	// 添加一个节点 ，只有迁移到新节点的key 发生变化 ，数量约为 1/(N+1)
+	newNode := "10.0.0.100:80"
+	ring.AddNode(newNode, 1)
+	moved := 0
+	for i := 0; i < keyCnt; i++ {
+		node := ring.GetNode("user-" + strconv.Itoa(i))
+		if node == before[i] {
+			continue
+		}
+		if node != newNode {
+			t.Fatalf("key moved between old nodes %s -> %s", before[i], node)
+		}
+		moved++
+	}
+	ratio := float64(moved) / keyCnt
+	expect := 1.0 / (nodeCnt + 1)
+	if ratio < expect*0.5 || ratio > expect*1.5 {
+		t.Fatalf("add node moved ratio %.4f , expect about %.4f", ratio, expect)
+	}
+
+	// 删除节点 ，只有原来在该节点上的key 发生变化
+	ring.RemoveNode(newNode)
+	removed := "10.0.0.3:80"
+	ring.RemoveNode(removed)
+	moved = 0
+	for i := 0; i < keyCnt; i++ {
+		node := ring.GetNode("user-" + strconv.Itoa(i))
+		if before[i] != removed && node != before[i] {
+			t.Fatalf("key on %s moved to %s after removing %s", before[i], node, removed)
+		}
+		if before[i] == removed {
+			moved++
+		}
+	}
+	ratio = float64(moved) / keyCnt
+	expect = 1.0 / nodeCnt
+	if ratio < expect*0.5 || ratio > expect*1.5 {
+		t.Fatalf("remove node moved ratio %.4f , expect about %.4f", ratio, expect)
+	}
+}
+
+func TestHashRing_HashFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   HashFunc
+	}{
+		{"crc32", HashCRC32},
+		{"fnv64", HashFNV64},
+		{"xxhash", HashXXHash},
+	}
+	const nodeCnt = 10
+	const keyCnt = 100000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := NewHashRing(200, WithFixedSpots(), WithHashFunc(tt.fn))
+			for i := 0; i < nodeCnt; i++ {
+				ring.AddNode("10.0.0."+strconv.Itoa(i)+":80", 1)
+			}
+			counts := make(map[string]int, nodeCnt)
+			for i := 0; i < keyCnt; i++ {
+				key := "user-" + strconv.Itoa(i)
+				if ring.Hash(key) != tt.fn(key) {
+					t.Fatalf("Hash(%s) not use hash func", key)
+				}
+				counts[ring.GetNode(key)]++
+			}
+			if len(counts) != nodeCnt {
+				t.Fatalf("only %d nodes got keys", len(counts))
+			}
+			avg := float64(keyCnt) / nodeCnt
+			for node, cnt := range counts {
+				if math.Abs(float64(cnt)-avg)/avg > 0.3 {
+					t.Fatalf("node %s got %d keys , avg %.0f", node, cnt, avg)
+				}
+			}
+			ranges := ring.Ranges()
+			if ranges[len(ranges)-1].End != math.MaxUint64 {
+				t.Fatalf("ranges not cover ring")
+			}
+		})
+	}
+}
+
+func TestHashRing_GetNodeBounded(t *testing.T) {
+	ring := NewHashRing(100, WithFixedSpots(), WithHashFunc(HashXXHash))
+	const nodeCnt = 8
+	for i := 0; i < nodeCnt; i++ {
+		ring.AddNode("gw-"+strconv.Itoa(i), 1)
+	}
+	// 没有负载时和 GetNode 一致
+	for i := 0; i < 1000; i++ {
+		key := "room-" + strconv.Itoa(i)
+		if ring.GetNodeBounded(key, nil, 1.25) != ring.GetNode(key) {
+			t.Fatalf("key %s without load should equal GetNode", key)
+		}
+	}
+
+	// 热点key 集中在少数几个房间 ，负载不能超过容量
+	const c = 1.25
+	const keyCnt = 8000
+	loads := make(map[string]int64, nodeCnt)
+	for i := 0; i < keyCnt; i++ {
+		key := "room-" + strconv.Itoa(i%3)
+		loads[ring.GetNodeBounded(key, loads, c)]++
+	}
+	capacity := int64(math.Ceil(c * keyCnt / nodeCnt))
+	for node, l := range loads {
+		if l > capacity {
+			t.Fatalf("node %s load %d over capacity %d", node, l, capacity)
+		}
+	}
+
+	// 权重为2 的节点容量加倍
+	ring.UpdateNode("gw-0", 2)
+	loads = map[string]int64{"gw-0": 300, "gw-1": 200}
+	if node := ring.GetNodeBounded("x", loads, 1); node == "gw-1" {
+		t.Fatalf("gw-1 over capacity should be skipped")
+	}
+}
+
+func TestHashRing_GetNodes(t *testing.T) {
+	ring := NewHashRing(100, WithFixedSpots(), WithHashFunc(HashXXHash))
+	const nodeCnt = 9
+	for i := 0; i < nodeCnt; i++ {
+		ring.AddNode("store-"+strconv.Itoa(i), 1)
+	}
+	if nodes := ring.GetNodes("k", nodeCnt+3); len(nodes) != nodeCnt {
+		t.Fatalf("GetNodes n > node count got %d", len(nodes))
+	}
+	if nodes := ring.GetNodes("k", 0); nodes != nil {
+		t.Fatalf("GetNodes n = 0 got %v", nodes)
+	}
+
+	const keyCnt = 30000
+	const replicas = 3
+	counts := make(map[string]int, nodeCnt)
+	for i := 0; i < keyCnt; i++ {
+		key := "shard-" + strconv.Itoa(i)
+		nodes := ring.GetNodes(key, replicas)
+		if len(nodes) != replicas {
+			t.Fatalf("key %s got %d nodes", key, len(nodes))
+		}
+		if nodes[0] != ring.GetNode(key) {
+			t.Fatalf("key %s first replica %s != GetNode %s", key, nodes[0], ring.GetNode(key))
+		}
+		uniq := make(map[string]struct{}, replicas)
+		for _, node := range nodes {
+			uniq[node] = struct{}{}
+			counts[node]++
+		}
+		if len(uniq) != replicas {
+			t.Fatalf("key %s got duplicate nodes %v", key, nodes)
+		}
+	}
+	// 每个节点承担的副本数接近平均值
+	avg := float64(keyCnt*replicas) / nodeCnt
+	for node, cnt := range counts {
+		if math.Abs(float64(cnt)-avg)/avg > 0.2 {
+			t.Fatalf("node %s got %d replicas , avg %.0f", node, cnt, avg)
+		}
+	}
+
+	// 3 个可用区 ，副本分布在不同可用区
+	zones := make(map[string]string, nodeCnt)
+	for i := 0; i < nodeCnt; i++ {
+		zones["store-"+strconv.Itoa(i)] = "zone-" + strconv.Itoa(i%3)
+	}
+	ring.SetZones(zones)
+	for i := 0; i < keyCnt; i++ {
+		key := "shard-" + strconv.Itoa(i)
+		nodes := ring.GetNodes(key, replicas)
+		used := make(map[string]struct{}, replicas)
+		for _, node := range nodes {
+			used[zones[node]] = struct{}{}
+		}
+		if len(used) != replicas {
+			t.Fatalf("key %s replicas %v not in distinct zones", key, nodes)
+		}
+	}
+	// 可用区不足时补充同可用区的节点
+	for i := 0; i < 100; i++ {
+		key := "shard-" + strconv.Itoa(i)
+		nodes := ring.GetNodes(key, 5)
+		if len(nodes) != 5 || nodes[0] != ring.GetNode(key) {
+			t.Fatalf("key %s got %v", key, nodes)
+		}
+		used := make(map[string]struct{}, 3)
+		for _, node := range nodes[:3] {
+			used[zones[node]] = struct{}{}
+		}
+		if len(used) != 3 {
+			t.Fatalf("key %s first replicas %v not in distinct zones", key, nodes)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"sync"
+)
+
+// JumpHash Jump Consistent Hash ，返回 [0, buckets) 之间的分片编号 ，buckets <= 0 时返回 -1
+// 分片数从 n 增加到 n+1 时只有约 1/(n+1) 的key 迁移到新分片
+func JumpHash(key uint64, buckets int) int32 {
+	if buckets <= 0 {
+		return -1
+	}
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int32(b)
+}
+
+// JumpBalancer 使用 JumpHash 的 Balancer ，节点按添加顺序编号 ，不支持权重
+// 删除最后一个节点只迁移该节点的key ，删除中间的节点时最后一个节点移动到被删除的位置 ，两个节点的key 都会迁移
+type JumpBalancer struct {
+	hashFunc HashFunc
+	mu       sync.RWMutex
+	nodes    []string
+}
+
+// NewJumpBalancer 创建 JumpBalancer ，hashFunc 为nil 时使用 HashXXHash
+func NewJumpBalancer(hashFunc HashFunc) *JumpBalancer {
+	if hashFunc == nil {
+		hashFunc = HashXXHash
+	}
+	return &JumpBalancer{hashFunc: hashFunc}
+}
+
+// AddNode 添加节点到末尾 ，weight 不生效
+func (j *JumpBalancer) AddNode(nodeKey string, weight int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := 0; i < len(j.nodes); i++ {
+		if j.nodes[i] == nodeKey {
+			return
+		}
+	}
+	j.nodes = append(j.nodes, nodeKey)
+}
+
+// RemoveNode 删除节点
+func (j *JumpBalancer) RemoveNode(nodeKey string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	last := len(j.nodes) - 1
+	for i := 0; i <= last; i++ {
+		if j.nodes[i] == nodeKey {
+			j.nodes[i] = j.nodes[last]
+			j.nodes = j.nodes[:last]
+			return
+		}
+	}
+}
+
+// GetNode 获取key 对应的节点
+func (j *JumpBalancer) GetNode(key string) string {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[JumpHash(j.hashFunc(key), len(j.nodes))]
+}
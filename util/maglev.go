@@ -0,0 +1,142 @@
+package util
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	// 默认查找表大小 ，需要是质数并且远大于节点数 ，建议大于 节点数 * 100
+	DefaultMaglevTableSize = 65537
+)
+
+// Maglev 查找表 ，参考 Maglev: A Fast and Reliable Software Network Load Balancer
+// 每个节点按自己的排列依次填充查找表 ，节点权重越大每轮填充的次数越多 ，查找 O(1)
+type Maglev struct {
+	hashFunc  HashFunc
+	tableSize int
+	mu        sync.RWMutex
+	weights   map[string]int
+	// 查找表 ，值为 nodes 的下标
+	table []int
+	nodes []string
+}
+
+// NewMaglev 创建 Maglev ，tableSize <= 0 使用 DefaultMaglevTableSize ，不是质数时取下一个质数
+// hashFunc 为nil 时使用 HashXXHash
+func NewMaglev(tableSize int, hashFunc HashFunc) *Maglev {
+	if tableSize <= 0 {
+		tableSize = DefaultMaglevTableSize
+	}
+	tableSize = nextPrime(tableSize)
+	if hashFunc == nil {
+		hashFunc = HashXXHash
+	}
+	return &Maglev{
+		hashFunc:  hashFunc,
+		tableSize: tableSize,
+		weights:   make(map[string]int),
+	}
+}
+
+// AddNode 添加节点 ，weight <= 0 按1 处理
+func (m *Maglev) AddNode(nodeKey string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.weights[nodeKey] = weight
+	m.populate()
+}
+
+// RemoveNode 删除节点
+func (m *Maglev) RemoveNode(nodeKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.weights, nodeKey)
+	m.populate()
+}
+
+// GetNode 获取key 对应的节点
+func (m *Maglev) GetNode(key string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.nodes) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[m.hashFunc(key)%uint64(m.tableSize)]]
+}
+
+// 重建查找表
+func (m *Maglev) populate() {
+	m.nodes = make([]string, 0, len(m.weights))
+	maxW := 0
+	for nodeKey, w := range m.weights {
+		m.nodes = append(m.nodes, nodeKey)
+		if w > maxW {
+			maxW = w
+		}
+	}
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	// 按节点名排序 ，保证结果和添加顺序无关
+	sort.Strings(m.nodes)
+	size := uint64(m.tableSize)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	next := make([]uint64, len(m.nodes))
+	credits := make([]float64, len(m.nodes))
+	for i, nodeKey := range m.nodes {
+		offsets[i] = m.hashFunc(nodeKey) % size
+		skips[i] = fmix64(m.hashFunc(nodeKey))%(size-1) + 1
+	}
+	table := make([]int, m.tableSize)
+	for i := range table {
+		table[i] = -1
+	}
+	filled := 0
+	for filled < m.tableSize {
+		for i, nodeKey := range m.nodes {
+			// 权重最大的节点每轮填充一次 ，其他节点按比例累积
+			credits[i] += float64(m.weights[nodeKey]) / float64(maxW)
+			if credits[i] < 1 {
+				continue
+			}
+			credits[i]--
+			c := (offsets[i] + next[i]*skips[i]) % size
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % size
+			}
+			table[c] = i
+			next[i]++
+			filled++
+			if filled == m.tableSize {
+				break
+			}
+		}
+	}
+	m.table = table
+}
+
+// 大于等于 n 的最小质数
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for i := 2; i*i <= n; i++ {
+			if n%i == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
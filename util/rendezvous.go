@@ -0,0 +1,83 @@
+package util
+
+import (
+	"math"
+	"sync"
+)
+
+// Rendezvous 带权重的最高随机权重(HRW) hash
+// 每个节点对key 计算得分 -weight / ln(hash) ，得分最高的节点胜出 ，删除节点只迁移该节点上的key
+type Rendezvous struct {
+	hashFunc HashFunc
+	mu       sync.RWMutex
+	nodes    []rendezvousNode
+}
+
+type rendezvousNode struct {
+	nodeKey string
+	hash    uint64
+	weight  float64
+}
+
+// NewRendezvous 创建 Rendezvous ，hashFunc 为nil 时使用 HashXXHash
+func NewRendezvous(hashFunc HashFunc) *Rendezvous {
+	if hashFunc == nil {
+		hashFunc = HashXXHash
+	}
+	return &Rendezvous{hashFunc: hashFunc}
+}
+
+// AddNode 添加节点 ，weight <= 0 按1 处理
+func (r *Rendezvous) AddNode(nodeKey string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(r.nodes); i++ {
+		if r.nodes[i].nodeKey == nodeKey {
+			r.nodes[i].weight = float64(weight)
+			return
+		}
+	}
+	r.nodes = append(r.nodes, rendezvousNode{nodeKey: nodeKey, hash: r.hashFunc(nodeKey), weight: float64(weight)})
+}
+
+// RemoveNode 删除节点
+func (r *Rendezvous) RemoveNode(nodeKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < len(r.nodes); i++ {
+		if r.nodes[i].nodeKey == nodeKey {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// GetNode 获取得分最高的节点
+func (r *Rendezvous) GetNode(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.nodes) == 0 {
+		return ""
+	}
+	kh := r.hashFunc(key)
+	best := -1
+	var bestScore float64
+	for i := 0; i < len(r.nodes); i++ {
+		score := r.score(kh, &r.nodes[i])
+		// 得分相同时按节点名比较 ，保证结果和添加顺序无关
+		if best < 0 || score > bestScore || (score == bestScore && r.nodes[i].nodeKey < r.nodes[best].nodeKey) {
+			best = i
+			bestScore = score
+		}
+	}
+	return r.nodes[best].nodeKey
+}
+
+func (r *Rendezvous) score(keyHash uint64, n *rendezvousNode) float64 {
+	// 取高 53 位转为 (0,1) 区间的浮点数
+	u := (float64(fmix64(keyHash^n.hash)>>11) + 0.5) / (1 << 53)
+	return -n.weight / math.Log(u)
+}